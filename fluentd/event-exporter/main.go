@@ -31,7 +31,11 @@ import (
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/contrib/fluentd/event-exporter/sinks/stackdriver"
+	"k8s.io/contrib/fluentd/event-exporter/sinks"
+	_ "k8s.io/contrib/fluentd/event-exporter/sinks/elasticsearch"
+	_ "k8s.io/contrib/fluentd/event-exporter/sinks/jsonl"
+	_ "k8s.io/contrib/fluentd/event-exporter/sinks/stackdriver"
+	_ "k8s.io/contrib/fluentd/event-exporter/sinks/webhook"
 )
 
 var (
@@ -39,17 +43,19 @@ var (
 	sinkOpts           = flag.String("sink-opts", "", "Parameters for configuring sink")
 	prometheusEndpoint = flag.String("prometheus-endpoint", ":80", "Endpoint on which to "+
 		"expose Prometheus http handler")
+	sinkName = flag.String("sink", "stackdriver", "Sink to export events to, one of: "+
+		strings.Join(sinks.SinkFactoryNames(), ", "))
 )
 
 func newSystemStopChannel() chan struct{} {
 	ch := make(chan struct{})
 	go func() {
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 		sig := <-c
 		glog.Infof("Recieved signal %s, terminating", sig.String())
 
-		ch <- struct{}{}
+		close(ch)
 	}()
 
 	return ch
@@ -69,7 +75,11 @@ func main() {
 	defer glog.Flush()
 	flag.Parse()
 
-	sink, err := stackdriver.NewSdSinkFactory().CreateNew(strings.Split(*sinkOpts, " "))
+	sinkFactory, err := sinks.GetSinkFactory(*sinkName)
+	if err != nil {
+		glog.Fatalf("Failed to initialize sink: %v", err)
+	}
+	sink, err := sinkFactory.CreateNew(strings.Split(*sinkOpts, " "))
 	if err != nil {
 		glog.Fatalf("Failed to initialize sink: %v", err)
 	}
@@ -0,0 +1,66 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package batch
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultFlushDelay     = 5 * time.Second
+	defaultMaxBufferSize  = 100
+	defaultMaxConcurrency = 10
+	defaultRetryDelay     = 10 * time.Second
+)
+
+// Config configures how a batching sink groups the events into requests.
+type Config struct {
+	FlushDelay     time.Duration
+	MaxBufferSize  int
+	MaxConcurrency int
+	RetryDelay     time.Duration
+}
+
+// NewConfigFromFlags defines the batching flags on the given flag set and
+// returns the config they are parsed into. Target is the name of the
+// backend used in the flag descriptions.
+func NewConfigFromFlags(fs *flag.FlagSet, target string) *Config {
+	config := &Config{}
+	fs.DurationVar(&config.FlushDelay, "flush-delay", defaultFlushDelay, "Delay after receiving "+
+		"the first event in batch before sending the request to "+target+", if batch "+
+		"doesn't get sent before")
+	fs.IntVar(&config.MaxBufferSize, "max-buffer-size", defaultMaxBufferSize, "Maximum number of events "+
+		"in the request to "+target)
+	fs.IntVar(&config.MaxConcurrency, "max-concurrency", defaultMaxConcurrency, "Maximum number of "+
+		"concurrent requests to "+target)
+	fs.DurationVar(&config.RetryDelay, "retry-delay", defaultRetryDelay, "Delay before retrying "+
+		"a failed request to "+target)
+	return config
+}
+
+// Validate checks that the config allows the sink to make progress.
+func (c *Config) Validate() error {
+	if c.MaxBufferSize <= 0 {
+		return fmt.Errorf("max buffer size must be positive, got %d", c.MaxBufferSize)
+	}
+	if c.MaxConcurrency <= 0 {
+		return fmt.Errorf("max concurrency must be positive, got %d", c.MaxConcurrency)
+	}
+	return nil
+}
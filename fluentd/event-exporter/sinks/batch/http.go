@@ -0,0 +1,85 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package batch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// maxErrorBodyLength limits how much of the response body is included in the
+// errors returned by Send.
+const maxErrorBodyLength = 512
+
+// ParseURL checks that the flag value is an absolute http or https URL.
+func ParseURL(value string) (*url.URL, error) {
+	if value == "" {
+		return nil, fmt.Errorf("url must be specified")
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %q: %v", value, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid url %q: scheme must be http or https", value)
+	}
+	return u, nil
+}
+
+// Send sends the request and returns the response status code and body. An
+// error is returned if the request failed or the response status isn't 2xx.
+// The error is permanent when the status indicates that the request itself is
+// wrong, e.g. malformed or too many entries, so that the batch isn't sent
+// again. The status code is 0 if no response was received.
+func Send(client *http.Client, req *http.Request) (int, []byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, body, nil
+	}
+
+	if len(body) > maxErrorBodyLength {
+		body = body[:maxErrorBodyLength]
+	}
+	err = fmt.Errorf("server responded with %s: %s", resp.Status, body)
+	if IsPermanentStatus(resp.StatusCode) {
+		return resp.StatusCode, nil, NewPermanentError(err)
+	}
+	return resp.StatusCode, nil, err
+}
+
+// IsPermanentStatus returns true if the status code means that the request
+// would be rejected again. Other client errors, e.g. an expired token or a
+// missing index, may be fixed on the server side, so they are retried.
+func IsPermanentStatus(code int) bool {
+	switch code {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
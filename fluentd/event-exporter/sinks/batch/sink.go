@@ -0,0 +1,282 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package batch
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	api_v1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/contrib/fluentd/event-exporter/sinks"
+	"k8s.io/contrib/fluentd/event-exporter/sinks/spool"
+)
+
+var (
+	receivedEntryCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "received_entry_count",
+			Help:      "Number of entries, received by the batching sink",
+			Subsystem: "batch_sink",
+		},
+		[]string{"sink"},
+	)
+
+	successfullySentEntryCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "successfully_sent_entry_count",
+			Help:      "Number of entries, successfully sent by the batching sink",
+			Subsystem: "batch_sink",
+		},
+		[]string{"sink"},
+	)
+
+	droppedEntryCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "dropped_entry_count",
+			Help:      "Number of entries, rejected by the backend of the batching sink",
+			Subsystem: "batch_sink",
+		},
+		[]string{"sink"},
+	)
+)
+
+// Writer sends a batch of events, each encoded as a JSON object, to the
+// backend of the sink.
+type Writer interface {
+	// Write returns an error if the batch wasn't accepted. The batch is sent
+	// again after a delay, unless the error is permanent.
+	Write(entries [][]byte) error
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// NewPermanentError wraps an error returned by a Writer to indicate that the
+// backend rejected the batch and sending it again won't help.
+func NewPermanentError(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent returns whether the error was created by NewPermanentError.
+func IsPermanent(err error) bool {
+	_, ok := err.(*permanentError)
+	return ok
+}
+
+type batchSink struct {
+	name         string
+	entryChannel chan []byte
+	config       *Config
+	writer       Writer
+
+	currentBuffer   [][]byte
+	timer           *time.Timer
+	fakeTimeChannel chan time.Time
+	// Channel for controlling how many requests are being sent at the same
+	// time. Each request adds an object at the start and takes it out upon
+	// completion, so any request over the capacity will lock on addition.
+	concurrencyChannel chan struct{}
+	// Spool where the batches are written before being sent, if it's
+	// enabled. The batches are then sent by MaxConcurrency goroutines reading
+	// from the spool.
+	spool *spool.Spool
+}
+
+// NewSink creates a sink, which encodes the events as JSON and sends them to
+// the writer in batches, through the spool if it's not nil. Name identifies
+// the sink in the logs and metrics.
+func NewSink(name string, writer Writer, config *Config, spool *spool.Spool) sinks.Sink {
+	return &batchSink{
+		name:         name,
+		entryChannel: make(chan []byte, config.MaxBufferSize),
+		config:       config,
+		writer:       writer,
+
+		currentBuffer:      [][]byte{},
+		timer:              nil,
+		fakeTimeChannel:    make(chan time.Time),
+		concurrencyChannel: make(chan struct{}, config.MaxConcurrency),
+		spool:              spool,
+	}
+}
+
+func (s *batchSink) OnAdd(event *api_v1.Event) {
+	s.add(event)
+}
+
+func (s *batchSink) OnUpdate(oldEvent *api_v1.Event, newEvent *api_v1.Event) {
+	s.add(newEvent)
+}
+
+func (s *batchSink) OnDelete(*api_v1.Event) {
+	// Nothing to do here
+}
+
+func (s *batchSink) OnList(*api_v1.EventList) {
+	// Nothing to do here
+}
+
+func (s *batchSink) add(event *api_v1.Event) {
+	receivedEntryCount.WithLabelValues(s.name).Inc()
+
+	entry, err := json.Marshal(event)
+	if err != nil {
+		glog.Warningf("Failed to encode event %+v: %v", event, err)
+		return
+	}
+	s.entryChannel <- entry
+}
+
+func (s *batchSink) Run(stopCh <-chan struct{}) {
+	glog.Infof("Starting %s sink", s.name)
+	if s.spool != nil {
+		s.spool.StartSending(s.config.MaxConcurrency, s.sendSpooledEntries)
+	}
+
+	for {
+		select {
+		case entry := <-s.entryChannel:
+			s.currentBuffer = append(s.currentBuffer, entry)
+			if len(s.currentBuffer) >= s.config.MaxBufferSize {
+				s.flushBuffer()
+			} else if len(s.currentBuffer) == 1 {
+				s.setTimer()
+			}
+		case <-s.getTimerChannel():
+			// The timer may fire after the buffer was flushed because it got full
+			if len(s.currentBuffer) > 0 {
+				s.flushBuffer()
+			}
+		case <-stopCh:
+			if s.spool != nil {
+				s.spool.Shutdown(s.flushPending)
+				return
+			}
+			glog.Infof("%s sink recieved stop signal, waiting for all requests to finish", s.name)
+			for i := 0; i < s.config.MaxConcurrency; i++ {
+				s.concurrencyChannel <- struct{}{}
+			}
+			glog.Infof("All requests finished, exiting %s sink", s.name)
+			return
+		}
+	}
+}
+
+func (s *batchSink) flushBuffer() {
+	entries := s.currentBuffer
+	s.currentBuffer = nil
+	if s.spool != nil {
+		err := s.spoolEntries(entries)
+		if err == nil {
+			return
+		}
+		glog.Warningf("Failed to spool %d entries, sending them directly: %v", len(entries), err)
+	}
+	s.concurrencyChannel <- struct{}{}
+	go s.sendEntries(entries)
+}
+
+func (s *batchSink) sendEntries(entries [][]byte) {
+	s.write(entries)
+
+	<-s.concurrencyChannel
+}
+
+func (s *batchSink) write(entries [][]byte) {
+	glog.V(4).Infof("Sending %d entries to %s", len(entries), s.name)
+
+	for {
+		err := s.writer.Write(entries)
+		if err == nil {
+			successfullySentEntryCount.WithLabelValues(s.name).Add(float64(len(entries)))
+			glog.V(4).Infof("Successfully sent %d entries to %s", len(entries), s.name)
+			break
+		}
+		if IsPermanent(err) {
+			droppedEntryCount.WithLabelValues(s.name).Add(float64(len(entries)))
+			glog.Warningf("Dropping %d entries rejected by %s: %v", len(entries), s.name, err)
+			break
+		}
+		glog.Warningf("Failed to send %d entries to %s: %v", len(entries), s.name, err)
+		time.Sleep(s.config.RetryDelay)
+	}
+}
+
+func (s *batchSink) spoolEntries(entries [][]byte) error {
+	raw := make([]json.RawMessage, len(entries))
+	for i, entry := range entries {
+		raw[i] = entry
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return s.spool.Append(data, len(entries))
+}
+
+// sendSpooledEntries decodes and sends a batch read from the spool.
+func (s *batchSink) sendSpooledEntries(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	entries := make([][]byte, len(raw))
+	for i := range raw {
+		entries[i] = raw[i]
+	}
+	s.write(entries)
+	return nil
+}
+
+// flushPending flushes the entries, which were received but not flushed yet,
+// before the spool is closed.
+func (s *batchSink) flushPending() {
+	for pending := true; pending; {
+		select {
+		case entry := <-s.entryChannel:
+			s.currentBuffer = append(s.currentBuffer, entry)
+		default:
+			pending = false
+		}
+	}
+	if len(s.currentBuffer) > 0 {
+		s.flushBuffer()
+	}
+}
+
+func (s *batchSink) getTimerChannel() <-chan time.Time {
+	if s.timer == nil {
+		return s.fakeTimeChannel
+	}
+	return s.timer.C
+}
+
+func (s *batchSink) setTimer() {
+	if s.timer == nil {
+		s.timer = time.NewTimer(s.config.FlushDelay)
+	} else {
+		s.timer.Reset(s.config.FlushDelay)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package batch
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	api_v1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/contrib/fluentd/event-exporter/sinks/spool"
+)
+
+type fakeWriter struct {
+	writeFunc func([][]byte) error
+}
+
+func (w *fakeWriter) Write(entries [][]byte) error {
+	if w.writeFunc != nil {
+		return w.writeFunc(entries)
+	}
+	return nil
+}
+
+func newTestConfig() *Config {
+	return &Config{
+		FlushDelay:     100 * time.Millisecond,
+		MaxBufferSize:  10,
+		MaxConcurrency: 10,
+		RetryDelay:     10 * time.Millisecond,
+	}
+}
+
+func TestBatchTimeout(t *testing.T) {
+	var writeCalledTimes int32
+	w := &fakeWriter{
+		writeFunc: func([][]byte) error {
+			atomic.AddInt32(&writeCalledTimes, 1)
+			return nil
+		},
+	}
+	s := NewSink("test", w, newTestConfig(), nil)
+	go s.Run(wait.NeverStop)
+
+	s.OnAdd(&api_v1.Event{})
+	time.Sleep(200 * time.Millisecond)
+
+	if atomic.LoadInt32(&writeCalledTimes) != 1 {
+		t.Fatalf("writeCalledTimes = %d, expected 1", writeCalledTimes)
+	}
+}
+
+func TestBatchSizeLimit(t *testing.T) {
+	var writeCalledTimes, writtenEntries int32
+	w := &fakeWriter{
+		writeFunc: func(entries [][]byte) error {
+			atomic.AddInt32(&writeCalledTimes, 1)
+			atomic.AddInt32(&writtenEntries, int32(len(entries)))
+			return nil
+		},
+	}
+	config := newTestConfig()
+	config.FlushDelay = 1 * time.Second
+	s := NewSink("test", w, config, nil)
+	go s.Run(wait.NeverStop)
+
+	for i := 0; i < 15; i++ {
+		s.OnAdd(&api_v1.Event{})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if atomic.LoadInt32(&writeCalledTimes) != 1 {
+		t.Fatalf("writeCalledTimes = %d, expected 1", writeCalledTimes)
+	}
+	if atomic.LoadInt32(&writtenEntries) != 10 {
+		t.Fatalf("writtenEntries = %d, expected 10", writtenEntries)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var writeCalledTimes int32
+	w := &fakeWriter{
+		writeFunc: func([][]byte) error {
+			if atomic.AddInt32(&writeCalledTimes, 1) < 3 {
+				return errors.New("unavailable")
+			}
+			return nil
+		},
+	}
+	s := NewSink("test", w, newTestConfig(), nil)
+	go s.Run(wait.NeverStop)
+
+	s.OnAdd(&api_v1.Event{})
+	time.Sleep(300 * time.Millisecond)
+
+	if atomic.LoadInt32(&writeCalledTimes) != 3 {
+		t.Fatalf("writeCalledTimes = %d, expected 3", writeCalledTimes)
+	}
+}
+
+func TestPermanentError(t *testing.T) {
+	var writeCalledTimes int32
+	w := &fakeWriter{
+		writeFunc: func([][]byte) error {
+			atomic.AddInt32(&writeCalledTimes, 1)
+			return NewPermanentError(errors.New("bad request"))
+		},
+	}
+	s := NewSink("test", w, newTestConfig(), nil)
+	go s.Run(wait.NeverStop)
+
+	s.OnAdd(&api_v1.Event{})
+	time.Sleep(300 * time.Millisecond)
+
+	if atomic.LoadInt32(&writeCalledTimes) != 1 {
+		t.Fatalf("writeCalledTimes = %d, expected 1", writeCalledTimes)
+	}
+}
+
+func TestStop(t *testing.T) {
+	s := NewSink("test", &fakeWriter{}, newTestConfig(), nil)
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stopCh)
+		close(done)
+	}()
+
+	s.OnAdd(&api_v1.Event{})
+	close(stopCh)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the sink to stop")
+	}
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	spoolConfig := &spool.Config{Directory: dir, MaxSize: 1 << 20, SegmentSize: 1 << 10}
+
+	// The backend is unavailable, so the writer doesn't return
+	unavailable := make(chan struct{})
+	defer close(unavailable)
+	w := &fakeWriter{
+		writeFunc: func([][]byte) error {
+			<-unavailable
+			return nil
+		},
+	}
+	sp, err := spool.Open("test", spoolConfig)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	s := NewSink("test", w, newTestConfig(), sp)
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stopCh)
+		close(done)
+	}()
+
+	for i := 0; i < 25; i++ {
+		s.OnAdd(&api_v1.Event{Reason: "Pulled"})
+	}
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the sink to stop")
+	}
+
+	// After a restart, all the entries are sent
+	var writtenEntries int32
+	w = &fakeWriter{
+		writeFunc: func(entries [][]byte) error {
+			for _, entry := range entries {
+				if string(entry) == "" || entry[0] != '{' {
+					t.Errorf("unexpected entry %q", entry)
+				}
+			}
+			atomic.AddInt32(&writtenEntries, int32(len(entries)))
+			return nil
+		},
+	}
+	sp, err = spool.Open("test", spoolConfig)
+	if err != nil {
+		t.Fatalf("failed to reopen spool: %v", err)
+	}
+	s = NewSink("test", w, newTestConfig(), sp)
+	stopCh = make(chan struct{})
+	defer close(stopCh)
+	go s.Run(stopCh)
+
+	for i := 0; atomic.LoadInt32(&writtenEntries) < 25; i++ {
+		if i == 100 {
+			t.Fatalf("writtenEntries = %d, expected 25", atomic.LoadInt32(&writtenEntries))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
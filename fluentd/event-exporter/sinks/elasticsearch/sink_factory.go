@@ -0,0 +1,106 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/contrib/fluentd/event-exporter/sinks"
+	"k8s.io/contrib/fluentd/event-exporter/sinks/batch"
+	"k8s.io/contrib/fluentd/event-exporter/sinks/spool"
+)
+
+const (
+	defaultIndex   = "kubernetes-events"
+	defaultTimeout = 30 * time.Second
+)
+
+type esSinkFactory struct {
+	flagSet      *flag.FlagSet
+	url          *string
+	index        *string
+	docType      *string
+	username     *string
+	passwordFile *string
+	timeout      *time.Duration
+	batchConfig  *batch.Config
+	spoolConfig  *spool.Config
+}
+
+// NewEsSinkFactory creates a new factory of sinks, which index the events in
+// Elasticsearch with the bulk API.
+func NewEsSinkFactory() sinks.SinkFactory {
+	fs := flag.NewFlagSet("elasticsearch", flag.ContinueOnError)
+	return &esSinkFactory{
+		flagSet: fs,
+		url:     fs.String("url", "", "URL of the Elasticsearch cluster, e.g. http://elasticsearch:9200"),
+		index:   fs.String("index", defaultIndex, "Index to store the events in"),
+		docType: fs.String("type", "", "Mapping type of the events, required by Elasticsearch "+
+			"versions before 6 and omitted if empty"),
+		username: fs.String("username", "", "User name for basic authentication"),
+		passwordFile: fs.String("password-file", "", "File with the password for basic "+
+			"authentication"),
+		timeout:     fs.Duration("timeout", defaultTimeout, "Timeout of the requests to Elasticsearch"),
+		batchConfig: batch.NewConfigFromFlags(fs, "Elasticsearch"),
+		spoolConfig: spool.NewConfigFromFlags(fs),
+	}
+}
+
+func (f *esSinkFactory) CreateNew(opts []string) (sinks.Sink, error) {
+	err := f.flagSet.Parse(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sink opts: %v", err)
+	}
+
+	if _, err := batch.ParseURL(*f.url); err != nil {
+		return nil, err
+	}
+	if *f.index == "" {
+		return nil, fmt.Errorf("index must be specified")
+	}
+	if err := f.batchConfig.Validate(); err != nil {
+		return nil, err
+	}
+	if err := f.spoolConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	password := ""
+	if *f.passwordFile != "" {
+		content, err := ioutil.ReadFile(*f.passwordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password: %v", err)
+		}
+		password = strings.TrimSpace(string(content))
+	}
+
+	client := &http.Client{Timeout: *f.timeout}
+	bulkURL := strings.TrimSuffix(*f.url, "/") + "/_bulk"
+	writer := newEsWriter(client, bulkURL, *f.index, *f.docType, *f.username, password)
+
+	sp, err := spool.OpenIfEnabled("elasticsearch", f.spoolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch.NewSink("elasticsearch", writer, f.batchConfig, sp), nil
+}
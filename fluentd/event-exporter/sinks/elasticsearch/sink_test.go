@@ -0,0 +1,124 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	api_v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestEsSink(t *testing.T) {
+	requests := make(chan []api_v1.Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			t.Errorf("path = %q, expected /_bulk", r.URL.Path)
+		}
+		if user, _, _ := r.BasicAuth(); user != "exporter" {
+			t.Errorf("user = %q, expected exporter", user)
+		}
+
+		var events []api_v1.Event
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action bulkAction
+			if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+				t.Errorf("failed to decode action %q: %v", scanner.Text(), err)
+			}
+			if action.Index.Index != "events" || action.Index.Type != "" || action.Index.ID == "" {
+				t.Errorf("unexpected action %+v", action)
+			}
+
+			scanner.Scan()
+			var event api_v1.Event
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Errorf("failed to decode event %q: %v", scanner.Text(), err)
+			}
+			events = append(events, event)
+		}
+		fmt.Fprint(w, `{"took": 3, "errors": false, "items": []}`)
+		requests <- events
+	}))
+	defer server.Close()
+
+	s, err := NewEsSinkFactory().CreateNew([]string{"-url", server.URL + "/", "-index", "events",
+		"-username", "exporter", "-flush-delay", "10ms"})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	go s.Run(wait.NeverStop)
+
+	s.OnAdd(&api_v1.Event{Reason: "Pulled"})
+	s.OnUpdate(&api_v1.Event{Reason: "Pulled", Count: 1}, &api_v1.Event{Reason: "Pulled", Count: 2})
+
+	select {
+	case events := <-requests:
+		if len(events) != 2 || events[1].Count != 2 {
+			t.Errorf("received %+v, expected both events", events)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a bulk request")
+	}
+}
+
+func TestEsWriterItemErrors(t *testing.T) {
+	testcases := map[string]struct {
+		response  string
+		expectErr bool
+	}{
+		"no errors": {
+			response: `{"errors": false, "items": [{"index": {"status": 201}}]}`,
+		},
+		"rejected": {
+			response: `{"errors": true, "items": [{"index": {"status": 201}},
+				{"index": {"status": 400, "error": {"type": "mapper_parsing_exception"}}}]}`,
+		},
+		"overloaded": {
+			response: `{"errors": true, "items": [{"index": {"status": 400}},
+				{"index": {"status": 429, "error": {"type": "es_rejected_execution_exception"}}}]}`,
+			expectErr: true,
+		},
+		"read-only index": {
+			response:  `{"errors": true, "items": [{"index": {"status": 403, "error": {"type": "cluster_block_exception"}}}]}`,
+			expectErr: true,
+		},
+		"invalid response": {
+			response:  `<html>`,
+			expectErr: true,
+		},
+	}
+
+	for name, tc := range testcases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, tc.response)
+		}))
+		w := newEsWriter(http.DefaultClient, server.URL+"/_bulk", "events", "", "", "")
+		err := w.Write([][]byte{[]byte("{}"), []byte(`{"reason": "Pulled"}`)})
+		server.Close()
+
+		if (err != nil) != tc.expectErr {
+			t.Errorf("%s: err = %v, expected error: %v", name, err, tc.expectErr)
+		}
+	}
+}
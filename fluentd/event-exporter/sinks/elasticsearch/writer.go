@@ -0,0 +1,168 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package elasticsearch
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/contrib/fluentd/event-exporter/sinks/batch"
+)
+
+var (
+	requestCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "request_count",
+			Help:      "Number of request, issued to the Elasticsearch bulk API",
+			Subsystem: "elasticsearch_sink",
+		},
+		[]string{"code"},
+	)
+
+	rejectedEntryCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name:      "rejected_entry_count",
+			Help:      "Number of entries, rejected by Elasticsearch in a successful bulk request",
+			Subsystem: "elasticsearch_sink",
+		},
+	)
+)
+
+type bulkAction struct {
+	Index bulkIndex `json:"index"`
+}
+
+type bulkIndex struct {
+	Index string `json:"_index"`
+	Type  string `json:"_type,omitempty"`
+	ID    string `json:"_id"`
+}
+
+type bulkResponse struct {
+	Errors bool                     `json:"errors"`
+	Items  []map[string]bulkItemRes `json:"items"`
+}
+
+type bulkItemRes struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// esWriter indexes each batch of events with a request to the bulk API.
+type esWriter struct {
+	client   *http.Client
+	url      string
+	index    string
+	docType  string
+	username string
+	password string
+}
+
+func newEsWriter(client *http.Client, url string, index string, docType string, username string, password string) batch.Writer {
+	return &esWriter{
+		client:   client,
+		url:      url,
+		index:    index,
+		docType:  docType,
+		username: username,
+		password: password,
+	}
+}
+
+func (w *esWriter) Write(entries [][]byte) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, entry := range entries {
+		// The id is derived from the content, so that sending the batch again
+		// after a partial failure doesn't index the same event twice.
+		sum := sha1.Sum(entry)
+		action := bulkAction{Index: bulkIndex{Index: w.index, Type: w.docType, ID: hex.EncodeToString(sum[:])}}
+		if err := encoder.Encode(action); err != nil {
+			return batch.NewPermanentError(err)
+		}
+		body.Write(entry)
+		body.WriteString("\n")
+	}
+
+	req, err := http.NewRequest("POST", w.url, &body)
+	if err != nil {
+		return batch.NewPermanentError(err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	code, resBody, err := batch.Send(w.client, req)
+	if code != 0 {
+		requestCount.WithLabelValues(strconv.Itoa(code)).Inc()
+	}
+	if err != nil {
+		return err
+	}
+
+	return w.checkItems(resBody)
+}
+
+// checkItems returns an error if Elasticsearch failed to index some of the
+// entries for a reason that may go away, e.g. it's overloaded or the index is
+// read-only. Entries rejected because of their content, e.g. a mapping
+// conflict, are logged and dropped.
+func (w *esWriter) checkItems(resBody []byte) error {
+	var res bulkResponse
+	if err := json.Unmarshal(resBody, &res); err != nil {
+		return fmt.Errorf("failed to parse bulk response: %v", err)
+	}
+	if !res.Errors {
+		return nil
+	}
+
+	retriable, rejected := 0, 0
+	var firstErr json.RawMessage
+	for _, item := range res.Items {
+		for _, r := range item {
+			if r.Status < 300 {
+				continue
+			}
+			if firstErr == nil {
+				firstErr = r.Error
+			}
+			if batch.IsPermanentStatus(r.Status) {
+				rejected++
+			} else {
+				retriable++
+			}
+		}
+	}
+
+	if retriable > 0 {
+		return fmt.Errorf("failed to index %d entries: %s", retriable+rejected, firstErr)
+	}
+	if rejected > 0 {
+		rejectedEntryCount.Add(float64(rejected))
+		glog.Warningf("Elasticsearch rejected %d entries: %s", rejected, firstErr)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonl
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/golang/glog"
+
+	api_v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// jsonlSink writes each event as a JSON object on its own line.
+type jsonlSink struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	// closer is closed when the sink stops, nil if the output shouldn't be
+	// closed, like stdout.
+	closer io.Closer
+	closed bool
+}
+
+func newJSONLSink(w io.Writer, closer io.Closer) *jsonlSink {
+	return &jsonlSink{
+		encoder: json.NewEncoder(w),
+		closer:  closer,
+	}
+}
+
+func (s *jsonlSink) OnAdd(event *api_v1.Event) {
+	s.write(event)
+}
+
+func (s *jsonlSink) OnUpdate(oldEvent *api_v1.Event, newEvent *api_v1.Event) {
+	s.write(newEvent)
+}
+
+func (s *jsonlSink) OnDelete(*api_v1.Event) {
+	// Nothing to do here
+}
+
+func (s *jsonlSink) OnList(*api_v1.EventList) {
+	// Nothing to do here
+}
+
+func (s *jsonlSink) write(event *api_v1.Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return
+	}
+	if err := s.encoder.Encode(event); err != nil {
+		glog.Warningf("Failed to write event %+v: %v", event, err)
+	}
+}
+
+func (s *jsonlSink) Run(stopCh <-chan struct{}) {
+	glog.Info("Starting JSON lines sink")
+	<-stopCh
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	if s.closer != nil {
+		if err := s.closer.Close(); err != nil {
+			glog.Warningf("Failed to close the output: %v", err)
+		}
+	}
+	glog.Info("Exiting JSON lines sink")
+}
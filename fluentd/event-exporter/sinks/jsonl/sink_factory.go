@@ -0,0 +1,62 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonl
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"k8s.io/contrib/fluentd/event-exporter/sinks"
+)
+
+const (
+	stdout = "-"
+)
+
+type jsonlSinkFactory struct {
+	flagSet *flag.FlagSet
+	output  *string
+}
+
+// NewJSONLSinkFactory creates a new factory of sinks, which write the events
+// as newline-delimited JSON to stdout or a file.
+func NewJSONLSinkFactory() sinks.SinkFactory {
+	fs := flag.NewFlagSet("jsonl", flag.ContinueOnError)
+	return &jsonlSinkFactory{
+		flagSet: fs,
+		output: fs.String("output", stdout, "File to append the events to, "+
+			stdout+" for stdout"),
+	}
+}
+
+func (f *jsonlSinkFactory) CreateNew(opts []string) (sinks.Sink, error) {
+	err := f.flagSet.Parse(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sink opts: %v", err)
+	}
+
+	if *f.output == stdout {
+		return newJSONLSink(os.Stdout, nil), nil
+	}
+
+	file, err := os.OpenFile(*f.output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output: %v", err)
+	}
+	return newJSONLSink(file, file), nil
+}
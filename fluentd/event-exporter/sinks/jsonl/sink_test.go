@@ -0,0 +1,81 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonl
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api_v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestJSONLSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonl")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "events.json")
+
+	s, err := NewJSONLSinkFactory().CreateNew([]string{"-output", output})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stopCh)
+		close(done)
+	}()
+
+	s.OnAdd(&api_v1.Event{Reason: "Pulled", Count: 1})
+	s.OnUpdate(&api_v1.Event{Reason: "Pulled", Count: 1}, &api_v1.Event{Reason: "Pulled", Count: 2})
+	s.OnDelete(&api_v1.Event{Reason: "Pulled", Count: 2})
+
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the sink to stop")
+	}
+	// Events received after stopping are not written
+	s.OnAdd(&api_v1.Event{Reason: "Killing"})
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatalf("failed to open output: %v", err)
+	}
+	defer file.Close()
+
+	var counts []int32
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event api_v1.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("failed to decode line %q: %v", scanner.Text(), err)
+		}
+		counts = append(counts, event.Count)
+	}
+	if len(counts) != 2 || counts[0] != 1 || counts[1] != 2 {
+		t.Errorf("written event counts %v, expected [1 2]", counts)
+	}
+}
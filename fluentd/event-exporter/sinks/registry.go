@@ -0,0 +1,55 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var factories = map[string]SinkFactory{}
+
+// RegisterSinkFactory makes a sink factory available under the given name.
+// It's meant to be called from the init function of the sink package and
+// panics if the name is already taken.
+func RegisterSinkFactory(name string, factory SinkFactory) {
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("sink factory %q is already registered", name))
+	}
+	factories[name] = factory
+}
+
+// GetSinkFactory returns the sink factory registered under the given name.
+func GetSinkFactory(name string) (SinkFactory, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q, registered sinks are: %s",
+			name, strings.Join(SinkFactoryNames(), ", "))
+	}
+	return factory, nil
+}
+
+// SinkFactoryNames returns the sorted names of the registered sink factories.
+func SinkFactoryNames() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spool
+
+import (
+	"flag"
+	"fmt"
+)
+
+const (
+	defaultMaxSize     = 100 * 1024 * 1024
+	defaultSegmentSize = 1024 * 1024
+)
+
+// Config configures the spool of a sink.
+type Config struct {
+	// Directory where the spool is stored, the spool is disabled if empty.
+	Directory string
+	// MaxSize is the maximum size of the spool in bytes, the oldest entries
+	// are dropped when it's exceeded.
+	MaxSize int64
+	// SegmentSize is the size in bytes after which a new segment file is
+	// started. Segments are removed once all their entries are sent.
+	SegmentSize int64
+}
+
+// NewConfigFromFlags defines the spool flags on the given flag set and
+// returns the config they are parsed into.
+func NewConfigFromFlags(fs *flag.FlagSet) *Config {
+	config := &Config{SegmentSize: defaultSegmentSize}
+	fs.StringVar(&config.Directory, "spool-dir", "", "Directory on a local volume where the "+
+		"events are stored until they are sent, so that they survive outages and restarts. "+
+		"Events are only kept in memory if empty")
+	fs.Int64Var(&config.MaxSize, "spool-max-size", defaultMaxSize, "Maximum size of the spool "+
+		"in bytes, the oldest events are dropped when it's exceeded")
+	return config
+}
+
+// Enabled returns whether the sink should use a spool.
+func (c *Config) Enabled() bool {
+	return c.Directory != ""
+}
+
+// Validate checks that the sizes of the spool are consistent.
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.SegmentSize <= 0 {
+		return fmt.Errorf("spool segment size must be positive, got %d", c.SegmentSize)
+	}
+	if c.MaxSize < c.SegmentSize {
+		return fmt.Errorf("spool max size must be at least %d bytes, got %d", c.SegmentSize, c.MaxSize)
+	}
+	return nil
+}
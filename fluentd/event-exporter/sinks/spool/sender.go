@@ -0,0 +1,61 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spool
+
+import (
+	"github.com/golang/glog"
+)
+
+// SendFunc sends a batch read from the spool. It's expected to retry until
+// the batch is sent or rejected, an error means that the batch couldn't be
+// decoded and is dropped.
+type SendFunc func(data []byte) error
+
+// StartSending sends the batches read from the spool with send, from
+// concurrency goroutines, until the spool is closed. Each batch is
+// acknowledged once send returns.
+func (s *Spool) StartSending(concurrency int, send SendFunc) {
+	for i := 0; i < concurrency; i++ {
+		go s.sendRecords(send)
+	}
+}
+
+func (s *Spool) sendRecords(send SendFunc) {
+	for {
+		record, ok := s.Next()
+		if !ok {
+			return
+		}
+		if err := send(record.Data); err != nil {
+			droppedEntryCount.WithLabelValues(s.name).Add(float64(record.Entries))
+			glog.Warningf("Dropping %d entries, which failed to decode from the %s spool: %v", record.Entries, s.name, err)
+		}
+		s.Ack(record)
+	}
+}
+
+// Shutdown calls flush, which appends the entries the sink received but
+// didn't spool yet, and closes the spool. Batches that weren't sent are sent
+// after restart.
+func (s *Spool) Shutdown(flush func()) {
+	glog.Infof("%s sink received stop signal, spooling pending entries", s.name)
+	flush()
+	if err := s.Close(); err != nil {
+		glog.Warningf("Failed to close the %s spool: %v", s.name, err)
+	}
+	glog.Infof("Spool closed, exiting %s sink", s.name)
+}
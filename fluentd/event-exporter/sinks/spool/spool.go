@@ -0,0 +1,507 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	segmentSuffix = ".spool"
+	// recordHeaderSize is the size of the header preceding the data of each
+	// record: the length of the data, the number of entries in it and its
+	// checksum.
+	recordHeaderSize = 12
+)
+
+var (
+	entryCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:      "entry_count",
+			Help:      "Number of entries in the spool, waiting to be sent",
+			Subsystem: "spool",
+		},
+		[]string{"sink"},
+	)
+
+	sizeBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:      "size_bytes",
+			Help:      "Size of the spool on disk",
+			Subsystem: "spool",
+		},
+		[]string{"sink"},
+	)
+
+	droppedEntryCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "dropped_entry_count",
+			Help:      "Number of entries, dropped from the spool before being sent",
+			Subsystem: "spool",
+		},
+		[]string{"sink"},
+	)
+)
+
+// Record is a batch of entries read from the spool.
+type Record struct {
+	Data []byte
+	// Entries is the number of entries in the batch.
+	Entries int
+
+	segment *segment
+}
+
+type segment struct {
+	id   uint64
+	path string
+	size int64
+	// Records and entries written to the segment.
+	records int
+	entries int
+	// Records and entries returned by Next, and the offset of the next one.
+	read        int
+	readEntries int
+	readOffset  int64
+	// Records and entries acknowledged after being sent.
+	acked        int
+	ackedEntries int
+
+	// file is opened for reading when the first record is read.
+	file    *os.File
+	removed bool
+}
+
+// Spool is a write-ahead log of batches of entries on a local volume, split
+// into segment files. Batches are returned by Next in the order they were
+// appended and must be acknowledged once they're sent, a segment is removed
+// when all its batches are. The batches of the segments, which weren't
+// removed before a restart, are returned again after the spool is opened, so
+// the entries are sent at least once.
+//
+// Segments are synced to disk when they're complete and when the spool is
+// closed, not after every batch: if the node crashes, the batches appended to
+// the last segment since it was started may be lost.
+type Spool struct {
+	name   string
+	config *Config
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	segments []*segment
+	// writer is the file of the last segment, nil if new batches must be
+	// appended to a new segment.
+	writer *os.File
+	closed bool
+}
+
+// OpenIfEnabled opens the spool if it's enabled in config, it returns nil
+// otherwise.
+func OpenIfEnabled(name string, config *Config) (*Spool, error) {
+	if !config.Enabled() {
+		return nil, nil
+	}
+	s, err := Open(name, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool: %v", err)
+	}
+	return s, nil
+}
+
+// Open opens the spool in the configured directory, loading the segments left
+// by the previous run. Name identifies the spool in the logs and metrics.
+func Open(name string, config *Config) (*Spool, error) {
+	if err := os.MkdirAll(config.Directory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %v", err)
+	}
+	files, err := ioutil.ReadDir(config.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %v", err)
+	}
+
+	s := &Spool{
+		name:   name,
+		config: config,
+	}
+	s.cond = sync.NewCond(&s.lock)
+
+	// ReadDir sorts the files by name, so the segments are loaded in order
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), segmentSuffix), 16, 64)
+		if err != nil {
+			glog.Warningf("Ignoring unexpected file %s in the spool directory", file.Name())
+			continue
+		}
+
+		seg, err := s.loadSegment(filepath.Join(config.Directory, file.Name()), id)
+		if err != nil {
+			return nil, err
+		}
+		if seg.records == 0 {
+			if err := os.Remove(seg.path); err != nil {
+				return nil, fmt.Errorf("failed to remove empty spool segment: %v", err)
+			}
+			continue
+		}
+		s.segments = append(s.segments, seg)
+	}
+
+	entries, size := s.usage()
+	if entries > 0 {
+		glog.Infof("Replaying %d entries (%d bytes) from the %s spool", entries, size, name)
+	}
+	s.updateMetrics()
+
+	return s, nil
+}
+
+// loadSegment scans a segment left by the previous run. The segment is
+// truncated after the last valid record, since the exporter might have been
+// killed while appending.
+func (s *Spool) loadSegment(path string, id uint64) (*segment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool segment: %v", err)
+	}
+	defer file.Close()
+
+	seg := &segment{id: id, path: path}
+	r := bufio.NewReader(file)
+	for {
+		data, entries, err := readRecord(r, s.config.MaxSize)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			glog.Warningf("Truncating spool segment %s after %d records: %v", path, seg.records, err)
+			if err := os.Truncate(path, seg.size); err != nil {
+				return nil, fmt.Errorf("failed to truncate spool segment: %v", err)
+			}
+			break
+		}
+		seg.size += int64(recordHeaderSize + len(data))
+		seg.records++
+		seg.entries += entries
+	}
+
+	return seg, nil
+}
+
+// Append writes a batch of entries to the spool. If the spool would exceed its
+// maximum size, the oldest segments are dropped first.
+func (s *Spool) Append(data []byte, entries int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return errors.New("spool is closed")
+	}
+	size := int64(recordHeaderSize + len(data))
+	if size > s.config.MaxSize {
+		return fmt.Errorf("batch of %d bytes doesn't fit in the spool", size)
+	}
+
+	for s.size()+size > s.config.MaxSize {
+		s.dropOldest()
+	}
+
+	if s.writer == nil || s.segments[len(s.segments)-1].size >= s.config.SegmentSize {
+		if err := s.startSegment(); err != nil {
+			return err
+		}
+	}
+
+	seg := s.segments[len(s.segments)-1]
+	if err := writeRecord(s.writer, data, entries); err != nil {
+		// Seal the segment, so that the next batch doesn't follow a
+		// partially written record.
+		s.writer.Close()
+		s.writer = nil
+		os.Truncate(seg.path, seg.size)
+		return fmt.Errorf("failed to write to the spool: %v", err)
+	}
+	seg.size += size
+	seg.records++
+	seg.entries += entries
+
+	s.updateMetrics()
+	s.cond.Signal()
+	return nil
+}
+
+// Next returns the oldest batch that wasn't returned yet, blocking until one
+// is appended. It returns false once the spool is closed.
+func (s *Spool) Next() (*Record, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for {
+		if s.closed {
+			return nil, false
+		}
+
+		seg := s.nextUnread()
+		if seg == nil {
+			s.cond.Wait()
+			continue
+		}
+		record, err := s.readNext(seg)
+		if err != nil {
+			s.dropUnread(seg, err)
+			continue
+		}
+		return record, true
+	}
+}
+
+// nextUnread returns the oldest segment with batches that weren't read yet.
+func (s *Spool) nextUnread() *segment {
+	for _, seg := range s.segments {
+		if seg.read < seg.records {
+			return seg
+		}
+	}
+	return nil
+}
+
+func (s *Spool) readNext(seg *segment) (*Record, error) {
+	if seg.file == nil {
+		file, err := os.Open(seg.path)
+		if err != nil {
+			return nil, err
+		}
+		seg.file = file
+	}
+
+	r := io.NewSectionReader(seg.file, seg.readOffset, seg.size-seg.readOffset)
+	data, entries, err := readRecord(r, s.config.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+	seg.read++
+	seg.readEntries += entries
+	seg.readOffset += int64(recordHeaderSize + len(data))
+
+	return &Record{Data: data, Entries: entries, segment: seg}, nil
+}
+
+// Ack acknowledges that the batch was sent and doesn't have to be kept anymore.
+func (s *Spool) Ack(record *Record) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	seg := record.segment
+	if s.closed || seg.removed {
+		return
+	}
+	seg.acked++
+	seg.ackedEntries += record.Entries
+	s.removeIfDone(seg)
+	s.updateMetrics()
+}
+
+// Close closes the spool, unblocking Next. Batches that weren't acknowledged
+// are returned again after the spool is reopened.
+func (s *Spool) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.cond.Broadcast()
+
+	var err error
+	if s.writer != nil {
+		err = s.closeWriter()
+		// The last segment may be done now that it's not written anymore
+		s.removeIfDone(s.segments[len(s.segments)-1])
+	}
+	for _, seg := range s.segments {
+		if seg.file != nil {
+			seg.file.Close()
+		}
+	}
+	return err
+}
+
+func (s *Spool) startSegment() error {
+	id := uint64(0)
+	if len(s.segments) > 0 {
+		id = s.segments[len(s.segments)-1].id + 1
+	}
+	if s.writer != nil {
+		if err := s.closeWriter(); err != nil {
+			glog.Warningf("Failed to close spool segment %s: %v", s.segments[len(s.segments)-1].path, err)
+		}
+		s.removeIfDone(s.segments[len(s.segments)-1])
+	}
+
+	path := filepath.Join(s.config.Directory, fmt.Sprintf("%016x%s", id, segmentSuffix))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %v", err)
+	}
+	s.segments = append(s.segments, &segment{id: id, path: path})
+	s.writer = file
+	return nil
+}
+
+// closeWriter flushes the last segment to disk and closes it, so that its
+// batches survive a crash of the node once it's complete.
+func (s *Spool) closeWriter() error {
+	err := s.writer.Sync()
+	if closeErr := s.writer.Close(); err == nil {
+		err = closeErr
+	}
+	s.writer = nil
+	return err
+}
+
+// removeIfDone removes the segment if no more batches are appended to it and
+// all of them were acknowledged.
+func (s *Spool) removeIfDone(seg *segment) {
+	if s.writer != nil && seg == s.segments[len(s.segments)-1] {
+		return
+	}
+	if seg.acked < seg.records {
+		return
+	}
+	s.remove(seg)
+}
+
+// dropOldest removes the oldest segment to make room for new batches.
+func (s *Spool) dropOldest() {
+	seg := s.segments[0]
+	if s.writer != nil && len(s.segments) == 1 {
+		s.writer.Close()
+		s.writer = nil
+	}
+
+	dropped := seg.entries - seg.ackedEntries
+	droppedEntryCount.WithLabelValues(s.name).Add(float64(dropped))
+	glog.Warningf("The %s spool is full, dropping %d entries", s.name, dropped)
+	s.remove(seg)
+}
+
+// dropUnread drops the batches of the segment that weren't read yet, after
+// the segment turned out to be unreadable.
+func (s *Spool) dropUnread(seg *segment, err error) {
+	dropped := seg.entries - seg.readEntries
+	droppedEntryCount.WithLabelValues(s.name).Add(float64(dropped))
+	glog.Errorf("Failed to read the %s spool, dropping %d entries: %v", s.name, dropped, err)
+
+	seg.acked += seg.records - seg.read
+	seg.ackedEntries += dropped
+	seg.read = seg.records
+	seg.readEntries = seg.entries
+	s.removeIfDone(seg)
+	s.updateMetrics()
+}
+
+func (s *Spool) remove(seg *segment) {
+	if seg.file != nil {
+		seg.file.Close()
+	}
+	if err := os.Remove(seg.path); err != nil {
+		glog.Warningf("Failed to remove spool segment %s: %v", seg.path, err)
+	}
+	seg.removed = true
+
+	for i := range s.segments {
+		if s.segments[i] == seg {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			break
+		}
+	}
+}
+
+// usage returns the number of entries, which weren't acknowledged, and the
+// size of the spool.
+func (s *Spool) usage() (int, int64) {
+	entries, size := 0, int64(0)
+	for _, seg := range s.segments {
+		entries += seg.entries - seg.ackedEntries
+		size += seg.size
+	}
+	return entries, size
+}
+
+func (s *Spool) size() int64 {
+	_, size := s.usage()
+	return size
+}
+
+func (s *Spool) updateMetrics() {
+	entries, size := s.usage()
+	entryCount.WithLabelValues(s.name).Set(float64(entries))
+	sizeBytes.WithLabelValues(s.name).Set(float64(size))
+}
+
+func writeRecord(w io.Writer, data []byte, entries int) error {
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], uint32(entries))
+	binary.BigEndian.PutUint32(record[8:12], crc32.ChecksumIEEE(data))
+	copy(record[recordHeaderSize:], data)
+
+	_, err := w.Write(record)
+	return err
+}
+
+// readRecord reads a record, returning io.EOF if there are no more records.
+func readRecord(r io.Reader, maxSize int64) ([]byte, int, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if int64(length) > maxSize {
+		return nil, 0, fmt.Errorf("invalid record length %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[8:12]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+
+	return data, int(binary.BigEndian.Uint32(header[4:8])), nil
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spool
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newTestSpool(t *testing.T, maxSize int64, segmentSize int64) (*Spool, *Config) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	config := &Config{Directory: dir, MaxSize: maxSize, SegmentSize: segmentSize}
+	s, err := Open(t.Name(), config)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	return s, config
+}
+
+func appendBatches(t *testing.T, s *Spool, from int, to int) {
+	for i := from; i < to; i++ {
+		if err := s.Append([]byte(fmt.Sprintf("batch-%02d", i)), i); err != nil {
+			t.Fatalf("failed to append batch %d: %v", i, err)
+		}
+	}
+}
+
+func expectNext(t *testing.T, s *Spool, expected int) *Record {
+	record, ok := s.Next()
+	if !ok {
+		t.Fatalf("expected batch %d, spool is closed", expected)
+	}
+	if string(record.Data) != fmt.Sprintf("batch-%02d", expected) || record.Entries != expected {
+		t.Fatalf("read %q with %d entries, expected batch %d", record.Data, record.Entries, expected)
+	}
+	return record
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatalf("failed to list segments: %v", err)
+	}
+	return files
+}
+
+func metricValue(t *testing.T, m interface {
+	Write(*dto.Metric) error
+}) float64 {
+	var metric dto.Metric
+	if err := m.Write(&metric); err != nil {
+		t.Fatalf("failed to read metric: %v", err)
+	}
+	if metric.Gauge != nil {
+		return metric.GetGauge().GetValue()
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestAppendAndAck(t *testing.T) {
+	// Each batch is 20 bytes, so segments hold two batches
+	s, config := newTestSpool(t, 1000, 40)
+	defer os.RemoveAll(config.Directory)
+	defer s.Close()
+
+	appendBatches(t, s, 0, 5)
+	if files := segmentFiles(t, config.Directory); len(files) != 3 {
+		t.Errorf("spool has %d segments, expected 3", len(files))
+	}
+	if depth := metricValue(t, entryCount.WithLabelValues(t.Name())); depth != 10 {
+		t.Errorf("spool depth is %v, expected 10", depth)
+	}
+
+	for i := 0; i < 4; i++ {
+		s.Ack(expectNext(t, s, i))
+	}
+	if files := segmentFiles(t, config.Directory); len(files) != 1 {
+		t.Errorf("spool has %d segments after acknowledging 4 batches, expected 1", len(files))
+	}
+	if depth := metricValue(t, entryCount.WithLabelValues(t.Name())); depth != 4 {
+		t.Errorf("spool depth is %v, expected 4", depth)
+	}
+
+	// The last segment is kept while it's written to
+	s.Ack(expectNext(t, s, 4))
+	if files := segmentFiles(t, config.Directory); len(files) != 1 {
+		t.Errorf("spool has %d segments, expected the last one to be kept", len(files))
+	}
+}
+
+func TestReplay(t *testing.T) {
+	s, config := newTestSpool(t, 1000, 40)
+	defer os.RemoveAll(config.Directory)
+
+	appendBatches(t, s, 0, 5)
+	s.Ack(expectNext(t, s, 0))
+	s.Ack(expectNext(t, s, 1))
+	// Batch 2 is read, but not acknowledged
+	expectNext(t, s, 2)
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close spool: %v", err)
+	}
+	if _, ok := s.Next(); ok {
+		t.Errorf("expected Next to return false after closing")
+	}
+
+	s, err := Open(t.Name(), config)
+	if err != nil {
+		t.Fatalf("failed to reopen spool: %v", err)
+	}
+	defer s.Close()
+
+	for i := 2; i < 5; i++ {
+		s.Ack(expectNext(t, s, i))
+	}
+	appendBatches(t, s, 5, 6)
+	expectNext(t, s, 5)
+}
+
+func TestMaxSize(t *testing.T) {
+	s, config := newTestSpool(t, 100, 40)
+	defer os.RemoveAll(config.Directory)
+	defer s.Close()
+
+	dropped := metricValue(t, droppedEntryCount.WithLabelValues(t.Name()))
+	appendBatches(t, s, 0, 10)
+
+	// Each batch is 20 bytes and segments hold two batches, so the spool
+	// keeps at most five batches and drops a whole segment to make room
+	if size := metricValue(t, sizeBytes.WithLabelValues(t.Name())); size != 80 {
+		t.Errorf("spool size is %v, expected 80", size)
+	}
+	if d := metricValue(t, droppedEntryCount.WithLabelValues(t.Name())) - dropped; d != 0+1+2+3+4+5 {
+		t.Errorf("dropped %v entries, expected 15", d)
+	}
+	expectNext(t, s, 6)
+
+	if err := s.Append(make([]byte, 100), 1); err == nil {
+		t.Errorf("expected an error appending a batch larger than the spool")
+	}
+}
+
+func TestTruncatedSegment(t *testing.T) {
+	s, config := newTestSpool(t, 1000, 1000)
+	defer os.RemoveAll(config.Directory)
+
+	appendBatches(t, s, 0, 2)
+	s.Close()
+
+	// Simulate a crash in the middle of appending a batch
+	files := segmentFiles(t, config.Directory)
+	if len(files) != 1 {
+		t.Fatalf("spool has %d segments, expected 1", len(files))
+	}
+	f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open segment: %v", err)
+	}
+	f.Write([]byte{0, 0, 0, 20, 0, 0})
+	f.Close()
+
+	s, err = Open(t.Name(), config)
+	if err != nil {
+		t.Fatalf("failed to reopen spool: %v", err)
+	}
+	defer s.Close()
+
+	appendBatches(t, s, 2, 3)
+	for i := 0; i < 3; i++ {
+		s.Ack(expectNext(t, s, i))
+	}
+}
+
+func TestNextBlocks(t *testing.T) {
+	s, config := newTestSpool(t, 1000, 40)
+	defer os.RemoveAll(config.Directory)
+
+	records := make(chan *Record)
+	go func() {
+		for {
+			record, ok := s.Next()
+			if !ok {
+				close(records)
+				return
+			}
+			records <- record
+		}
+	}()
+
+	select {
+	case <-records:
+		t.Fatalf("expected Next to block on an empty spool")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	appendBatches(t, s, 0, 1)
+	select {
+	case record := <-records:
+		s.Ack(record)
+	case <-time.After(time.Second):
+		t.Fatalf("expected Next to return the appended batch")
+	}
+
+	s.Close()
+	select {
+	case _, ok := <-records:
+		if ok {
+			t.Errorf("expected no more batches")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Next to return after closing")
+	}
+}
+
+func TestOpenIfEnabled(t *testing.T) {
+	s, err := OpenIfEnabled(t.Name(), &Config{})
+	if s != nil || err != nil {
+		t.Errorf("expected no spool when disabled, got %v, %v", s, err)
+	}
+
+	s, config := newTestSpool(t, 1000, 40)
+	defer os.RemoveAll(config.Directory)
+	s.Close()
+	s, err = OpenIfEnabled(t.Name(), config)
+	if s == nil || err != nil {
+		t.Fatalf("expected a spool when enabled, got %v, %v", s, err)
+	}
+	s.Close()
+}
+
+func TestStartSending(t *testing.T) {
+	s, config := newTestSpool(t, 1000, 40)
+	defer os.RemoveAll(config.Directory)
+
+	dropped := metricValue(t, droppedEntryCount.WithLabelValues(t.Name()))
+	sent := make(chan string, 10)
+	s.StartSending(2, func(data []byte) error {
+		sent <- string(data)
+		if string(data) == "batch-01" {
+			return fmt.Errorf("invalid batch")
+		}
+		return nil
+	})
+	appendBatches(t, s, 0, 4)
+
+	received := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		select {
+		case data := <-sent:
+			received[data] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expected 4 batches to be sent, got %v", received)
+		}
+	}
+	// The batches are acknowledged after being sent
+	for i := 0; metricValue(t, entryCount.WithLabelValues(t.Name())) != 0; i++ {
+		if i == 100 {
+			t.Fatalf("expected the sent batches to be acknowledged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if d := metricValue(t, droppedEntryCount.WithLabelValues(t.Name())) - dropped; d != 1 {
+		t.Errorf("dropped %v entries, expected the entry of the invalid batch", d)
+	}
+
+	flushed := false
+	s.Shutdown(func() { flushed = true })
+	if !flushed {
+		t.Errorf("expected the pending entries to be flushed before closing")
+	}
+	if _, ok := s.Next(); ok {
+		t.Errorf("expected the spool to be closed")
+	}
+}
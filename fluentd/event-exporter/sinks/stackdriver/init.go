@@ -18,6 +18,8 @@ package stackdriver
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/contrib/fluentd/event-exporter/sinks"
 )
 
 func init() {
@@ -26,4 +28,6 @@ func init() {
 		successfullySentEntryCount,
 		requestCount,
 	)
+
+	sinks.RegisterSinkFactory("stackdriver", NewSdSinkFactory())
 }
@@ -17,6 +17,7 @@ limitations under the License.
 package stackdriver
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/golang/glog"
@@ -25,6 +26,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/util/clock"
 	api_v1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/contrib/fluentd/event-exporter/sinks/spool"
 )
 
 var (
@@ -61,11 +63,16 @@ type sdSink struct {
 	// and takes it out upon completion. Channel's capacity is set to the
 	// maximum level of parallelism, so any extra request will lock on addition.
 	concurrencyChannel chan struct{}
+	// Spool where the batches are written before being sent, if it's
+	// enabled. The batches are then sent by MaxConcurrency goroutines reading
+	// from the spool, so that the sink doesn't block while Stackdriver is
+	// unavailable.
+	spool *spool.Spool
 
 	beforeFirstList bool
 }
 
-func newSdSink(writer sdWriter, clock clock.Clock, config *sdSinkConfig) *sdSink {
+func newSdSink(writer sdWriter, clock clock.Clock, config *sdSinkConfig, spool *spool.Spool) *sdSink {
 	return &sdSink{
 		logEntryChannel: make(chan *sd.LogEntry, config.MaxBufferSize),
 		config:          config,
@@ -77,6 +84,7 @@ func newSdSink(writer sdWriter, clock clock.Clock, config *sdSinkConfig) *sdSink
 		timer:              nil,
 		fakeTimeChannel:    make(chan time.Time),
 		concurrencyChannel: make(chan struct{}, config.MaxConcurrency),
+		spool:              spool,
 
 		beforeFirstList: true,
 	}
@@ -119,6 +127,10 @@ func (s *sdSink) OnList(list *api_v1.EventList) {
 
 func (s *sdSink) Run(stopCh <-chan struct{}) {
 	glog.Info("Starting Stackdriver sink")
+	if s.spool != nil {
+		s.spool.StartSending(s.config.MaxConcurrency, s.sendSpooledEntries)
+	}
+
 	for {
 		select {
 		case entry := <-s.logEntryChannel:
@@ -133,6 +145,10 @@ func (s *sdSink) Run(stopCh <-chan struct{}) {
 			s.flushBuffer()
 			break
 		case <-stopCh:
+			if s.spool != nil {
+				s.spool.Shutdown(s.flushPending)
+				return
+			}
 			glog.Info("Stackdriver sink recieved stop signal, waiting for all requests to finish")
 			for i := 0; i < s.config.MaxConcurrency; i++ {
 				s.concurrencyChannel <- struct{}{}
@@ -146,21 +162,66 @@ func (s *sdSink) Run(stopCh <-chan struct{}) {
 func (s *sdSink) flushBuffer() {
 	entries := s.currentBuffer
 	s.currentBuffer = nil
+	if s.spool != nil {
+		err := s.spoolEntries(entries)
+		if err == nil {
+			return
+		}
+		glog.Warningf("Failed to spool %d entries, sending them directly: %v", len(entries), err)
+	}
 	s.concurrencyChannel <- struct{}{}
 	go s.sendEntries(entries)
 }
 
 func (s *sdSink) sendEntries(entries []*sd.LogEntry) {
+	s.write(entries)
+
+	<-s.concurrencyChannel
+}
+
+func (s *sdSink) write(entries []*sd.LogEntry) {
 	glog.V(4).Infof("Sending %d entries to Stackdriver", len(entries))
 
 	written := s.writer.Write(entries, s.logName, s.config.Resource)
 	successfullySentEntryCount.Add(float64(written))
 
-	<-s.concurrencyChannel
-
 	glog.V(4).Infof("Successfully sent %d entries to Stackdriver", len(entries))
 }
 
+func (s *sdSink) spoolEntries(entries []*sd.LogEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return s.spool.Append(data, len(entries))
+}
+
+// sendSpooledEntries decodes and sends a batch read from the spool.
+func (s *sdSink) sendSpooledEntries(data []byte) error {
+	var entries []*sd.LogEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	s.write(entries)
+	return nil
+}
+
+// flushPending flushes the entries, which were received but not flushed yet,
+// before the spool is closed.
+func (s *sdSink) flushPending() {
+	for pending := true; pending; {
+		select {
+		case entry := <-s.logEntryChannel:
+			s.currentBuffer = append(s.currentBuffer, entry)
+		default:
+			pending = false
+		}
+	}
+	if len(s.currentBuffer) > 0 {
+		s.flushBuffer()
+	}
+}
+
 func (s *sdSink) getTimerChannel() <-chan time.Time {
 	if s.timer == nil {
 		return s.fakeTimeChannel
@@ -27,6 +27,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/contrib/fluentd/event-exporter/sinks"
+	"k8s.io/contrib/fluentd/event-exporter/sinks/spool"
 )
 
 type sdSinkFactory struct {
@@ -34,6 +35,7 @@ type sdSinkFactory struct {
 	flushDelay     *time.Duration
 	maxBufferSize  *int
 	maxConcurrency *int
+	spoolConfig    *spool.Config
 }
 
 // NewSdSinkFactory creates a new Stackdriver sink factory
@@ -48,6 +50,7 @@ func NewSdSinkFactory() sinks.SinkFactory {
 			"in the request to Stackdriver"),
 		maxConcurrency: fs.Int("max-concurrency", defaultMaxConcurrency, "Maximum number of "+
 			"concurrent requests to Stackdriver"),
+		spoolConfig: spool.NewConfigFromFlags(fs),
 	}
 }
 
@@ -61,6 +64,9 @@ func (f *sdSinkFactory) CreateNew(opts []string) (sinks.Sink, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to build config: %v", err)
 	}
+	if err := f.spoolConfig.Validate(); err != nil {
+		return nil, err
+	}
 
 	ctx := context.Background()
 	client, err := google.DefaultClient(ctx)
@@ -76,7 +82,12 @@ func (f *sdSinkFactory) CreateNew(opts []string) (sinks.Sink, error) {
 
 	clk := clock.RealClock{}
 
-	return newSdSink(writer, clk, config), nil
+	sp, err := spool.OpenIfEnabled("stackdriver", f.spoolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSdSink(writer, clk, config, sp), nil
 }
 
 func (f *sdSinkFactory) createSinkConfig() (*sdSinkConfig, error) {
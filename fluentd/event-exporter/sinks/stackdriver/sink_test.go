@@ -17,6 +17,8 @@ limitations under the License.
 package stackdriver
 
 import (
+	"io/ioutil"
+	"os"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -26,6 +28,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/apimachinery/pkg/util/wait"
 	api_v1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/contrib/fluentd/event-exporter/sinks/spool"
 )
 
 type fakeSdWriter struct {
@@ -55,7 +58,7 @@ func TestMaxConcurrency(t *testing.T) {
 		MaxConcurrency: 10,
 		MaxBufferSize:  10,
 	}
-	s := newSdSink(w, clock.NewFakeClock(time.Time{}), config)
+	s := newSdSink(w, clock.NewFakeClock(time.Time{}), config, nil)
 	go s.Run(wait.NeverStop)
 
 	for i := 0; i < 110; i++ {
@@ -82,7 +85,7 @@ func TestBatchTimeout(t *testing.T) {
 		MaxConcurrency: 10,
 		MaxBufferSize:  10,
 	}
-	s := newSdSink(w, clock.NewFakeClock(time.Time{}), config)
+	s := newSdSink(w, clock.NewFakeClock(time.Time{}), config, nil)
 	go s.Run(wait.NeverStop)
 
 	s.OnAdd(&api_v1.Event{})
@@ -108,7 +111,7 @@ func TestBatchSizeLimit(t *testing.T) {
 		MaxConcurrency: 10,
 		MaxBufferSize:  10,
 	}
-	s := newSdSink(w, clock.NewFakeClock(time.Time{}), config)
+	s := newSdSink(w, clock.NewFakeClock(time.Time{}), config, nil)
 	go s.Run(wait.NeverStop)
 
 	for i := 0; i < 15; i++ {
@@ -137,7 +140,7 @@ func TestInitialList(t *testing.T) {
 		MaxConcurrency: 10,
 		MaxBufferSize:  10,
 	}
-	s := newSdSink(w, clock.NewFakeClock(time.Time{}), config)
+	s := newSdSink(w, clock.NewFakeClock(time.Time{}), config, nil)
 	go s.Run(wait.NeverStop)
 
 	s.OnList(&api_v1.EventList{})
@@ -156,3 +159,88 @@ func TestInitialList(t *testing.T) {
 		t.Fatalf("writeCalledTimes = %d, expected 1", writeCalledTimes)
 	}
 }
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	spoolConfig := &spool.Config{Directory: dir, MaxSize: 1 << 20, SegmentSize: 1 << 10}
+	config := &sdSinkConfig{
+		Resource:       nil,
+		FlushDelay:     10 * time.Millisecond,
+		LogName:        "logname",
+		MaxConcurrency: 2,
+		MaxBufferSize:  10,
+	}
+
+	// Stackdriver is unavailable, so the writer doesn't return
+	unavailable := make(chan struct{})
+	defer close(unavailable)
+	w := &fakeSdWriter{
+		writeFunc: func([]*sd.LogEntry, string, *sd.MonitoredResource) int {
+			<-unavailable
+			return 0
+		},
+	}
+	sp, err := spool.Open("stackdriver-test", spoolConfig)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	s := newSdSink(w, clock.NewFakeClock(time.Time{}), config, sp)
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stopCh)
+		close(done)
+	}()
+
+	added := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			s.OnAdd(&api_v1.Event{})
+		}
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the sink not to block while Stackdriver is unavailable")
+	}
+
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the sink to stop")
+	}
+
+	// After a restart, all the entries are sent
+	var writtenEntries int32
+	w = &fakeSdWriter{
+		writeFunc: func(entries []*sd.LogEntry, _ string, _ *sd.MonitoredResource) int {
+			atomic.AddInt32(&writtenEntries, int32(len(entries)))
+			return len(entries)
+		},
+	}
+	sp, err = spool.Open("stackdriver-test", spoolConfig)
+	if err != nil {
+		t.Fatalf("failed to reopen spool: %v", err)
+	}
+	s = newSdSink(w, clock.NewFakeClock(time.Time{}), config, sp)
+	stopCh = make(chan struct{})
+	defer close(stopCh)
+	go s.Run(stopCh)
+
+	for i := 0; atomic.LoadInt32(&writtenEntries) < 100; i++ {
+		if i == 100 {
+			t.Fatalf("writtenEntries = %d, expected 100", atomic.LoadInt32(&writtenEntries))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&writtenEntries) != 100 {
+		t.Fatalf("writtenEntries = %d, expected 100", writtenEntries)
+	}
+}
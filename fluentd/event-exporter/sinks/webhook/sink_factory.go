@@ -0,0 +1,94 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/contrib/fluentd/event-exporter/sinks"
+	"k8s.io/contrib/fluentd/event-exporter/sinks/batch"
+	"k8s.io/contrib/fluentd/event-exporter/sinks/spool"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+)
+
+type webhookSinkFactory struct {
+	flagSet         *flag.FlagSet
+	url             *string
+	timeout         *time.Duration
+	bearerTokenFile *string
+	batchConfig     *batch.Config
+	spoolConfig     *spool.Config
+}
+
+// NewWebhookSinkFactory creates a new factory of sinks, which post the events
+// to an HTTP endpoint in batches.
+func NewWebhookSinkFactory() sinks.SinkFactory {
+	fs := flag.NewFlagSet("webhook", flag.ContinueOnError)
+	return &webhookSinkFactory{
+		flagSet: fs,
+		url:     fs.String("url", "", "URL to post the JSON array of events to"),
+		timeout: fs.Duration("timeout", defaultTimeout, "Timeout of the requests to the webhook"),
+		bearerTokenFile: fs.String("bearer-token-file", "", "File with the token sent in the "+
+			"Authorization header of the requests to the webhook"),
+		batchConfig: batch.NewConfigFromFlags(fs, "the webhook"),
+		spoolConfig: spool.NewConfigFromFlags(fs),
+	}
+}
+
+func (f *webhookSinkFactory) CreateNew(opts []string) (sinks.Sink, error) {
+	err := f.flagSet.Parse(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sink opts: %v", err)
+	}
+
+	if _, err := batch.ParseURL(*f.url); err != nil {
+		return nil, err
+	}
+	if err := f.batchConfig.Validate(); err != nil {
+		return nil, err
+	}
+	if err := f.spoolConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	bearerToken := ""
+	if *f.bearerTokenFile != "" {
+		token, err := ioutil.ReadFile(*f.bearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token: %v", err)
+		}
+		bearerToken = strings.TrimSpace(string(token))
+	}
+
+	client := &http.Client{Timeout: *f.timeout}
+	writer := newWebhookWriter(client, *f.url, bearerToken)
+
+	sp, err := spool.OpenIfEnabled("webhook", f.spoolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch.NewSink("webhook", writer, f.batchConfig, sp), nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	api_v1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/contrib/fluentd/event-exporter/sinks/batch"
+)
+
+func TestWebhookSink(t *testing.T) {
+	requests := make(chan []api_v1.Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Authorization = %q, expected the bearer token", r.Header.Get("Authorization"))
+		}
+		var events []api_v1.Event
+		if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		requests <- events
+	}))
+	defer server.Close()
+
+	tokenFile, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatalf("failed to create token file: %v", err)
+	}
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString("secret\n")
+	tokenFile.Close()
+
+	s, err := NewWebhookSinkFactory().CreateNew([]string{"-url", server.URL,
+		"-bearer-token-file", tokenFile.Name(), "-max-buffer-size", "2", "-max-concurrency", "1", "-flush-delay", "10ms"})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	go s.Run(wait.NeverStop)
+
+	for _, reason := range []string{"Pulled", "Created", "Started"} {
+		s.OnAdd(&api_v1.Event{Reason: reason})
+	}
+
+	var reasons []string
+	for len(reasons) < 3 {
+		select {
+		case events := <-requests:
+			if len(events) > 2 {
+				t.Errorf("request with %d events, expected at most 2", len(events))
+			}
+			for _, event := range events {
+				reasons = append(reasons, event.Reason)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("received %v, expected 3 events", reasons)
+		}
+	}
+	if reasons[0] != "Pulled" || reasons[1] != "Created" || reasons[2] != "Started" {
+		t.Errorf("received %v, expected the events in order", reasons)
+	}
+}
+
+func TestWebhookWriterErrors(t *testing.T) {
+	testcases := []struct {
+		code      int
+		expectErr bool
+		permanent bool
+	}{
+		{code: http.StatusOK},
+		{code: http.StatusNoContent},
+		{code: http.StatusBadRequest, expectErr: true, permanent: true},
+		{code: http.StatusRequestEntityTooLarge, expectErr: true, permanent: true},
+		{code: http.StatusUnprocessableEntity, expectErr: true, permanent: true},
+		{code: http.StatusUnauthorized, expectErr: true},
+		{code: http.StatusForbidden, expectErr: true},
+		{code: http.StatusNotFound, expectErr: true},
+		{code: http.StatusTooManyRequests, expectErr: true},
+		{code: http.StatusServiceUnavailable, expectErr: true},
+	}
+
+	for _, tc := range testcases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.code)
+		}))
+		w := newWebhookWriter(http.DefaultClient, server.URL, "")
+		err := w.Write([][]byte{[]byte("{}")})
+		server.Close()
+
+		if (err != nil) != tc.expectErr {
+			t.Errorf("code %d: err = %v, expected error: %v", tc.code, err, tc.expectErr)
+		}
+		if batch.IsPermanent(err) != tc.permanent {
+			t.Errorf("code %d: permanent = %v, expected %v", tc.code, batch.IsPermanent(err), tc.permanent)
+		}
+	}
+}
+
+func TestWebhookSinkFactoryErrors(t *testing.T) {
+	for _, opts := range [][]string{
+		{},
+		{"-url", "ftp://example.com"},
+		{"-url", "http://example.com", "-max-concurrency", "0"},
+		{"-url", "http://example.com", "-bearer-token-file", "/nonexistent"},
+	} {
+		if _, err := NewWebhookSinkFactory().CreateNew(opts); err == nil {
+			t.Errorf("opts %v: expected an error", opts)
+		}
+	}
+}
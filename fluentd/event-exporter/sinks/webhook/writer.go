@@ -0,0 +1,79 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/contrib/fluentd/event-exporter/sinks/batch"
+)
+
+var (
+	requestCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "request_count",
+			Help:      "Number of request, issued to the webhook",
+			Subsystem: "webhook_sink",
+		},
+		[]string{"code"},
+	)
+)
+
+// webhookWriter posts each batch of events to the webhook as a JSON array.
+type webhookWriter struct {
+	client      *http.Client
+	url         string
+	bearerToken string
+}
+
+func newWebhookWriter(client *http.Client, url string, bearerToken string) batch.Writer {
+	return &webhookWriter{
+		client:      client,
+		url:         url,
+		bearerToken: bearerToken,
+	}
+}
+
+func (w *webhookWriter) Write(entries [][]byte) error {
+	body := bytes.NewBufferString("[")
+	for i, entry := range entries {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		body.Write(entry)
+	}
+	body.WriteString("]")
+
+	req, err := http.NewRequest("POST", w.url, body)
+	if err != nil {
+		return batch.NewPermanentError(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.bearerToken)
+	}
+
+	code, _, err := batch.Send(w.client, req)
+	if code != 0 {
+		requestCount.WithLabelValues(strconv.Itoa(code)).Inc()
+	}
+	return err
+}
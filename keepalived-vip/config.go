@@ -0,0 +1,191 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	defLVSScheduler      = "wlc"
+	defPersistence       = 1800
+	defWeight            = 1
+	defConnectTimeout    = 3
+	defHealthCheckPath   = "/"
+	defHealthCheckStatus = 200
+
+	tcpCheck = "TCP_CHECK"
+	httpGet  = "HTTP_GET"
+	sslGet   = "SSL_GET"
+)
+
+var lvsSchedulers = []string{"rr", "wrr", "lc", "wlc", "lblc", "lblcr", "dh", "sh", "sed", "nq"}
+
+// healthCheck is the check keepalived runs against every backend of a VIP.
+type healthCheck struct {
+	// Type is TCP_CHECK, HTTP_GET or SSL_GET.
+	Type string `json:"type"`
+	// Path is the url requested by HTTP_GET and SSL_GET checks.
+	Path string `json:"path"`
+	// StatusCode is the status expected by HTTP_GET and SSL_GET checks.
+	StatusCode int `json:"statusCode"`
+	// ConnectTimeout is the timeout of the check in seconds.
+	ConnectTimeout int `json:"connectTimeout"`
+}
+
+// vipConfig is the configuration of a VIP in the ConfigMap. The value of a
+// key is either namespace/service name[:NAT|DR] or a JSON object with the
+// options below.
+type vipConfig struct {
+	// IP is the VIP, overriding the key. IPv6 addresses must be set here
+	// since they aren't valid keys.
+	IP string `json:"ip"`
+	// Service is the exposed service, with the format namespace/service name.
+	Service string `json:"service"`
+	// LVSMethod is the forwarding method.
+	LVSMethod string `json:"lvsMethod"`
+	// Scheduler is the LVS scheduler distributing the connections.
+	Scheduler string `json:"scheduler"`
+	// PersistenceTimeout is how long in seconds the connections of a client
+	// are sent to the same backend. Zero disables persistence.
+	PersistenceTimeout *int `json:"persistenceTimeout"`
+	// HealthCheck is the check of the backends.
+	HealthCheck *healthCheck `json:"healthCheck"`
+	// Weight is the weight of the backends.
+	Weight *int `json:"weight"`
+	// NodeWeights overrides the weight of the backends running on a node.
+	NodeWeights map[string]int `json:"nodeWeights"`
+}
+
+// parseVIPConfig parses the value of a key of the ConfigMap, filling in the
+// defaults of the options that aren't set.
+func parseVIPConfig(input string) (*vipConfig, error) {
+	cfg := &vipConfig{}
+	if strings.HasPrefix(strings.TrimSpace(input), "{") {
+		if err := json.Unmarshal([]byte(input), cfg); err != nil {
+			return nil, fmt.Errorf("invalid JSON found in '%v': %v", input, err)
+		}
+		if cfg.LVSMethod == "" {
+			cfg.LVSMethod = "NAT"
+		}
+	} else {
+		ns, svc, lvsm, err := parseNsSvcLVS(input)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Service = fmt.Sprintf("%v/%v", ns, svc)
+		cfg.LVSMethod = lvsm
+	}
+
+	cfg.setDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration found in '%v': %v", input, err)
+	}
+
+	return cfg, nil
+}
+
+func (cfg *vipConfig) setDefaults() {
+	if cfg.Scheduler == "" {
+		cfg.Scheduler = defLVSScheduler
+	}
+	if cfg.PersistenceTimeout == nil {
+		persistence := defPersistence
+		cfg.PersistenceTimeout = &persistence
+	}
+	if cfg.Weight == nil {
+		weight := defWeight
+		cfg.Weight = &weight
+	}
+	if cfg.HealthCheck == nil {
+		cfg.HealthCheck = &healthCheck{}
+	}
+	hc := cfg.HealthCheck
+	if hc.Type == "" {
+		hc.Type = tcpCheck
+	}
+	if hc.ConnectTimeout == 0 {
+		hc.ConnectTimeout = defConnectTimeout
+	}
+	if hc.Type != tcpCheck {
+		if hc.Path == "" {
+			hc.Path = defHealthCheckPath
+		}
+		if hc.StatusCode == 0 {
+			hc.StatusCode = defHealthCheckStatus
+		}
+	}
+}
+
+func (cfg *vipConfig) validate() error {
+	if _, _, err := parseNsName(cfg.Service); err != nil {
+		return err
+	}
+	if cfg.IP != "" && net.ParseIP(cfg.IP) == nil {
+		return fmt.Errorf("invalid IP address %v", cfg.IP)
+	}
+	if !lvsRegex.MatchString(cfg.LVSMethod) {
+		return fmt.Errorf("invalid LVS method. Only NAT and DR are supported: %v", cfg.LVSMethod)
+	}
+	if stringSlice(lvsSchedulers).pos(cfg.Scheduler) == -1 {
+		return fmt.Errorf("invalid LVS scheduler %v, supported values are %v", cfg.Scheduler, strings.Join(lvsSchedulers, ", "))
+	}
+	if *cfg.PersistenceTimeout < 0 {
+		return fmt.Errorf("invalid persistence timeout %v", *cfg.PersistenceTimeout)
+	}
+	if *cfg.Weight < 0 {
+		return fmt.Errorf("invalid weight %v", *cfg.Weight)
+	}
+	for node, weight := range cfg.NodeWeights {
+		if weight < 0 {
+			return fmt.Errorf("invalid weight %v for node %v", weight, node)
+		}
+	}
+
+	hc := cfg.HealthCheck
+	switch hc.Type {
+	case tcpCheck, httpGet, sslGet:
+	default:
+		return fmt.Errorf("invalid health check %v, only %v, %v and %v are supported", hc.Type, tcpCheck, httpGet, sslGet)
+	}
+	if hc.ConnectTimeout < 0 {
+		return fmt.Errorf("invalid health check connect timeout %v", hc.ConnectTimeout)
+	}
+	if hc.Type != tcpCheck {
+		if !strings.HasPrefix(hc.Path, "/") || strings.ContainsAny(hc.Path, " \t\n") {
+			return fmt.Errorf("invalid health check path '%v'", hc.Path)
+		}
+		if hc.StatusCode < 100 || hc.StatusCode > 599 {
+			return fmt.Errorf("invalid health check status code %v", hc.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+// backendWeight returns the weight of a backend running on node.
+func (cfg *vipConfig) backendWeight(node *string) int {
+	if node != nil {
+		if weight, ok := cfg.NodeWeights[*node]; ok {
+			return weight
+		}
+	}
+	return *cfg.Weight
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseVIPConfig(t *testing.T) {
+	testcases := map[string]struct {
+		Input       string
+		Service     string
+		Scheduler   string
+		Persistence int
+		HealthCheck healthCheck
+		ExpectedErr bool
+	}{
+		"legacy format": {
+			Input: "default/echoheaders", Service: "default/echoheaders", Scheduler: "wlc", Persistence: 1800,
+			HealthCheck: healthCheck{Type: "TCP_CHECK", ConnectTimeout: 3},
+		},
+		"invalid legacy format": {Input: "echoheaders", ExpectedErr: true},
+		"json defaults": {
+			Input: `{"service": "default/echoheaders"}`, Service: "default/echoheaders", Scheduler: "wlc", Persistence: 1800,
+			HealthCheck: healthCheck{Type: "TCP_CHECK", ConnectTimeout: 3},
+		},
+		"json options": {
+			Input: `{"service": "default/echoheaders", "scheduler": "sh", "persistenceTimeout": 0,
+				"healthCheck": {"type": "SSL_GET", "path": "/healthz", "statusCode": 204, "connectTimeout": 5}}`,
+			Service: "default/echoheaders", Scheduler: "sh", Persistence: 0,
+			HealthCheck: healthCheck{Type: "SSL_GET", Path: "/healthz", StatusCode: 204, ConnectTimeout: 5},
+		},
+		"http check defaults": {
+			Input:   `{"service": "default/echoheaders", "healthCheck": {"type": "HTTP_GET"}}`,
+			Service: "default/echoheaders", Scheduler: "wlc", Persistence: 1800,
+			HealthCheck: healthCheck{Type: "HTTP_GET", Path: "/", StatusCode: 200, ConnectTimeout: 3},
+		},
+		"ipv6 vip": {
+			Input: `{"ip": "2001:db8::50", "service": "default/echoheaders"}`, Service: "default/echoheaders", Scheduler: "wlc", Persistence: 1800,
+			HealthCheck: healthCheck{Type: "TCP_CHECK", ConnectTimeout: 3},
+		},
+		"invalid ip":              {Input: `{"ip": "2001:zz::50", "service": "default/echoheaders"}`, ExpectedErr: true},
+		"invalid json":            {Input: `{"service": }`, ExpectedErr: true},
+		"missing service":         {Input: `{"scheduler": "rr"}`, ExpectedErr: true},
+		"invalid forward method":  {Input: `{"service": "default/echoheaders", "lvsMethod": "AJAX"}`, ExpectedErr: true},
+		"invalid scheduler":       {Input: `{"service": "default/echoheaders", "scheduler": "random"}`, ExpectedErr: true},
+		"invalid persistence":     {Input: `{"service": "default/echoheaders", "persistenceTimeout": -1}`, ExpectedErr: true},
+		"invalid weight":          {Input: `{"service": "default/echoheaders", "nodeWeights": {"node1": -1}}`, ExpectedErr: true},
+		"invalid health check":    {Input: `{"service": "default/echoheaders", "healthCheck": {"type": "PING"}}`, ExpectedErr: true},
+		"invalid check path":      {Input: `{"service": "default/echoheaders", "healthCheck": {"type": "HTTP_GET", "path": "healthz"}}`, ExpectedErr: true},
+		"invalid check status":    {Input: `{"service": "default/echoheaders", "healthCheck": {"type": "HTTP_GET", "statusCode": 42}}`, ExpectedErr: true},
+		"invalid connect timeout": {Input: `{"service": "default/echoheaders", "healthCheck": {"connectTimeout": -3}}`, ExpectedErr: true},
+	}
+
+	for k, tc := range testcases {
+		cfg, err := parseVIPConfig(tc.Input)
+		if tc.ExpectedErr {
+			if err == nil {
+				t.Errorf("%s: expected an error but valid information returned: %v", k, tc.Input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+
+		if cfg.Service != tc.Service {
+			t.Errorf("%s: expected %v but returned %v", k, tc.Service, cfg.Service)
+		}
+		if cfg.LVSMethod != "NAT" {
+			t.Errorf("%s: expected NAT but returned %v", k, cfg.LVSMethod)
+		}
+		if cfg.Scheduler != tc.Scheduler {
+			t.Errorf("%s: expected %v but returned %v", k, tc.Scheduler, cfg.Scheduler)
+		}
+		if *cfg.PersistenceTimeout != tc.Persistence {
+			t.Errorf("%s: expected %v but returned %v", k, tc.Persistence, *cfg.PersistenceTimeout)
+		}
+		if *cfg.HealthCheck != tc.HealthCheck {
+			t.Errorf("%s: expected %+v but returned %+v", k, tc.HealthCheck, *cfg.HealthCheck)
+		}
+	}
+}
+
+func TestBackendWeight(t *testing.T) {
+	cfg, err := parseVIPConfig(`{"service": "default/echoheaders", "weight": 2, "nodeWeights": {"node1": 5}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	node1, node2 := "node1", "node2"
+	if w := cfg.backendWeight(&node1); w != 5 {
+		t.Errorf("expected 5 but returned %v", w)
+	}
+	if w := cfg.backendWeight(&node2); w != 2 {
+		t.Errorf("expected 2 but returned %v", w)
+	}
+	if w := cfg.backendWeight(nil); w != 2 {
+		t.Errorf("expected 2 but returned %v", w)
+	}
+}
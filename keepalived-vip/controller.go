@@ -21,6 +21,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"reflect"
 	"sort"
@@ -31,8 +32,10 @@ import (
 
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/client/record"
 	"k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
 	utildbus "k8s.io/kubernetes/pkg/util/dbus"
 	"k8s.io/kubernetes/pkg/util/exec"
 	"k8s.io/kubernetes/pkg/util/flowcontrol"
@@ -50,8 +53,9 @@ var (
 )
 
 type service struct {
-	IP   string
-	Port int
+	IP     string
+	Port   int
+	Weight int
 }
 
 type serviceByIPPort []service
@@ -71,12 +75,15 @@ func (c serviceByIPPort) Less(i, j int) bool {
 }
 
 type vip struct {
-	Name      string
-	IP        string
-	Port      int
-	Protocol  string
-	LVSMethod string
-	Backends  []service
+	Name               string
+	IP                 string
+	Port               int
+	Protocol           string
+	LVSMethod          string
+	Scheduler          string
+	PersistenceTimeout int
+	HealthCheck        healthCheck
+	Backends           []service
 }
 
 type vipByNameIPPort []vip
@@ -107,14 +114,26 @@ type ipvsControllerController struct {
 	client            *unversioned.Client
 	epController      *cache.Controller
 	svcController     *cache.Controller
+	cmController      *cache.Controller
+	nodeController    *cache.Controller
 	svcLister         cache.StoreToServiceLister
 	epLister          cache.StoreToEndpointsLister
+	cmLister          cache.Store
+	nodeLister        cache.StoreToNodeLister
 	reloadRateLimiter flowcontrol.RateLimiter
+	recorder          record.EventRecorder
 	keepalived        *keepalived
 	configMapName     string
 	ruCfg             []vip
 	ruMD5             string
 
+	// invalidVIPs holds the error of every invalid VIP of the last sync, so
+	// that an event is only recorded when it changes.
+	invalidVIPs map[string]string
+
+	// ruLock protects ruCfg, which is also read by the status endpoint.
+	ruLock sync.Mutex
+
 	// stopLock is used to enforce only a single call to Stop is active.
 	// Needed because we allow stopping through an http endpoint and
 	// allowing concurrent stoppers leads to stack traces.
@@ -126,8 +145,9 @@ type ipvsControllerController struct {
 }
 
 // getEndpoints returns a list of <endpoint ip>:<port> for a given service/target port combination.
+// The weight of each endpoint is taken from the configuration of the VIP.
 func (ipvsc *ipvsControllerController) getEndpoints(
-	s *api.Service, servicePort *api.ServicePort) []service {
+	s *api.Service, servicePort *api.ServicePort, cfg *vipConfig) []service {
 	ep, err := ipvsc.epLister.GetServiceEndpoints(s)
 	if err != nil {
 		glog.Warningf("unexpected error getting service endpoints: %v", err)
@@ -156,7 +176,11 @@ func (ipvsc *ipvsControllerController) getEndpoints(
 				continue
 			}
 			for _, epAddress := range ss.Addresses {
-				endpoints = append(endpoints, service{IP: epAddress.IP, Port: targetPort})
+				endpoints = append(endpoints, service{
+					IP:     epAddress.IP,
+					Port:   targetPort,
+					Weight: cfg.backendWeight(epAddress.NodeName),
+				})
 			}
 		}
 	}
@@ -164,20 +188,52 @@ func (ipvsc *ipvsControllerController) getEndpoints(
 	return endpoints
 }
 
+// endpointsOfFamily returns the endpoints whose address is of the same family
+// as the VIP, since LVS can't forward between IPv4 and IPv6.
+func endpointsOfFamily(endpoints []service, ipv6 bool) []service {
+	var result []service
+	for _, ep := range endpoints {
+		if isIPv6(net.ParseIP(ep.IP)) == ipv6 {
+			result = append(result, ep)
+		}
+	}
+	return result
+}
+
 // getServices returns a list of services and their endpoints.
 func (ipvsc *ipvsControllerController) getServices(cfgMap *api.ConfigMap) []vip {
 	svcs := []vip{}
+	invalidVIPs := map[string]string{}
+	invalidVIP := func(key, message string) {
+		glog.Warningf("VIP %v: %v", key, message)
+		invalidVIPs[key] = message
+		if ipvsc.invalidVIPs[key] != message {
+			ipvsc.recorder.Eventf(cfgMap, api.EventTypeWarning, "InvalidVIP", "VIP %v: %v", key, message)
+		}
+	}
 
 	// k -> IP to use
-	// v -> <namespace>/<service name>:<lvs method>
-	for externalIP, nsSvcLvs := range cfgMap.Data {
-		ns, svc, lvsm, err := parseNsSvcLVS(nsSvcLvs)
+	// v -> <namespace>/<service name>:<lvs method> or a JSON vipConfig
+	for key, value := range cfgMap.Data {
+		cfg, err := parseVIPConfig(value)
 		if err != nil {
-			glog.Warningf("%v", err)
+			invalidVIP(key, err.Error())
 			continue
 		}
 
-		nsSvc := fmt.Sprintf("%v/%v", ns, svc)
+		// IPv6 addresses aren't valid keys, they are set in the value instead
+		externalIP := key
+		if cfg.IP != "" {
+			externalIP = cfg.IP
+		}
+		ip := net.ParseIP(externalIP)
+		if ip == nil {
+			invalidVIP(key, "invalid IP address")
+			continue
+		}
+		externalIP = ip.String()
+
+		nsSvc := cfg.Service
 		svcObj, svcExists, err := ipvsc.svcLister.Indexer.GetByKey(nsSvc)
 		if err != nil {
 			glog.Warningf("error getting service %v: %v", nsSvc, err)
@@ -189,63 +245,111 @@ func (ipvsc *ipvsControllerController) getServices(cfgMap *api.ConfigMap) []vip
 			continue
 		}
 
+		family := "IPv4"
+		if isIPv6(ip) {
+			family = "IPv6"
+		}
 		s := svcObj.(*api.Service)
+		found, mismatch := false, false
 		for _, servicePort := range s.Spec.Ports {
-			ep := ipvsc.getEndpoints(s, &servicePort)
+			ep := ipvsc.getEndpoints(s, &servicePort, cfg)
 			if len(ep) == 0 {
 				glog.Warningf("no endpoints found for service %v, port %+v", s.Name, servicePort)
 				continue
 			}
+			ep = endpointsOfFamily(ep, isIPv6(ip))
+			if len(ep) == 0 {
+				glog.Warningf("no %v endpoints found for service %v, port %+v", family, s.Name, servicePort)
+				mismatch = true
+				continue
+			}
+			found = true
 
 			sort.Sort(serviceByIPPort(ep))
 
 			svcs = append(svcs, vip{
-				Name:      fmt.Sprintf("%v/%v", s.Namespace, s.Name),
-				IP:        externalIP,
-				Port:      int(servicePort.Port),
-				LVSMethod: lvsm,
-				Backends:  ep,
-				Protocol:  fmt.Sprintf("%v", servicePort.Protocol),
+				Name:               fmt.Sprintf("%v/%v", s.Namespace, s.Name),
+				IP:                 externalIP,
+				Port:               int(servicePort.Port),
+				LVSMethod:          cfg.LVSMethod,
+				Scheduler:          cfg.Scheduler,
+				PersistenceTimeout: *cfg.PersistenceTimeout,
+				HealthCheck:        *cfg.HealthCheck,
+				Backends:           ep,
+				Protocol:           fmt.Sprintf("%v", servicePort.Protocol),
 			})
 			glog.V(2).Infof("found service: %v:%v", s.Name, servicePort.Port)
 		}
+		if !found && mismatch {
+			invalidVIP(key, fmt.Sprintf("service %v has no %v endpoints", nsSvc, family))
+		}
 	}
 
 	sort.Sort(vipByNameIPPort(svcs))
+	ipvsc.invalidVIPs = invalidVIPs
 
 	return svcs
 }
 
-func (ipvsc *ipvsControllerController) getConfigMap(ns, name string) (*api.ConfigMap, error) {
-	return ipvsc.client.ConfigMaps(ns).Get(name)
+func (ipvsc *ipvsControllerController) getConfigMap() (*api.ConfigMap, error) {
+	obj, exists, err := ipvsc.cmLister.GetByKey(ipvsc.configMapName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("configmap %v not found", ipvsc.configMapName)
+	}
+	return obj.(*api.ConfigMap), nil
+}
+
+// updateNodes updates the VRRP peers of keepalived with the nodes in the
+// cluster. It returns true if they changed.
+func (ipvsc *ipvsControllerController) updateNodes() bool {
+	k := ipvsc.keepalived
+	clusterNodes := getClusterNodesIP(ipvsc.nodeLister.Store)
+	neighbors6 := getNodeNeighbors(&nodeInfo{ip: k.ip6}, getClusterNodesIPv6(ipvsc.nodeLister.Store))
+	if reflect.DeepEqual(clusterNodes, k.nodes) && reflect.DeepEqual(neighbors6, k.neighbors6) {
+		return false
+	}
+
+	glog.Infof("cluster nodes changed: %v %v", clusterNodes, neighbors6)
+	k.nodes = clusterNodes
+	k.neighbors = getNodeNeighbors(&nodeInfo{ip: k.ip}, clusterNodes)
+	k.neighbors6 = neighbors6
+	k.priority = getNodePriority(k.ip, clusterNodes)
+	return true
 }
 
 // sync all services with the
 func (ipvsc *ipvsControllerController) sync(key string) error {
 	ipvsc.reloadRateLimiter.Accept()
 
-	if !ipvsc.epController.HasSynced() || !ipvsc.svcController.HasSynced() {
+	if !ipvsc.epController.HasSynced() || !ipvsc.svcController.HasSynced() ||
+		!ipvsc.cmController.HasSynced() || !ipvsc.nodeController.HasSynced() {
 		time.Sleep(100 * time.Millisecond)
 		return fmt.Errorf("deferring sync till endpoints controller has synced")
 	}
 
-	ns, name, err := parseNsName(ipvsc.configMapName)
-	if err != nil {
-		glog.Warningf("%v", err)
-		return err
-	}
-	cfgMap, err := ipvsc.getConfigMap(ns, name)
+	cfgMap, err := ipvsc.getConfigMap()
 	if err != nil {
 		return fmt.Errorf("unexpected error searching configmap %v: %v", ipvsc.configMapName, err)
 	}
 
-	svc := ipvsc.getServices(cfgMap)
-	ipvsc.ruCfg = svc
+	ipvsc.updateNodes()
 
+	svc := ipvsc.getServices(cfgMap)
 	err = ipvsc.keepalived.WriteCfg(svc)
+	if cfgErr, ok := err.(*configError); ok {
+		// retrying doesn't help until the services or the ConfigMap change
+		glog.Errorf("keeping the current keepalived configuration: %v", cfgErr)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	ipvsc.ruLock.Lock()
+	ipvsc.ruCfg = svc
+	ipvsc.ruLock.Unlock()
 	glog.V(2).Infof("services: %v", svc)
 
 	md5, err := checksum(keepalivedCfg)
@@ -284,7 +388,7 @@ func (ipvsc *ipvsControllerController) Stop() error {
 }
 
 // newIPVSController creates a new controller from the given config.
-func newIPVSController(kubeClient *unversioned.Client, namespace string, useUnicast bool, configMapName string, vrid int) *ipvsControllerController {
+func newIPVSController(kubeClient *unversioned.Client, namespace string, useUnicast bool, configMapName string, vrid, instances int) *ipvsControllerController {
 	ipvsc := ipvsControllerController{
 		client:            kubeClient,
 		reloadRateLimiter: flowcontrol.NewTokenBucketRateLimiter(reloadQPS, int(reloadQPS)),
@@ -293,6 +397,11 @@ func newIPVSController(kubeClient *unversioned.Client, namespace string, useUnic
 		stopCh:            make(chan struct{}),
 	}
 
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartRecordingToSink(kubeClient.Events(""))
+	ipvsc.recorder = eventBroadcaster.NewRecorder(api.EventSource{Component: "keepalived-vip"})
+
 	podInfo, err := getPodDetails(kubeClient)
 	if err != nil {
 		glog.Fatalf("Error getting POD information: %v", err)
@@ -303,8 +412,15 @@ func newIPVSController(kubeClient *unversioned.Client, namespace string, useUnic
 		glog.Fatalf("Error getting %v: %v", podInfo.PodName, err)
 	}
 
-	selector := parseNodeSelector(pod.Spec.NodeSelector)
-	clusterNodes := getClusterNodesIP(kubeClient, selector)
+	selector, err := labels.Parse(parseNodeSelector(pod.Spec.NodeSelector))
+	if err != nil {
+		glog.Fatalf("'%v' is not a valid selector: %v", parseNodeSelector(pod.Spec.NodeSelector), err)
+	}
+
+	cmNamespace, cmName, err := parseNsName(configMapName)
+	if err != nil {
+		glog.Fatalf("Error using configmap %v: %v", configMapName, err)
+	}
 
 	nodeInfo, err := getNetworkInfo(podInfo.NodeIP)
 	if err != nil {
@@ -315,22 +431,31 @@ func newIPVSController(kubeClient *unversioned.Client, namespace string, useUnic
 		glog.Fatalf("Error using VRID %d, only values between 0 and 255 are allowed.", vrid)
 	}
 
-	neighbors := getNodeNeighbors(nodeInfo, clusterNodes)
+	if instances < 1 || vrid+instances-1 > 255 {
+		glog.Fatalf("Error using %d VRRP instances starting at VRID %d, only VRIDs between 0 and 255 are allowed.", instances, vrid)
+	}
 
 	execer := exec.New()
 	dbus := utildbus.New()
 	iptInterface := utiliptables.New(execer, dbus, utiliptables.ProtocolIpv4)
+	ip6tInterface := utiliptables.New(execer, dbus, utiliptables.ProtocolIpv6)
+
+	configTest := supportsConfigTest()
+	if !configTest {
+		glog.Warningf("%v doesn't support --config-test, new configurations are reloaded without being validated", keepalivedBin)
+	}
 
 	ipvsc.keepalived = &keepalived{
 		iface:      nodeInfo.iface,
 		ip:         nodeInfo.ip,
 		netmask:    nodeInfo.netmask,
-		nodes:      clusterNodes,
-		neighbors:  neighbors,
-		priority:   getNodePriority(nodeInfo.ip, clusterNodes),
+		ip6:        nodeInfo.ip6,
 		useUnicast: useUnicast,
+		configTest: configTest,
 		ipt:        iptInterface,
+		ipt6:       ip6tInterface,
 		vrid:       vrid,
+		instances:  instances,
 	}
 
 	ipvsc.syncQueue = NewTaskQueue(ipvsc.sync)
@@ -367,6 +492,31 @@ func newIPVSController(kubeClient *unversioned.Client, namespace string, useUnic
 			ipvsc.client, "endpoints", namespace, fields.Everything()),
 		&api.Endpoints{}, resyncPeriod, eventHandlers)
 
+	ipvsc.cmLister, ipvsc.cmController = cache.NewInformer(
+		cache.NewListWatchFromClient(
+			ipvsc.client, "configmaps", cmNamespace, fields.OneTermEqualSelector("metadata.name", cmName)),
+		&api.ConfigMap{}, resyncPeriod, eventHandlers)
+
+	// Nodes update their status periodically, only changes of the address
+	// used as VRRP peer need a sync.
+	nodeHandlers := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			ipvsc.syncQueue.enqueue(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			ipvsc.syncQueue.enqueue(obj)
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			if nodeIPChanged(old.(*api.Node), cur.(*api.Node)) {
+				ipvsc.syncQueue.enqueue(cur)
+			}
+		},
+	}
+
+	ipvsc.nodeLister.Store, ipvsc.nodeController = cache.NewInformer(
+		nodeListWatch(kubeClient, selector),
+		&api.Node{}, resyncPeriod, nodeHandlers)
+
 	return &ipvsc
 }
 
@@ -0,0 +1,127 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+// newTestController returns a controller exposing the given services and
+// endpoints, which records events in recorder.
+func newTestController(recorder record.EventRecorder, services []*api.Service, endpoints []*api.Endpoints) *ipvsControllerController {
+	ipvsc := &ipvsControllerController{recorder: recorder}
+	ipvsc.svcLister.Indexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, s := range services {
+		ipvsc.svcLister.Indexer.Add(s)
+	}
+	ipvsc.epLister.Store = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, ep := range endpoints {
+		ipvsc.epLister.Store.Add(ep)
+	}
+	return ipvsc
+}
+
+// recordedEvents returns the events recorded so far.
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestInvalidVIPEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	ipvsc := newTestController(recorder, nil, nil)
+	cfgMap := &api.ConfigMap{Data: map[string]string{}}
+
+	testcases := []struct {
+		value  string
+		events int
+	}{
+		{"echoheaders", 1},
+		// the same error isn't recorded again on every sync
+		{"echoheaders", 0},
+		{`{"service": "default/echoheaders", "scheduler": "random"}`, 1},
+		{"default/echoheaders", 0},
+		// but it is once the VIP becomes invalid again
+		{"echoheaders", 1},
+	}
+	for i, tc := range testcases {
+		cfgMap.Data["10.0.0.1"] = tc.value
+		ipvsc.getServices(cfgMap)
+		if events := recordedEvents(recorder); len(events) != tc.events {
+			t.Errorf("%v: expected %v events, got %v", i, tc.events, events)
+		}
+	}
+}
+
+func TestIPv6VIPWithIPv4Endpoints(t *testing.T) {
+	svc := &api.Service{
+		ObjectMeta: api.ObjectMeta{Name: "echoheaders", Namespace: api.NamespaceDefault},
+		Spec: api.ServiceSpec{Ports: []api.ServicePort{
+			{Port: 80, Protocol: api.ProtocolTCP, TargetPort: intstr.FromInt(8080)},
+		}},
+	}
+	ep := &api.Endpoints{
+		ObjectMeta: api.ObjectMeta{Name: "echoheaders", Namespace: api.NamespaceDefault},
+		Subsets: []api.EndpointSubset{{
+			Addresses: []api.EndpointAddress{{IP: "10.2.0.1"}},
+			Ports:     []api.EndpointPort{{Port: 8080, Protocol: api.ProtocolTCP}},
+		}},
+	}
+	recorder := record.NewFakeRecorder(10)
+	ipvsc := newTestController(recorder, []*api.Service{svc}, []*api.Endpoints{ep})
+	cfgMap := &api.ConfigMap{Data: map[string]string{
+		"10.4.0.50":      "default/echoheaders",
+		"echoheaders-v6": `{"ip": "2001:db8::50", "service": "default/echoheaders"}`,
+	}}
+
+	vips := ipvsc.getServices(cfgMap)
+	if len(vips) != 1 || vips[0].IP != "10.4.0.50" {
+		t.Fatalf("expected the IPv6 VIP without IPv6 endpoints to be skipped, got %+v", vips)
+	}
+	events := recordedEvents(recorder)
+	if len(events) != 1 || !strings.Contains(events[0], "VIP echoheaders-v6: service default/echoheaders has no IPv6 endpoints") {
+		t.Errorf("expected an InvalidVIP event for echoheaders-v6, got %v", events)
+	}
+
+	k := &keepalived{iface: "eth0", ip: "10.0.0.1", nodes: []string{"10.0.0.1"}, vrid: 50,
+		ip6: "2001:db8::1"}
+	if err := k.loadTemplate(); err != nil {
+		t.Fatalf("unexpected error loading the template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := k.render(&buf, vips); err != nil {
+		t.Fatalf("unexpected error rendering the configuration: %v", err)
+	}
+	cfg := buf.String()
+	if !strings.Contains(cfg, "virtual_server 10.4.0.50 80") || strings.Contains(cfg, "2001:db8::50") {
+		t.Errorf("expected only the IPv4 VIP in the configuration:\n%v", cfg)
+	}
+}
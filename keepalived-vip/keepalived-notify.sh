@@ -0,0 +1,32 @@
+#!/bin/bash
+
+# Copyright 2017 The Kubernetes Authors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Called by keepalived when a VRRP instance changes its state, with the
+# arguments: INSTANCE|GROUP <name> MASTER|BACKUP|FAULT|STOP [priority].
+# The state is written to a file named after the instance, which is read by
+# the /status endpoint of kube-keepalived-vip.
+
+set -e
+
+STATE_DIR=/var/run/keepalived-vip
+
+if [ "$1" != "INSTANCE" ]; then
+  exit 0
+fi
+
+mkdir -p ${STATE_DIR}
+echo "$3" > ${STATE_DIR}/$2.tmp
+mv ${STATE_DIR}/$2.tmp ${STATE_DIR}/$2
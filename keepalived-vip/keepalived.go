@@ -19,12 +19,19 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
 	k8sexec "k8s.io/kubernetes/pkg/util/exec"
 	"k8s.io/kubernetes/pkg/util/iptables"
 )
@@ -32,9 +39,34 @@ import (
 const (
 	iptablesChain = "KUBE-KEEPALIVED-VIP"
 	keepalivedCfg = "/etc/keepalived/keepalived.conf"
+	// stableRunPeriod is how long keepalived has to run before the restart
+	// backoff is reset.
+	stableRunPeriod = 5 * time.Minute
 )
 
-var keepalivedTmpl = "keepalived.tmpl"
+var (
+	keepalivedTmpl = "keepalived.tmpl"
+	keepalivedBin  = "keepalived"
+
+	// minRestartBackoff and maxRestartBackoff bound the delay before
+	// keepalived is restarted after exiting.
+	minRestartBackoff = time.Second
+	maxRestartBackoff = time.Minute
+
+	keepalivedCrashes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "keepalived_crashes_total",
+		Help: "Number of times keepalived exited unexpectedly and was restarted.",
+	})
+	keepalivedConfigErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "keepalived_config_errors_total",
+		Help: "Number of configurations rejected by keepalived.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(keepalivedCrashes)
+	prometheus.MustRegister(keepalivedConfigErrors)
+}
 
 type keepalived struct {
 	iface      string
@@ -50,18 +82,101 @@ type keepalived struct {
 	cmd        *exec.Cmd
 	ipt        iptables.Interface
 	vrid       int
+	// configTest is true if keepalived can validate the configuration before
+	// it is reloaded.
+	configTest bool
+	// lock protects cmd, started and stopped, which are updated when
+	// keepalived is restarted.
+	lock    sync.Mutex
+	stopped bool
+	// ip6, neighbors6 and ipt6 are used by the VRRP instances of IPv6 VIPs.
+	ip6        string
+	neighbors6 []string
+	ipt6       iptables.Interface
+	// instances is the number of VRRP instances the VIPs are spread across.
+	instances int
+}
+
+// vrrpInstance is a VRRP instance announcing a subset of the VIPs, with its
+// own virtual router.
+type vrrpInstance struct {
+	Name     string
+	VRID     int
+	Priority int
+	// Preempt allows the node with the highest priority to take over the
+	// VIPs, so that every instance has a different master.
+	Preempt bool
+	// IPv6 is true for the instances announcing IPv6 VIPs. IPv4 and IPv6
+	// VIPs can't be mixed in an instance.
+	IPv6 bool
+	// Prefix is the prefix length of the VIPs.
+	Prefix int
+	VIPs   []string
 }
 
 // WriteCfg creates a new keepalived configuration file.
-// In case of an error with the generation it returns the error
+// In case of an error with the generation it returns the error. If keepalived
+// rejects the new configuration a configError is returned and the current
+// file is kept.
 func (k *keepalived) WriteCfg(svcs []vip) error {
-	w, err := os.Create(keepalivedCfg)
+	tmp := keepalivedCfg + ".new"
+	w, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	err = k.render(w, svcs)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
-	defer w.Close()
 
+	if k.configTest {
+		if err := validateCfg(tmp); err != nil {
+			keepalivedConfigErrors.Inc()
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, keepalivedCfg); err != nil {
+		return err
+	}
 	k.vips = getVIPs(svcs)
+	return nil
+}
+
+// configError is returned when keepalived rejects a configuration.
+type configError struct {
+	err    error
+	output []byte
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("invalid keepalived configuration: %v\n%s", e.err, e.output)
+}
+
+// supportsConfigTest returns true if keepalived can check a configuration
+// file without running it.
+func supportsConfigTest() bool {
+	out, _ := k8sexec.New().Command(keepalivedBin, "--help").CombinedOutput()
+	return strings.Contains(string(out), "--config-test")
+}
+
+// validateCfg checks the configuration file at path with keepalived.
+func validateCfg(path string) error {
+	out, err := k8sexec.New().Command(keepalivedBin, "--config-test", "--use-file", path).CombinedOutput()
+	if err != nil {
+		return &configError{err: err, output: out}
+	}
+	return nil
+}
+
+// render writes the keepalived configuration for svcs to w.
+func (k *keepalived) render(w io.Writer, svcs []vip) error {
+	vips := getVIPs(svcs)
 
 	conf := make(map[string]interface{})
 	conf["iptablesChain"] = iptablesChain
@@ -69,11 +184,15 @@ func (k *keepalived) WriteCfg(svcs []vip) error {
 	conf["myIP"] = k.ip
 	conf["netmask"] = k.netmask
 	conf["svcs"] = svcs
-	conf["vips"] = getVIPs(svcs)
+	conf["vips"] = vips
+	conf["instances"] = k.getInstances(vips)
 	conf["nodes"] = k.neighbors
+	conf["myIP6"] = k.ip6
+	conf["nodes6"] = k.neighbors6
 	conf["priority"] = k.priority
 	conf["useUnicast"] = k.useUnicast
 	conf["vrid"] = k.vrid
+	conf["notify"] = keepalivedNotify
 
 	if glog.V(2) {
 		b, _ := json.Marshal(conf)
@@ -94,8 +213,75 @@ func getVIPs(svcs []vip) []string {
 	return result
 }
 
-// Start starts a keepalived process in foreground.
-// In case of any error it will terminate the execution with a fatal error
+// getInstances spreads the VIPs across the VRRP instances. Each instance uses
+// the next VRID, and the priorities of the nodes are rotated so that every
+// instance prefers a different master. IPv6 VIPs are announced by a separate
+// instance with the same VRID, which is only rendered if there are any.
+func (k *keepalived) getInstances(vips []string) []vrrpInstance {
+	count := k.instances
+	if count < 1 {
+		count = 1
+	}
+
+	instances := make([]vrrpInstance, 2*count)
+	for i := 0; i < count; i++ {
+		name := "vips"
+		priority := getNodePriority(k.ip, k.nodes)
+		if count > 1 {
+			name = fmt.Sprintf("vips-%d", i)
+			priority = getInstancePriority(k.ip, k.nodes, i)
+		}
+		for j, ipv6 := range []bool{false, true} {
+			instance := vrrpInstance{
+				Name:     name,
+				VRID:     k.vrid + i,
+				Priority: priority,
+				Preempt:  count > 1,
+				IPv6:     ipv6,
+				Prefix:   32,
+				VIPs:     []string{},
+			}
+			if ipv6 {
+				instance.Name += "-v6"
+				instance.Prefix = 128
+			}
+			instances[j*count+i] = instance
+		}
+	}
+
+	var vips6 []string
+	for _, vip := range vips {
+		i := vipInstance(vip, count)
+		if isIPv6(net.ParseIP(vip)) {
+			i += count
+			vips6 = append(vips6, vip)
+		}
+		instances[i].VIPs = append(instances[i].VIPs, vip)
+	}
+
+	if len(vips6) == 0 {
+		return instances[:count]
+	}
+	// without a source address the IPv6 instances would fall back to
+	// multicast, and every node would take the VIPs if it's filtered
+	if k.useUnicast && k.ip6 == "" {
+		glog.Errorf("not announcing the IPv6 VIPs %v: --use-unicast requires a global IPv6 address on interface %v", vips6, k.iface)
+		return instances[:count]
+	}
+	return instances
+}
+
+// vipInstance returns the VRRP instance announcing vip. A hash of the address
+// is used so that the instance of a VIP doesn't change when others are added
+// or removed.
+func vipInstance(vip string, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(vip))
+	return int(h.Sum32() % uint32(count))
+}
+
+// Start runs keepalived in foreground, restarting it with a backoff when it
+// exits, until Stop is called.
 func (k *keepalived) Start() {
 	ae, err := k.ipt.EnsureChain(iptables.TableFilter, iptables.Chain(iptablesChain))
 	if err != nil {
@@ -104,31 +290,95 @@ func (k *keepalived) Start() {
 	if ae {
 		glog.V(2).Infof("chain %v already existed", iptablesChain)
 	}
+	// keepalived also adds the IPv6 VIPs to the chain with ip6tables
+	if _, err := k.ipt6.EnsureChain(iptables.TableFilter, iptables.Chain(iptablesChain)); err != nil {
+		glog.Warningf("unexpected error creating ip6tables chain %v: %v", iptablesChain, err)
+	}
+
+	k.supervise()
+}
+
+// supervise runs keepalived until Stop is called. The iptables chain is kept
+// when keepalived is restarted, so the VIPs it owns are still protected.
+func (k *keepalived) supervise() {
+	backoff := minRestartBackoff
+	for {
+		started := time.Now()
+		err := k.run()
+		if k.isStopped() {
+			return
+		}
+
+		keepalivedCrashes.Inc()
+		if time.Since(started) > stableRunPeriod {
+			backoff = minRestartBackoff
+		}
+		glog.Errorf("keepalived exited unexpectedly: %v, restarting in %v", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+	}
+}
 
-	k.cmd = exec.Command("keepalived",
+// run starts a keepalived process and waits for it to exit.
+func (k *keepalived) run() error {
+	// the state of the VRRP instances is notified again by keepalived
+	if err := os.RemoveAll(vrrpStateDir); err != nil {
+		glog.Warningf("unexpected error removing VRRP states: %v", err)
+	}
+
+	cmd := exec.Command(keepalivedBin,
 		"--dont-fork",
 		"--log-console",
 		"--release-vips",
 		"--pid", "/keepalived.pid")
 
-	k.cmd.Stdout = os.Stdout
-	k.cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
 
+	k.lock.Lock()
+	if k.stopped {
+		k.lock.Unlock()
+		return nil
+	}
+	if err := cmd.Start(); err != nil {
+		k.lock.Unlock()
+		return err
+	}
+	k.cmd = cmd
 	k.started = true
+	k.lock.Unlock()
 
-	if err := k.cmd.Start(); err != nil {
-		glog.Errorf("keepalived error: %v", err)
-	}
+	err := cmd.Wait()
 
-	if err := k.cmd.Wait(); err != nil {
-		glog.Fatalf("keepalived error: %v", err)
-	}
+	k.lock.Lock()
+	k.started = false
+	k.lock.Unlock()
+	return err
+}
+
+// isRunning returns true if the keepalived process is running.
+func (k *keepalived) isRunning() bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.started
+}
+
+func (k *keepalived) isStopped() bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.stopped
 }
 
 // Reload sends SIGHUP to keepalived to reload the configuration.
 func (k *keepalived) Reload() error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
 	if !k.started {
-		// TODO: add a warning indicating that keepalived is not started?
+		// the configuration is read when keepalived is (re)started
+		glog.Warningf("keepalived is not running, skipping reload")
 		return nil
 	}
 
@@ -151,7 +401,17 @@ func (k *keepalived) Stop() {
 	if err != nil {
 		glog.V(2).Infof("unexpected error flushing iptables chain %v: %v", err, iptablesChain)
 	}
+	err = k.ipt6.FlushChain(iptables.TableFilter, iptables.Chain(iptablesChain))
+	if err != nil {
+		glog.V(2).Infof("unexpected error flushing ip6tables chain %v: %v", err, iptablesChain)
+	}
 
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.stopped = true
+	if !k.started {
+		return
+	}
 	err = syscall.Kill(k.cmd.Process.Pid, syscall.SIGTERM)
 	if err != nil {
 		glog.Errorf("error stopping keepalived: %v", err)
@@ -170,7 +430,7 @@ func resetIPVS() error {
 
 func (k *keepalived) removeVIP(vip string) error {
 	glog.Infof("removing configured VIP %v", vip)
-	out, err := k8sexec.New().Command("ip", "addr", "del", vip+"/32", "dev", k.iface).CombinedOutput()
+	out, err := k8sexec.New().Command("ip", "addr", "del", fmt.Sprintf("%v/%v", vip, vipPrefix(vip)), "dev", k.iface).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("error reloading keepalived: %v\n%s", err, out)
 	}
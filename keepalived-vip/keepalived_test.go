@@ -0,0 +1,274 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestGetInstances(t *testing.T) {
+	nodes := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	vips := []string{"10.4.0.50", "10.4.0.51", "10.4.0.52", "10.4.0.53", "10.4.0.54"}
+
+	k := &keepalived{ip: "10.0.0.2", nodes: nodes, vrid: 50}
+	instances := k.getInstances(vips)
+	if len(instances) != 1 {
+		t.Fatalf("expected a single instance but %v returned", len(instances))
+	}
+	single := instances[0]
+	if single.Name != "vips" || single.VRID != 50 || single.Priority != 101 || single.Preempt {
+		t.Errorf("unexpected instance %+v", single)
+	}
+	if !reflect.DeepEqual(single.VIPs, vips) {
+		t.Errorf("expected %v but returned %v", vips, single.VIPs)
+	}
+
+	k.instances = 3
+	instances = k.getInstances(vips)
+	if len(instances) != 3 {
+		t.Fatalf("expected 3 instances but %v returned", len(instances))
+	}
+	count := 0
+	for i, instance := range instances {
+		if instance.VRID != 50+i {
+			t.Errorf("expected VRID %v but returned %v", 50+i, instance.VRID)
+		}
+		if !instance.Preempt {
+			t.Errorf("expected instance %v to preempt", instance.Name)
+		}
+		for _, vip := range instance.VIPs {
+			if vipInstance(vip, 3) != i {
+				t.Errorf("expected %v in instance %v", vip, vipInstance(vip, 3))
+			}
+		}
+		count += len(instance.VIPs)
+	}
+	if count != len(vips) {
+		t.Errorf("expected %v VIPs but %v returned", len(vips), count)
+	}
+	if k.getInstances(vips[:1])[vipInstance(vips[0], 3)].VIPs[0] != vips[0] {
+		t.Errorf("expected the instance of a VIP not to depend on the other VIPs")
+	}
+
+	instances = k.getInstances(append(vips, "2001:db8::50"))
+	if len(instances) != 6 {
+		t.Fatalf("expected 6 instances with IPv6 VIPs but %v returned", len(instances))
+	}
+	v6 := instances[3+vipInstance("2001:db8::50", 3)]
+	if !v6.IPv6 || v6.Prefix != 128 || v6.VRID != instances[vipInstance("2001:db8::50", 3)].VRID {
+		t.Errorf("unexpected IPv6 instance %+v", v6)
+	}
+	if !reflect.DeepEqual(v6.VIPs, []string{"2001:db8::50"}) {
+		t.Errorf("expected the IPv6 VIP in %v but returned %v", v6.Name, v6.VIPs)
+	}
+
+	// IPv6 VIPs can't be announced with unicast without an IPv6 address
+	k.useUnicast = true
+	instances = k.getInstances(append(vips, "2001:db8::50"))
+	if len(instances) != 3 {
+		t.Errorf("expected 3 instances without an IPv6 address but %v returned", len(instances))
+	}
+	k.ip6 = "2001:db8::2"
+	instances = k.getInstances(append(vips, "2001:db8::50"))
+	if len(instances) != 6 {
+		t.Errorf("expected 6 instances with an IPv6 address but %v returned", len(instances))
+	}
+}
+
+func TestGetInstancePriority(t *testing.T) {
+	nodes := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	masters := map[string]bool{}
+	for instance := 0; instance < 10; instance++ {
+		master, highest := "", 0
+		for _, node := range nodes {
+			p := getInstancePriority(node, nodes, instance)
+			if p < 100 || p > maxPriority {
+				t.Errorf("expected a priority between 100 and %v but returned %v", maxPriority, p)
+			}
+			if p > highest {
+				master, highest = node, p
+			}
+		}
+		masters[master] = true
+
+		// the priorities don't depend on the other nodes
+		more := append([]string{"10.0.0.0"}, nodes...)
+		for _, node := range nodes {
+			if getInstancePriority(node, nodes, instance) != getInstancePriority(node, more, instance) {
+				t.Errorf("expected the priority of %v in instance %v not to change when a node is added", node, instance)
+			}
+		}
+	}
+	if len(masters) < 2 {
+		t.Errorf("expected the priorities to be rotated, the master of every instance is %v", masters)
+	}
+	if p := getInstancePriority("10.0.0.9", nodes, 1); p != 99 {
+		t.Errorf("expected 99 for an unknown node but returned %v", p)
+	}
+}
+
+func TestGetNodePriority(t *testing.T) {
+	nodes := make([]string, 300)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("10.0.%v.%v", i/256, i%256)
+	}
+	if p := getNodePriority(nodes[1], nodes); p != 101 {
+		t.Errorf("expected 101 but returned %v", p)
+	}
+	if p := getNodePriority(nodes[299], nodes); p != maxPriority {
+		t.Errorf("expected the priority to be at most %v but returned %v", maxPriority, p)
+	}
+}
+
+func TestRender(t *testing.T) {
+	k := &keepalived{iface: "eth0", ip: "10.0.0.1", nodes: []string{"10.0.0.1"}, vrid: 50, useUnicast: true,
+		neighbors: []string{"10.0.0.2"}, ip6: "2001:db8::1", neighbors6: []string{"2001:db8::2"}}
+	if err := k.loadTemplate(); err != nil {
+		t.Fatalf("unexpected error loading the template: %v", err)
+	}
+
+	tcp, _ := parseVIPConfig("default/tcp")
+	http, _ := parseVIPConfig(`{"service": "default/http", "scheduler": "rr", "persistenceTimeout": 0,
+		"healthCheck": {"type": "HTTP_GET", "path": "/healthz"}, "nodeWeights": {"node1": 3}}`)
+	svcs := []vip{
+		{Name: "default/tcp", IP: "10.4.0.50", Port: 80, Protocol: "TCP", LVSMethod: tcp.LVSMethod,
+			Scheduler: tcp.Scheduler, PersistenceTimeout: *tcp.PersistenceTimeout, HealthCheck: *tcp.HealthCheck,
+			Backends: []service{{IP: "10.2.0.1", Port: 8080, Weight: 1}}},
+		{Name: "default/http", IP: "10.4.0.51", Port: 80, Protocol: "TCP", LVSMethod: http.LVSMethod,
+			Scheduler: http.Scheduler, PersistenceTimeout: *http.PersistenceTimeout, HealthCheck: *http.HealthCheck,
+			Backends: []service{{IP: "10.2.0.2", Port: 8080, Weight: 3}}},
+		{Name: "default/tcp", IP: "2001:db8::50", Port: 80, Protocol: "TCP", LVSMethod: tcp.LVSMethod,
+			Scheduler: tcp.Scheduler, PersistenceTimeout: *tcp.PersistenceTimeout, HealthCheck: *tcp.HealthCheck,
+			Backends: []service{{IP: "2001:db8::10", Port: 8080, Weight: 1}}},
+	}
+
+	var buf bytes.Buffer
+	if err := k.render(&buf, svcs); err != nil {
+		t.Fatalf("unexpected error rendering the configuration: %v", err)
+	}
+	cfg := buf.String()
+	for _, expected := range []string{
+		"lvs_sched wlc",
+		"persistence_timeout 1800",
+		"TCP_CHECK {\n      connect_port 8080\n      connect_timeout 3",
+		"lvs_sched rr",
+		"weight 3",
+		"HTTP_GET {\n      url {\n        path /healthz\n        status_code 200",
+		"vrrp_instance vips-v6 {",
+		"native_ipv6",
+		"unicast_src_ip 10.0.0.1\n  unicast_peer { \n    10.0.0.2\n  }",
+		"unicast_src_ip 2001:db8::1\n  unicast_peer { \n    2001:db8::2\n  }",
+		"10.4.0.50/32",
+		"2001:db8::50/128",
+		"virtual_server 2001:db8::50 80",
+		"real_server 2001:db8::10 8080",
+	} {
+		if !strings.Contains(cfg, expected) {
+			t.Errorf("expected %q in the configuration:\n%v", expected, cfg)
+		}
+	}
+	if strings.Count(cfg, "persistence_timeout") != 2 {
+		t.Errorf("expected persistence to be disabled for default/http:\n%v", cfg)
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatalf("unexpected error reading counter: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestSupervise(t *testing.T) {
+	defer func(bin, stateDir string, min, max time.Duration) {
+		keepalivedBin, vrrpStateDir, minRestartBackoff, maxRestartBackoff = bin, stateDir, min, max
+	}(keepalivedBin, vrrpStateDir, minRestartBackoff, maxRestartBackoff)
+	keepalivedBin = "false"
+	minRestartBackoff, maxRestartBackoff = time.Millisecond, 4*time.Millisecond
+
+	// the VRRP states are removed every time keepalived is started
+	dir, err := ioutil.TempDir("", "keepalived-vip")
+	if err != nil {
+		t.Fatalf("unexpected error creating a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	vrrpStateDir = filepath.Join(dir, "states")
+	if err := os.Mkdir(vrrpStateDir, 0755); err != nil {
+		t.Fatalf("unexpected error creating %v: %v", vrrpStateDir, err)
+	}
+
+	crashes := counterValue(t, keepalivedCrashes)
+	k := &keepalived{}
+	done := make(chan struct{})
+	go func() {
+		k.supervise()
+		close(done)
+	}()
+
+	// keepalived is restarted every time it exits
+	for i := 0; counterValue(t, keepalivedCrashes) < crashes+3; i++ {
+		if i == 100 {
+			t.Fatalf("expected keepalived to be restarted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := k.Reload(); err != nil {
+		t.Errorf("unexpected error reloading a stopped keepalived: %v", err)
+	}
+	if _, err := os.Stat(vrrpStateDir); !os.IsNotExist(err) {
+		t.Errorf("expected %v to be removed, got %v", vrrpStateDir, err)
+	}
+
+	k.lock.Lock()
+	k.stopped = true
+	k.lock.Unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the supervisor to stop")
+	}
+	if k.isRunning() {
+		t.Errorf("expected keepalived not to be running")
+	}
+}
+
+func TestValidateCfg(t *testing.T) {
+	defer func(bin string) { keepalivedBin = bin }(keepalivedBin)
+
+	keepalivedBin = "true"
+	if err := validateCfg(keepalivedCfg); err != nil {
+		t.Errorf("unexpected error validating a valid configuration: %v", err)
+	}
+
+	keepalivedBin = "false"
+	err := validateCfg(keepalivedCfg)
+	if _, ok := err.(*configError); !ok {
+		t.Errorf("expected a configError but returned %v", err)
+	}
+}
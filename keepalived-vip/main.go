@@ -61,6 +61,15 @@ var (
 		`The keepalived VRID (Virtual Router Identifier, between 0 and 255 as per
 			RFC-5798), which must be different for every Virtual Router (ie. every
 			keepalived sets) running on the same network.`)
+
+	statusPort = flags.Int("status-port", 8899,
+		`Port used to serve /healthz, /status with the state of the VRRP instances and
+		IPVS, and /metrics.`)
+
+	vrrpInstances = flags.Int("vrrp-instances", 1,
+		`Number of VRRP instances the VIPs are spread across, using consecutive VRIDs
+		starting at --vrid. The priorities of the nodes are rotated in each instance
+		so that the VIPs are announced by different nodes.`)
 )
 
 func main() {
@@ -122,16 +131,24 @@ func main() {
 	if *useUnicast {
 		glog.Info("keepalived will use unicast to sync the nodes")
 	}
-	ipvsc := newIPVSController(kubeClient, namespace, *useUnicast, *configMapName, *vrid)
+	ipvsc := newIPVSController(kubeClient, namespace, *useUnicast, *configMapName, *vrid, *vrrpInstances)
 	go ipvsc.epController.Run(wait.NeverStop)
 	go ipvsc.svcController.Run(wait.NeverStop)
+	go ipvsc.cmController.Run(wait.NeverStop)
+	go ipvsc.nodeController.Run(wait.NeverStop)
 
 	go ipvsc.syncQueue.run(time.Second, ipvsc.stopCh)
 
 	go handleSigterm(ipvsc)
 
+	go ipvsc.serveStatus(*statusPort)
+
 	glog.Info("starting keepalived to announce VIPs")
 	ipvsc.keepalived.Start()
+
+	// Start only returns once the controller is stopped, handleSigterm exits
+	// after the VIPs are released.
+	select {}
 }
 
 func handleSigterm(ipvsc *ipvsControllerController) {
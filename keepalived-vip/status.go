@@ -0,0 +1,302 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ipvsTable is the kernel table of the IPVS virtual and real servers.
+	ipvsTable = "/proc/net/ip_vs"
+	// keepalivedNotify is the script keepalived runs on VRRP state changes.
+	keepalivedNotify = "/keepalived-notify.sh"
+)
+
+var (
+	// vrrpStateDir is where keepalivedNotify writes the state of each VRRP
+	// instance, in a file named after the instance.
+	vrrpStateDir = "/var/run/keepalived-vip"
+)
+
+// vrrpStates are the states reported by keepalived notify scripts.
+var vrrpStates = []string{"MASTER", "BACKUP", "FAULT", "STOP"}
+
+// ipvsRealServer is a real server in the IPVS table.
+type ipvsRealServer struct {
+	Address      string `json:"address"`
+	Forward      string `json:"forward"`
+	Weight       int    `json:"weight"`
+	ActiveConn   int    `json:"activeConn"`
+	InactiveConn int    `json:"inactiveConn"`
+}
+
+// ipvsVirtualServer is a virtual server in the IPVS table.
+type ipvsVirtualServer struct {
+	Protocol    string           `json:"protocol"`
+	Address     string           `json:"address"`
+	Scheduler   string           `json:"scheduler"`
+	RealServers []ipvsRealServer `json:"realServers"`
+}
+
+// status is the state of the node reported by the /status endpoint.
+type status struct {
+	VRRP   map[string]string   `json:"vrrp"`
+	Config []vip               `json:"config"`
+	IPVS   []ipvsVirtualServer `json:"ipvs"`
+}
+
+// readVRRPStates returns the state of each VRRP instance notified by
+// keepalived.
+func readVRRPStates(dir string) (map[string]string, error) {
+	states := map[string]string{}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		// the notify script writes to a temporary file before renaming it
+		if strings.HasSuffix(file.Name(), ".tmp") {
+			continue
+		}
+		state, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		states[file.Name()] = strings.TrimSpace(string(state))
+	}
+
+	return states, nil
+}
+
+// readIPVSTable returns the IPVS virtual servers configured in the kernel.
+func readIPVSTable() ([]ipvsVirtualServer, error) {
+	f, err := os.Open(ipvsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseIPVSTable(f)
+}
+
+// parseIPVSTable parses the content of /proc/net/ip_vs.
+func parseIPVSTable(r io.Reader) ([]ipvsVirtualServer, error) {
+	servers := []ipvsVirtualServer{}
+	scanner := bufio.NewScanner(r)
+	for line := 0; scanner.Scan(); line++ {
+		// the first lines are the version and the headers
+		if line < 3 {
+			continue
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "->" {
+			if len(servers) == 0 || len(fields) < 6 {
+				return nil, fmt.Errorf("unexpected IPVS real server '%v'", scanner.Text())
+			}
+			address, err := parseIPVSAddress(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			rs := ipvsRealServer{Address: address, Forward: fields[2]}
+			for i, value := range []*int{&rs.Weight, &rs.ActiveConn, &rs.InactiveConn} {
+				if *value, err = strconv.Atoi(fields[3+i]); err != nil {
+					return nil, fmt.Errorf("unexpected IPVS real server '%v': %v", scanner.Text(), err)
+				}
+			}
+			vs := &servers[len(servers)-1]
+			vs.RealServers = append(vs.RealServers, rs)
+			continue
+		}
+
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("unexpected IPVS virtual server '%v'", scanner.Text())
+		}
+		vs := ipvsVirtualServer{Protocol: fields[0], Address: fields[1], Scheduler: fields[2], RealServers: []ipvsRealServer{}}
+		// firewall marks have no address
+		if vs.Protocol != "FWM" {
+			address, err := parseIPVSAddress(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			vs.Address = address
+		}
+		servers = append(servers, vs)
+	}
+
+	return servers, scanner.Err()
+}
+
+// parseIPVSAddress converts an address of the IPVS table, an hexadecimal IPv4
+// address or an IPv6 address in brackets followed by an hexadecimal port, to
+// the ip:port format.
+func parseIPVSAddress(address string) (string, error) {
+	i := strings.LastIndex(address, ":")
+	if i < 0 {
+		return "", fmt.Errorf("invalid IPVS address %v", address)
+	}
+	port, err := strconv.ParseUint(address[i+1:], 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid IPVS address %v: %v", address, err)
+	}
+
+	var ip net.IP
+	host := address[:i]
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		ip = net.ParseIP(host[1 : len(host)-1])
+	} else if b, err := hex.DecodeString(host); err == nil && len(b) == net.IPv4len {
+		ip = net.IP(b)
+	}
+	if ip == nil {
+		return "", fmt.Errorf("invalid IPVS address %v", address)
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(port, 10)), nil
+}
+
+// getStatus returns the state of the VRRP instances and IPVS on the node.
+func (ipvsc *ipvsControllerController) getStatus() (*status, error) {
+	states, err := readVRRPStates(vrrpStateDir)
+	if err != nil {
+		return nil, err
+	}
+	table, err := readIPVSTable()
+	if err != nil {
+		return nil, err
+	}
+
+	ipvsc.ruLock.Lock()
+	defer ipvsc.ruLock.Unlock()
+	return &status{VRRP: states, Config: ipvsc.ruCfg, IPVS: table}, nil
+}
+
+// healthzHandler reports whether keepalived is running.
+func (ipvsc *ipvsControllerController) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if !ipvsc.keepalived.isRunning() {
+		http.Error(w, "keepalived is not running", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, "ok")
+}
+
+// statusHandler serves the status of the node as JSON.
+func (ipvsc *ipvsControllerController) statusHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := ipvsc.getStatus()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		glog.Errorf("error encoding status: %v", err)
+	}
+}
+
+var (
+	vrrpStateDesc = prometheus.NewDesc(
+		"keepalived_vrrp_state",
+		"State of the VRRP instances on the node, 1 for the current state.",
+		[]string{"instance", "state"}, nil)
+	ipvsWeightDesc = prometheus.NewDesc(
+		"keepalived_ipvs_real_server_weight",
+		"Weight of the IPVS real servers.",
+		[]string{"protocol", "virtual_server", "real_server"}, nil)
+	ipvsActiveConnDesc = prometheus.NewDesc(
+		"keepalived_ipvs_active_connections",
+		"Active connections to the IPVS real servers.",
+		[]string{"protocol", "virtual_server", "real_server"}, nil)
+	ipvsInactiveConnDesc = prometheus.NewDesc(
+		"keepalived_ipvs_inactive_connections",
+		"Inactive connections to the IPVS real servers.",
+		[]string{"protocol", "virtual_server", "real_server"}, nil)
+)
+
+// statusCollector exports the status of the node as Prometheus gauges.
+type statusCollector struct {
+	ipvsc *ipvsControllerController
+}
+
+// Describe implements prometheus.Collector.
+func (c statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- vrrpStateDesc
+	ch <- ipvsWeightDesc
+	ch <- ipvsActiveConnDesc
+	ch <- ipvsInactiveConnDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c statusCollector) Collect(ch chan<- prometheus.Metric) {
+	s, err := c.ipvsc.getStatus()
+	if err != nil {
+		glog.Warningf("error collecting status: %v", err)
+		return
+	}
+
+	for instance, current := range s.VRRP {
+		for _, state := range vrrpStates {
+			value := 0.0
+			if state == current {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(vrrpStateDesc, prometheus.GaugeValue, value, instance, state)
+		}
+	}
+
+	for _, vs := range s.IPVS {
+		for _, rs := range vs.RealServers {
+			labels := []string{vs.Protocol, vs.Address, rs.Address}
+			ch <- prometheus.MustNewConstMetric(ipvsWeightDesc, prometheus.GaugeValue, float64(rs.Weight), labels...)
+			ch <- prometheus.MustNewConstMetric(ipvsActiveConnDesc, prometheus.GaugeValue, float64(rs.ActiveConn), labels...)
+			ch <- prometheus.MustNewConstMetric(ipvsInactiveConnDesc, prometheus.GaugeValue, float64(rs.InactiveConn), labels...)
+		}
+	}
+}
+
+// serveStatus serves /healthz, /status and /metrics on the given port.
+func (ipvsc *ipvsControllerController) serveStatus(port int) {
+	prometheus.MustRegister(statusCollector{ipvsc: ipvsc})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", ipvsc.healthzHandler)
+	mux.HandleFunc("/status", ipvsc.statusHandler)
+	mux.Handle("/metrics", prometheus.Handler())
+	glog.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", port), mux))
+}
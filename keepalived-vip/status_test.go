@@ -0,0 +1,114 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testIPVSTable = `IP Virtual Server version 1.2.1 (size=4096)
+Prot LocalAddress:Port Scheduler Flags
+  -> RemoteAddress:Port Forward Weight ActiveConn InActConn
+TCP  0A040032:0050 wlc persistent 1800
+  -> 0A020005:1F90      Masq    1      3          12
+  -> 0A020006:1F90      Masq    2      0          1
+UDP  [2001:0db8:0000:0000:0000:0000:0000:0032]:0035 rr
+  -> [2001:0db8:0000:0000:0000:0000:0000:0005]:0035      Route   1      0          0
+FWM  00000001 wlc
+`
+
+func TestParseIPVSTable(t *testing.T) {
+	servers, err := parseIPVSTable(strings.NewReader(testIPVSTable))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []ipvsVirtualServer{
+		{Protocol: "TCP", Address: "10.4.0.50:80", Scheduler: "wlc", RealServers: []ipvsRealServer{
+			{Address: "10.2.0.5:8080", Forward: "Masq", Weight: 1, ActiveConn: 3, InactiveConn: 12},
+			{Address: "10.2.0.6:8080", Forward: "Masq", Weight: 2, ActiveConn: 0, InactiveConn: 1},
+		}},
+		{Protocol: "UDP", Address: "[2001:db8::32]:53", Scheduler: "rr", RealServers: []ipvsRealServer{
+			{Address: "[2001:db8::5]:53", Forward: "Route", Weight: 1},
+		}},
+		{Protocol: "FWM", Address: "00000001", Scheduler: "wlc", RealServers: []ipvsRealServer{}},
+	}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Errorf("expected %+v but returned %+v", expected, servers)
+	}
+
+	invalid := strings.Join(strings.Split(testIPVSTable, "\n")[:3], "\n") + "\n  -> 0A020005:1F90 Masq 1 0 0\n"
+	if _, err := parseIPVSTable(strings.NewReader(invalid)); err == nil {
+		t.Errorf("expected an error for a real server without virtual server")
+	}
+}
+
+func TestParseIPVSAddress(t *testing.T) {
+	testcases := map[string]struct {
+		Input      string
+		Address    string
+		ExpectedOk bool
+	}{
+		"ipv4":         {"0A040032:0050", "10.4.0.50:80", true},
+		"ipv6":         {"[2001:0db8::0032]:01BB", "[2001:db8::32]:443", true},
+		"missing port": {"0A040032", "", false},
+		"invalid ipv4": {"0A0400:0050", "", false},
+		"invalid ipv6": {"[2001:zz::1]:0050", "", false},
+		"invalid port": {"0A040032:ZZ", "", false},
+	}
+
+	for k, tc := range testcases {
+		address, err := parseIPVSAddress(tc.Input)
+		if tc.ExpectedOk != (err == nil) {
+			t.Errorf("%s: unexpected error %v - input %v", k, err, tc.Input)
+		}
+		if address != tc.Address {
+			t.Errorf("%s: expected %v but returned %v - input %v", k, tc.Address, address, tc.Input)
+		}
+	}
+}
+
+func TestReadVRRPStates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vrrp")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "vips-0"), []byte("MASTER\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "vips-1"), []byte("BACKUP\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "vips-1.tmp"), []byte("FAULT\n"), 0644)
+
+	states, err := readVRRPStates(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{"vips-0": "MASTER", "vips-1": "BACKUP"}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected %v but returned %v", expected, states)
+	}
+
+	states, err = readVRRPStates(filepath.Join(dir, "missing"))
+	if err != nil || len(states) != 0 {
+		t.Errorf("expected no states before keepalived notifies them, returned %v: %v", states, err)
+	}
+}
@@ -19,6 +19,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"net"
 	"os"
 	"regexp"
@@ -29,13 +30,16 @@ import (
 	"github.com/golang/glog"
 	"k8s.io/kubernetes/pkg/api"
 	apierrs "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/client/cache"
 	"k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
 	k8sexec "k8s.io/kubernetes/pkg/util/exec"
 	"k8s.io/kubernetes/pkg/util/node"
 	"k8s.io/kubernetes/pkg/util/sysctl"
 	"k8s.io/kubernetes/pkg/util/wait"
 	"k8s.io/kubernetes/pkg/util/workqueue"
+	"k8s.io/kubernetes/pkg/watch"
 )
 
 var (
@@ -49,6 +53,8 @@ type nodeInfo struct {
 	iface   string
 	ip      string
 	netmask int
+	// ip6 is the global IPv6 address of the interface, if any.
+	ip6 string
 }
 
 // getNetworkInfo returns information of the node where the pod is running
@@ -58,6 +64,7 @@ func getNetworkInfo(ip string) (*nodeInfo, error) {
 		iface:   iface,
 		ip:      ip,
 		netmask: mask,
+		ip6:     ipv6ByInterface(iface),
 	}, nil
 }
 
@@ -131,41 +138,79 @@ func netInterfaces() []net.Interface {
 }
 
 // interfaceByIP returns the local network interface name that is using the
-// specified IP address. If no interface is found returns an empty string.
+// specified IPv4 or IPv6 address. If no interface is found returns an empty string.
 func interfaceByIP(ip string) (string, string, int) {
 	for _, iface := range netInterfaces() {
-		ifaceIP, mask, err := ipByInterface(iface.Name)
-		if err == nil && ip == ifaceIP {
-			return iface.Name, ip, mask
+		addrs, err := ipsByInterface(iface.Name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(net.ParseIP(ip)) {
+				mask, _ := addr.Mask.Size()
+				return iface.Name, ip, mask
+			}
 		}
 	}
 
 	return "", "", 0
 }
 
-func ipByInterface(name string) (string, int, error) {
+// ipsByInterface returns the addresses of a network interface, except
+// loopback ones.
+func ipsByInterface(name string) ([]*net.IPNet, error) {
 	iface, err := net.InterfaceByName(name)
 	if err != nil {
-		return "", 32, err
+		return nil, err
 	}
 
 	addrs, err := iface.Addrs()
 	if err != nil {
-		return "", 32, err
+		return nil, err
 	}
 
+	ips := []*net.IPNet{}
 	for _, a := range addrs {
 		if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				ip := ipnet.IP.String()
-				ones, _ := ipnet.Mask.Size()
-				mask := ones
-				return ip, mask, nil
-			}
+			ips = append(ips, ipnet)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, errors.New("Found no IP addresses.")
+	}
+	return ips, nil
+}
+
+// ipv6ByInterface returns the first global IPv6 address of a network
+// interface. If there is none returns an empty string.
+func ipv6ByInterface(name string) string {
+	addrs, err := ipsByInterface(name)
+	if err != nil {
+		return ""
+	}
+
+	for _, addr := range addrs {
+		if isIPv6(addr.IP) && addr.IP.IsGlobalUnicast() {
+			return addr.IP.String()
 		}
 	}
 
-	return "", 32, errors.New("Found no IPv4 addresses.")
+	return ""
+}
+
+// isIPv6 returns true if ip is an IPv6 address.
+func isIPv6(ip net.IP) bool {
+	return ip != nil && ip.To4() == nil
+}
+
+// vipPrefix returns the prefix length of a VIP: 32 for an IPv4 address and
+// 128 for an IPv6 address.
+func vipPrefix(vip string) int {
+	if isIPv6(net.ParseIP(vip)) {
+		return 128
+	}
+	return 32
 }
 
 type stringSlice []string
@@ -182,27 +227,26 @@ func (slice stringSlice) pos(value string) int {
 	return -1
 }
 
-// getClusterNodesIP returns the IP address of each node in the kubernetes cluster
-func getClusterNodesIP(kubeClient *unversioned.Client, nodeSelector string) (clusterNodes []string) {
-	listOpts := api.ListOptions{}
-
-	if nodeSelector != "" {
-		label, err := labels.Parse(nodeSelector)
-		if err != nil {
-			glog.Fatalf("'%v' is not a valid selector: %v", nodeSelector, err)
+// getClusterNodesIP returns the IP address of each node in the store
+func getClusterNodesIP(store cache.Store) (clusterNodes []string) {
+	for _, obj := range store.List() {
+		nodeIP, err := node.GetNodeHostIP(obj.(*api.Node))
+		// IPv6 peers are only used by the IPv6 VRRP instances
+		if err == nil && !isIPv6(nodeIP) {
+			clusterNodes = append(clusterNodes, nodeIP.String())
 		}
-		listOpts.LabelSelector = label
 	}
+	sort.Strings(clusterNodes)
 
-	nodes, err := kubeClient.Nodes().List(listOpts)
-	if err != nil {
-		glog.Fatalf("Error getting running nodes: %v", err)
-	}
+	return
+}
 
-	for _, nodo := range nodes.Items {
-		nodeIP, err := node.GetNodeHostIP(&nodo)
-		if err == nil {
-			clusterNodes = append(clusterNodes, nodeIP.String())
+// getClusterNodesIPv6 returns the IPv6 address of each node in the store
+// which has one.
+func getClusterNodesIPv6(store cache.Store) (clusterNodes []string) {
+	for _, obj := range store.List() {
+		if nodeIP := getNodeIPv6(obj.(*api.Node)); nodeIP != "" {
+			clusterNodes = append(clusterNodes, nodeIP)
 		}
 	}
 	sort.Strings(clusterNodes)
@@ -210,6 +254,46 @@ func getClusterNodesIP(kubeClient *unversioned.Client, nodeSelector string) (clu
 	return
 }
 
+// getNodeIPv6 returns the first IPv6 address of a node, preferring internal
+// addresses. If there is none returns an empty string.
+func getNodeIPv6(n *api.Node) string {
+	for _, addressType := range []api.NodeAddressType{api.NodeInternalIP, api.NodeExternalIP} {
+		for _, address := range n.Status.Addresses {
+			if address.Type == addressType && isIPv6(net.ParseIP(address.Address)) {
+				return net.ParseIP(address.Address).String()
+			}
+		}
+	}
+	return ""
+}
+
+// nodeIPChanged returns true if the IPv4 or IPv6 address of a node changed.
+func nodeIPChanged(old, cur *api.Node) bool {
+	if getNodeIPv6(old) != getNodeIPv6(cur) {
+		return true
+	}
+	oldIP, oldErr := node.GetNodeHostIP(old)
+	curIP, curErr := node.GetNodeHostIP(cur)
+	if oldErr != nil || curErr != nil {
+		return (oldErr == nil) != (curErr == nil)
+	}
+	return !oldIP.Equal(curIP)
+}
+
+// nodeListWatch returns a ListWatch of the nodes matching the selector.
+func nodeListWatch(kubeClient *unversioned.Client, selector labels.Selector) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector
+			return kubeClient.Nodes().List(options)
+		},
+		WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector
+			return kubeClient.Nodes().Watch(options)
+		},
+	}
+}
+
 // getNodeNeighbors returns a list of IP address of the nodes
 func getNodeNeighbors(nodeInfo *nodeInfo, clusterNodes []string) (neighbors []string) {
 	for _, neighbor := range clusterNodes {
@@ -221,10 +305,36 @@ func getNodeNeighbors(nodeInfo *nodeInfo, clusterNodes []string) (neighbors []st
 	return
 }
 
+const (
+	// maxPriority is the highest priority of a VRRP backup, 255 is reserved
+	// for the owner of the VIPs.
+	maxPriority = 254
+	// instancePriorities is the number of priorities, starting at 100, the
+	// nodes are spread across in each VRRP instance.
+	instancePriorities = 150
+)
+
 // getPriority returns the priority of one node using the
 // IP address as key. It starts in 100
 func getNodePriority(ip string, nodes []string) int {
-	return 100 + stringSlice(nodes).pos(ip)
+	priority := 100 + stringSlice(nodes).pos(ip)
+	if priority > maxPriority {
+		return maxPriority
+	}
+	return priority
+}
+
+// getInstancePriority returns the priority of one node in a VRRP instance.
+// It's a hash of the IP address of the node and the index of the instance,
+// so the node with the highest priority is different in each instance, and
+// doesn't change when other nodes are added or removed.
+func getInstancePriority(ip string, nodes []string, instance int) int {
+	if stringSlice(nodes).pos(ip) < 0 {
+		return getNodePriority(ip, nodes)
+	}
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%v/%v", ip, instance)
+	return 100 + int(h.Sum32()%instancePriorities)
 }
 
 // loadIPVModule load module require to use keepalived
@@ -17,7 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"reflect"
 	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
 )
 
 func TestParseNsSvcLVS(t *testing.T) {
@@ -56,3 +60,72 @@ func TestParseNsSvcLVS(t *testing.T) {
 		}
 	}
 }
+
+func newTestNode(name, ip string) *api.Node {
+	return &api.Node{
+		ObjectMeta: api.ObjectMeta{Name: name},
+		Status: api.NodeStatus{
+			Addresses: []api.NodeAddress{{Type: api.NodeInternalIP, Address: ip}},
+		},
+	}
+}
+
+func TestGetClusterNodesIP(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store.Add(newTestNode("node2", "10.0.0.2"))
+	store.Add(newTestNode("node1", "10.0.0.1"))
+	store.Add(&api.Node{ObjectMeta: api.ObjectMeta{Name: "no-address"}})
+
+	expected := []string{"10.0.0.1", "10.0.0.2"}
+	if nodes := getClusterNodesIP(store); !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("expected %v but returned %v", expected, nodes)
+	}
+}
+
+func TestGetClusterNodesIPv6(t *testing.T) {
+	dualStack := newTestNode("node1", "10.0.0.1")
+	dualStack.Status.Addresses = append(dualStack.Status.Addresses,
+		api.NodeAddress{Type: api.NodeExternalIP, Address: "2001:db8::ff"},
+		api.NodeAddress{Type: api.NodeInternalIP, Address: "2001:0db8::1"})
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store.Add(dualStack)
+	store.Add(newTestNode("node2", "10.0.0.2"))
+
+	expected := []string{"2001:db8::1"}
+	if nodes := getClusterNodesIPv6(store); !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("expected %v but returned %v", expected, nodes)
+	}
+	expected = []string{"10.0.0.1", "10.0.0.2"}
+	if nodes := getClusterNodesIP(store); !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("expected %v but returned %v", expected, nodes)
+	}
+}
+
+func TestVIPPrefix(t *testing.T) {
+	if p := vipPrefix("10.4.0.50"); p != 32 {
+		t.Errorf("expected 32 for an IPv4 VIP but returned %v", p)
+	}
+	if p := vipPrefix("2001:db8::50"); p != 128 {
+		t.Errorf("expected 128 for an IPv6 VIP but returned %v", p)
+	}
+}
+
+func TestNodeIPChanged(t *testing.T) {
+	node := newTestNode("node1", "10.0.0.1")
+	heartbeat := newTestNode("node1", "10.0.0.1")
+	heartbeat.Status.Conditions = []api.NodeCondition{{Type: api.NodeReady, Status: api.ConditionTrue}}
+	if nodeIPChanged(node, heartbeat) {
+		t.Errorf("expected a status update not to change the IP address")
+	}
+	if !nodeIPChanged(node, newTestNode("node1", "10.0.0.5")) {
+		t.Errorf("expected a new address to change the IP address")
+	}
+	if !nodeIPChanged(node, &api.Node{ObjectMeta: api.ObjectMeta{Name: "node1"}}) {
+		t.Errorf("expected a removed address to change the IP address")
+	}
+	dualStack := newTestNode("node1", "10.0.0.1")
+	dualStack.Status.Addresses = append(dualStack.Status.Addresses, api.NodeAddress{Type: api.NodeInternalIP, Address: "2001:db8::1"})
+	if !nodeIPChanged(node, dualStack) {
+		t.Errorf("expected a new IPv6 address to change the IP address")
+	}
+}
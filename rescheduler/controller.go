@@ -0,0 +1,414 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	ca_simulator "k8s.io/contrib/cluster-autoscaler/simulator"
+	kube_utils "k8s.io/contrib/cluster-autoscaler/utils/kubernetes"
+	"k8s.io/contrib/rescheduler/metrics"
+	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+	policyv1 "k8s.io/kubernetes/pkg/apis/policy/v1beta1"
+	kube_client "k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
+
+	"github.com/golang/glog"
+)
+
+// nodeNameIndex indexes the pods of the cache by the node they run on.
+const nodeNameIndex = "nodeName"
+
+func nodeNameIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*apiv1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("expected Pod, got %#v", obj)
+	}
+	if pod.Spec.NodeName == "" {
+		return []string{}, nil
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// nodeLister lists the ready nodes of the cluster.
+type nodeLister interface {
+	List() ([]*apiv1.Node, error)
+}
+
+// pdbLister lists the pod disruption budgets of the cluster.
+type pdbLister interface {
+	List() ([]*policyv1.PodDisruptionBudget, error)
+}
+
+// podCache is a cache of the pods of the cluster which haven't terminated,
+// indexed by node.
+type podCache struct {
+	indexer cache.Indexer
+}
+
+func newPodInformer(client kube_client.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	selector := fields.ParseSelectorOrDie("status.phase!=" + string(apiv1.PodSucceeded) +
+		",status.phase!=" + string(apiv1.PodFailed))
+	podListWatch := cache.NewListWatchFromClient(client.CoreV1().RESTClient(), "pods", apiv1.NamespaceAll, selector)
+	return cache.NewSharedIndexInformer(podListWatch, &apiv1.Pod{}, resyncPeriod,
+		cache.Indexers{nodeNameIndex: nodeNameIndexFunc})
+}
+
+// podsOnNode returns the pods running on the node. They are shared with the
+// cache, and must not be modified.
+func (c *podCache) podsOnNode(nodeName string) ([]*apiv1.Pod, error) {
+	objs, err := c.indexer.ByIndex(nodeNameIndex, nodeName)
+	if err != nil {
+		return []*apiv1.Pod{}, err
+	}
+	pods := make([]*apiv1.Pod, 0, len(objs))
+	for _, obj := range objs {
+		pods = append(pods, obj.(*apiv1.Pod))
+	}
+	return pods, nil
+}
+
+// isUnschedulable returns true if the scheduler failed to find a node for pod.
+func isUnschedulable(pod *apiv1.Pod) bool {
+	if pod.Spec.NodeName != "" {
+		return false
+	}
+	_, condition := apiv1.GetPodCondition(&pod.Status, apiv1.PodScheduled)
+	return condition != nil && condition.Status == apiv1.ConditionFalse && condition.Reason == "Unschedulable"
+}
+
+// rescheduler makes room for unschedulable critical pods. Pods are watched
+// through a shared informer, and unschedulable critical pods are processed
+// one at a time from a work queue.
+type rescheduler struct {
+	client           kube_client.Interface
+	recorder         kube_record.EventRecorder
+	predicateChecker *ca_simulator.PredicateChecker
+	policy           *criticalPodPolicy
+	decisions        *decisionLog
+
+	podInformer cache.SharedIndexInformer
+	pods        *podCache
+	nodeLister  nodeLister
+	pdbLister   pdbLister
+
+	// queue holds the keys of the pods to process.
+	queue workqueue.RateLimitingInterface
+
+	// lock is held while processing a pod or releasing taints, so that the
+	// taints of the pods being processed are never released.
+	lock               sync.Mutex
+	podsBeingProcessed *podSet
+	// deadlines holds until when each pod being processed is waited for.
+	deadlines map[string]time.Time
+	// attempts holds the attempts to make room for critical pods which aren't
+	// scheduled yet.
+	attempts map[string]*podAttempts
+}
+
+// podAttempts tracks the attempts to make room for a critical pod.
+type podAttempts struct {
+	count              int
+	unschedulableSince time.Time
+}
+
+// unschedulableSince returns when the scheduler found pod unschedulable.
+func unschedulableSince(pod *apiv1.Pod, now time.Time) time.Time {
+	_, condition := apiv1.GetPodCondition(&pod.Status, apiv1.PodScheduled)
+	if condition == nil || condition.LastTransitionTime.IsZero() {
+		return now
+	}
+	return condition.LastTransitionTime.Time
+}
+
+func newRescheduler(client kube_client.Interface, recorder kube_record.EventRecorder,
+	predicateChecker *ca_simulator.PredicateChecker, policy *criticalPodPolicy, decisions *decisionLog,
+	stopChannel <-chan struct{}) *rescheduler {
+	podInformer := newPodInformer(client, *housekeepingInterval)
+	r := &rescheduler{
+		client:             client,
+		recorder:           recorder,
+		predicateChecker:   predicateChecker,
+		policy:             policy,
+		decisions:          decisions,
+		podInformer:        podInformer,
+		pods:               &podCache{indexer: podInformer.GetIndexer()},
+		nodeLister:         kube_utils.NewReadyNodeLister(client, stopChannel),
+		pdbLister:          kube_utils.NewPodDisruptionBudgetLister(client, stopChannel),
+		queue:              workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "rescheduler"),
+		podsBeingProcessed: NewPodSet(),
+		deadlines:          make(map[string]time.Time),
+		attempts:           make(map[string]*podAttempts),
+	}
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    r.enqueuePod,
+		UpdateFunc: func(old, cur interface{}) { r.enqueuePod(cur) },
+		DeleteFunc: r.enqueuePod,
+	})
+	return r
+}
+
+// enqueuePod queues critical pods, and pods which are being processed so that
+// their taints are released once they're scheduled.
+func (r *rescheduler) enqueuePod(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*apiv1.Pod)
+	if !ok {
+		glog.Warningf("Unexpected object %#v", obj)
+		return
+	}
+	if !r.podsBeingProcessed.Has(pod) && !r.policy.isCritical(pod) {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		glog.Warningf("Failed to get key of pod %s: %v", podId(pod), err)
+		return
+	}
+	r.queue.Add(key)
+}
+
+// Run processes pods until stopChannel is closed.
+func (r *rescheduler) Run(stopChannel <-chan struct{}) {
+	defer r.queue.ShutDown()
+
+	go r.podInformer.Run(stopChannel)
+	if !cache.WaitForCacheSync(stopChannel, r.podInformer.HasSynced) {
+		glog.Errorf("Failed to sync the pod cache")
+		return
+	}
+
+	if *dryRun {
+		glog.Infof("Running in dry-run mode, nodes won't be tainted and pods won't be evicted")
+	} else {
+		// As tolerations/taints feature changed from being specified in annotations
+		// to being specified in fields in Kubernetes 1.6, we need to make sure that
+		// any annotations that were created in the previous versions are removed.
+		releaseAllTaintsDeprecated(r.client, r.nodeLister)
+
+		// Keep the taints added by a previous leader for pods which may still
+		// be scheduled, and release the others.
+		r.resumeWaitingForPods()
+		go wait.Until(r.releaseTaints, *housekeepingInterval, stopChannel)
+	}
+
+	wait.Until(r.worker, time.Second, stopChannel)
+}
+
+func (r *rescheduler) releaseTaints() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	releaseAllTaints(r.client, r.nodeLister, r.podsBeingProcessed)
+}
+
+// resumeWaitingForPods waits for the critical pods that nodes are tainted
+// for, until the deadline stored on the node. It lets a new leader take over
+// the pods the previous one was waiting for.
+func (r *rescheduler) resumeWaitingForPods() {
+	nodes, err := r.nodeLister.List()
+	if err != nil {
+		glog.Warningf("Cannot resume waiting for pods - error while listing nodes: %v", err)
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := time.Now()
+	for _, pod := range resumePodsOnNodes(nodes, r.podsBeingProcessed, now) {
+		r.waitForScheduled(pod.pod, now.Add(pod.timeout))
+	}
+}
+
+// waitForScheduled marks pod as being processed until deadline. The pod is
+// processed again when it's scheduled, or once the deadline has passed.
+func (r *rescheduler) waitForScheduled(pod *apiv1.Pod, deadline time.Time) {
+	glog.Infof("Waiting for pod %s to be scheduled", podId(pod))
+	r.podsBeingProcessed.Add(pod)
+	r.deadlines[podId(pod)] = deadline
+	metrics.PodsBeingProcessed.Set(float64(r.podsBeingProcessed.Len()))
+	r.queue.AddAfter(pod.Namespace+"/"+pod.Name, deadline.Sub(time.Now()))
+}
+
+// doneWaiting marks pod as not being processed anymore. It returns true if it was.
+func (r *rescheduler) doneWaiting(pod *apiv1.Pod) bool {
+	if !r.podsBeingProcessed.Has(pod) {
+		return false
+	}
+	r.podsBeingProcessed.Remove(pod)
+	delete(r.deadlines, podId(pod))
+	metrics.PodsBeingProcessed.Set(float64(r.podsBeingProcessed.Len()))
+	return true
+}
+
+func (r *rescheduler) worker() {
+	for r.processNextPod() {
+	}
+}
+
+func (r *rescheduler) processNextPod() bool {
+	key, quit := r.queue.Get()
+	if quit {
+		return false
+	}
+	defer r.queue.Done(key)
+
+	if err := r.syncPod(key.(string)); err != nil {
+		glog.Warningf("%+v", err)
+		r.queue.AddRateLimited(key)
+	} else {
+		r.queue.Forget(key)
+	}
+	return true
+}
+
+// syncPod tries to make room for the pod with the given key if it's an
+// unschedulable critical pod, and releases the taints added for it once it's
+// scheduled or it was waited for long enough.
+func (r *rescheduler) syncPod(key string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	obj, exists, err := r.pods.indexer.GetByKey(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		pod := &apiv1.Pod{}
+		pod.Namespace, pod.Name = namespace, name
+		delete(r.attempts, podId(pod))
+		if r.doneWaiting(pod) {
+			glog.Infof("Pod %s was deleted before being scheduled.", podId(pod))
+			r.releaseTaintsLocked()
+		}
+		return nil
+	}
+	pod := obj.(*apiv1.Pod)
+
+	if pod.Spec.NodeName != "" {
+		attempts, found := r.attempts[podId(pod)]
+		delete(r.attempts, podId(pod))
+		if found {
+			glog.Infof("Pod %v was scheduled after %d attempts, %v after being unschedulable.",
+				podId(pod), attempts.count, time.Since(attempts.unschedulableSince))
+		}
+		if r.doneWaiting(pod) {
+			glog.Infof("Pod %v was successfully scheduled.", podId(pod))
+			// Only pods room was made for count, not the ones scheduled on
+			// their own after a dry run or a failed attempt.
+			if found {
+				metrics.CriticalPodSchedulingLatency.Observe(time.Since(attempts.unschedulableSince).Seconds())
+			}
+			r.releaseTaintsLocked()
+		}
+		return nil
+	}
+
+	if r.podsBeingProcessed.Has(pod) {
+		if deadline := r.deadlines[podId(pod)]; time.Now().Before(deadline) {
+			r.queue.AddAfter(key, deadline.Sub(time.Now()))
+			return nil
+		}
+		glog.Warningf("Timeout while waiting for pod %s to be scheduled after %v.", podId(pod), *podScheduledTimeout)
+		metrics.SchedulingTimeoutsCount.Inc()
+		r.doneWaiting(pod)
+		r.releaseTaintsLocked()
+	}
+
+	if !isUnschedulable(pod) || !r.policy.isCritical(pod) {
+		return nil
+	}
+	return r.reschedulePod(pod)
+}
+
+// releaseTaintsLocked releases the taints of the pods not being processed
+// anymore. The caller must hold the lock.
+func (r *rescheduler) releaseTaintsLocked() {
+	if !*dryRun {
+		releaseAllTaints(r.client, r.nodeLister, r.podsBeingProcessed)
+	}
+}
+
+// reschedulePod makes room for an unschedulable critical pod on the cheapest
+// node, and waits for it to be scheduled there.
+func (r *rescheduler) reschedulePod(pod *apiv1.Pod) error {
+	start := time.Now()
+	attempts, found := r.attempts[podId(pod)]
+	if !found {
+		attempts = &podAttempts{unschedulableSince: unschedulableSince(pod, start)}
+		r.attempts[podId(pod)] = attempts
+	}
+	attempts.count++
+	glog.Infof("Critical pod %s is unschedulable. Trying to find a spot for it, attempt %d.", podId(pod), attempts.count)
+	k8sApp := "unknown"
+	if l, found := pod.ObjectMeta.Labels["k8s-app"]; found {
+		k8sApp = l
+	}
+	metrics.UnschedulableCriticalPodsCount.WithLabelValues(k8sApp).Inc()
+	nodes, err := r.nodeLister.List()
+	if err != nil {
+		return fmt.Errorf("Failed to list nodes: %v", err)
+	}
+	pdbs, err := r.pdbLister.List()
+	if err != nil {
+		return fmt.Errorf("Failed to list pod disruption budgets: %v", err)
+	}
+	decision, err := r.placePod(pod, nodes, pdbs)
+	decision.Attempt = attempts.count
+	decision.Duration = time.Since(start).Seconds()
+	r.decisions.Add(decision)
+	metrics.AttemptDuration.Observe(decision.Duration)
+	metrics.AttemptsCount.WithLabelValues(decision.result()).Inc()
+	return err
+}
+
+func (r *rescheduler) placePod(pod *apiv1.Pod, nodes []*apiv1.Node, pdbs []*policyv1.PodDisruptionBudget) (*decision, error) {
+	best, runnerUp := findNodeForPod(r.pods, r.predicateChecker, r.policy, nodes, pdbs, pod)
+	decision := newDecision(pod, best, runnerUp, *dryRun)
+	if best == nil {
+		if !*dryRun {
+			r.recorder.Eventf(pod, apiv1.EventTypeNormal, "PodDoestFitAnyNode",
+				"Critical pod %s doesn't fit on any of the %d nodes.", podId(pod), len(nodes))
+		}
+		return decision, fmt.Errorf("Pod %s can't be scheduled on any existing node.", podId(pod))
+	}
+	choice := describeChoice(best, runnerUp)
+	if *dryRun {
+		glog.Infof("Would place the pod on node %v, evicting %v. %s", best.node.Name, decision.Victims, choice)
+		return decision, nil
+	}
+	glog.Infof("Trying to place the pod on node %v. %s", best.node.Name, choice)
+
+	deadline := time.Now().Add(*podScheduledTimeout)
+	err := prepareNodeForPod(r.client, r.recorder, r.predicateChecker, r.pods, r.policy, best.node, pdbs, pod, choice, deadline)
+	if err != nil {
+		decision.Error = err.Error()
+		return decision, err
+	}
+	r.waitForScheduled(pod, deadline)
+	return decision, nil
+}
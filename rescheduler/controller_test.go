@@ -0,0 +1,217 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	core "k8s.io/client-go/testing"
+	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	"k8s.io/contrib/rescheduler/metrics"
+	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+	policyv1 "k8s.io/kubernetes/pkg/apis/policy/v1beta1"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/clientset/fake"
+)
+
+type testNodeLister []*apiv1.Node
+
+func (l testNodeLister) List() ([]*apiv1.Node, error) {
+	return l, nil
+}
+
+type testPdbLister []*policyv1.PodDisruptionBudget
+
+func (l testPdbLister) List() ([]*policyv1.PodDisruptionBudget, error) {
+	return l, nil
+}
+
+func markUnschedulable(pod *apiv1.Pod) *apiv1.Pod {
+	pod.Status.Conditions = []apiv1.PodCondition{{
+		Type:   apiv1.PodScheduled,
+		Status: apiv1.ConditionFalse,
+		Reason: "Unschedulable",
+	}}
+	return pod
+}
+
+func newTestRescheduler(client *fake.Clientset, nodes ...*apiv1.Node) *rescheduler {
+	return &rescheduler{
+		client:             client,
+		recorder:           kube_record.NewFakeRecorder(10),
+		predicateChecker:   simulator.NewTestPredicateChecker(),
+		policy:             testPolicy,
+		decisions:          NewDecisionLog(),
+		pods:               newTestPodCache(),
+		nodeLister:         testNodeLister(nodes),
+		pdbLister:          testPdbLister{},
+		queue:              workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		podsBeingProcessed: NewPodSet(),
+		deadlines:          make(map[string]time.Time),
+		attempts:           make(map[string]*podAttempts),
+	}
+}
+
+func TestEnqueuePod(t *testing.T) {
+	r := newTestRescheduler(&fake.Clientset{})
+	defer r.queue.ShutDown()
+
+	r.enqueuePod(markUnschedulable(createTestPod("random", false, 100)))
+	assert.Equal(t, 0, r.queue.Len())
+
+	r.enqueuePod(markUnschedulable(createTestPod("heapster", true, 100)))
+	assert.Equal(t, 1, r.queue.Len())
+	key, _ := r.queue.Get()
+	assert.Equal(t, "kube-system/heapster", key)
+	r.queue.Done(key)
+
+	// scheduled critical pods are queued too, to stop tracking their attempts
+	scheduled := createTestPod("scheduled", true, 100)
+	scheduled.Spec.NodeName = "node1"
+	r.enqueuePod(scheduled)
+	assert.Equal(t, 1, r.queue.Len())
+
+	// as are pods being processed, to release their taints
+	random := createTestPod("random", false, 100)
+	random.Spec.NodeName = "node1"
+	r.podsBeingProcessed.Add(random)
+	r.enqueuePod(random)
+	assert.Equal(t, 2, r.queue.Len())
+}
+
+// scheduledCount returns the number of pods observed in the scheduling latency.
+func scheduledCount() uint64 {
+	var m dto.Metric
+	metrics.CriticalPodSchedulingLatency.Write(&m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestSyncPod(t *testing.T) {
+	evictions := 0
+	updatedNodes := make(chan string, 10)
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		obj := action.(core.UpdateAction).GetObject().(*apiv1.Node)
+		updatedNodes <- obj.Name
+		return true, obj, nil
+	})
+	fakeClient.Fake.AddReactor("post", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		evictions++
+		return true, nil, nil
+	})
+
+	node := createTestNode("node1", 1000)
+	r := newTestRescheduler(fakeClient, node)
+	defer r.queue.ShutDown()
+	addTestPodsToCache(r.pods, "node1", []apiv1.Pod{*createTestPod("p1", false, 800)})
+	pod := markUnschedulable(createTestPod("heapster", true, 500))
+	unschedulableSince := time.Now().Add(-time.Minute)
+	pod.Status.Conditions[0].LastTransitionTime = metav1.NewTime(unschedulableSince)
+	r.pods.indexer.Add(pod)
+
+	// room is made for the pod, which is then waited for
+	assert.NoError(t, r.syncPod("kube-system/heapster"))
+	assert.Equal(t, 1, evictions)
+	assert.Equal(t, "node1", getStringFromChan(updatedNodes))
+	assert.Equal(t, criticalAddonsOnlyTaintKey, node.Spec.Taints[0].Key)
+	assert.True(t, r.podsBeingProcessed.Has(pod))
+	assert.Equal(t, 1, r.podsBeingProcessed.Len())
+	assert.Equal(t, "node1", r.decisions.List()[0].Node)
+	assert.Equal(t, 1, r.decisions.List()[0].Attempt)
+	assert.Equal(t, "placed", r.decisions.List()[0].result())
+	assert.Equal(t, 1, r.attempts["kube-system_heapster"].count)
+	assert.True(t, r.attempts["kube-system_heapster"].unschedulableSince.Equal(unschedulableSince))
+
+	// nothing happens until the pod is scheduled or the deadline passes
+	assert.NoError(t, r.syncPod("kube-system/heapster"))
+	assert.Equal(t, 1, evictions)
+	assert.Equal(t, "Nothing returned", getStringFromChan(updatedNodes))
+
+	// the taint is released once the pod is scheduled
+	scheduled := *pod
+	scheduled.Spec.NodeName = "node1"
+	scheduled.Status.Conditions = nil
+	r.pods.indexer.Update(&scheduled)
+	count := scheduledCount()
+	assert.NoError(t, r.syncPod("kube-system/heapster"))
+	assert.Equal(t, count+1, scheduledCount())
+	assert.False(t, r.podsBeingProcessed.Has(pod))
+	assert.Equal(t, 0, r.podsBeingProcessed.Len())
+	assert.Equal(t, "node1", getStringFromChan(updatedNodes))
+	assert.Empty(t, node.Spec.Taints)
+	assert.Empty(t, r.attempts)
+
+	// pods which don't fit anywhere are retried
+	r.pods.indexer.Add(markUnschedulable(createTestPod("huge", true, 2000)))
+	assert.Error(t, r.syncPod("kube-system/huge"))
+	assert.Error(t, r.syncPod("kube-system/huge"))
+	assert.Equal(t, 2, r.decisions.List()[0].Attempt)
+	assert.Equal(t, "no_node", r.decisions.List()[0].result())
+	// and aren't observed once scheduled, no room was made for them
+	huge := createTestPod("huge", true, 2000)
+	huge.Spec.NodeName = "node1"
+	r.pods.indexer.Update(huge)
+	assert.NoError(t, r.syncPod("kube-system/huge"))
+	assert.Equal(t, count+1, scheduledCount())
+	assert.Empty(t, r.attempts)
+	r.pods.indexer.Delete(huge)
+	assert.NoError(t, r.syncPod("kube-system/huge"))
+	assert.Empty(t, r.attempts)
+	assert.NoError(t, r.syncPod("kube-system/missing"))
+}
+
+func TestSyncPodTimeout(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		return true, action.(core.UpdateAction).GetObject(), nil
+	})
+	node := createTestNode("node1", 1000)
+	addTaintToNode(node, "kube-system_heapster")
+	r := newTestRescheduler(fakeClient, node)
+	defer r.queue.ShutDown()
+	pod := markUnschedulable(createTestPod("heapster", true, 500))
+	r.pods.indexer.Add(pod)
+	r.waitForScheduled(pod, time.Now().Add(-time.Second))
+
+	// the taint is released, and room is made again for the pod
+	assert.NoError(t, r.syncPod("kube-system/heapster"))
+	assert.True(t, r.podsBeingProcessed.Has(pod))
+	assert.Equal(t, 1, len(node.Spec.Taints))
+	assert.True(t, r.deadlines["kube-system_heapster"].After(time.Now()))
+}
+
+func TestSyncDeletedPod(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		return true, action.(core.UpdateAction).GetObject(), nil
+	})
+	node := createTestNode("node1", 1000)
+	addTaintToNode(node, "kube-system_heapster")
+	r := newTestRescheduler(fakeClient, node)
+	defer r.queue.ShutDown()
+	r.waitForScheduled(createTestPod("heapster", true, 500), time.Now().Add(time.Minute))
+
+	assert.NoError(t, r.syncPod("kube-system/heapster"))
+	assert.False(t, r.podsBeingProcessed.HasId("kube-system_heapster"))
+	assert.Empty(t, node.Spec.Taints)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/labels"
+	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+
+	"github.com/golang/glog"
+)
+
+// podPriorityAnnotationKey is the annotation holding the priority of a pod.
+// The vendored API predates the priority field of pods, so the value of
+// their priority class has to be copied there, e.g. by an admission webhook.
+const podPriorityAnnotationKey = "rescheduler.alpha.kubernetes.io/priority"
+
+// getPodPriority returns the priority of pod, 0 if it has none.
+func getPodPriority(pod *apiv1.Pod) int32 {
+	value, found := pod.Annotations[podPriorityAnnotationKey]
+	if !found {
+		return 0
+	}
+	priority, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		glog.V(4).Infof("Ignoring invalid priority %q of pod %s: %v", value, podId(pod), err)
+		return 0
+	}
+	return int32(priority)
+}
+
+// criticalPodPolicy decides which pods are critical. Pods with the critical
+// pod annotation are critical in the system namespace only, pods matching
+// selector or with at least minPriority are critical in any namespace.
+type criticalPodPolicy struct {
+	systemNamespace string
+	// selector is nil when pods aren't selected by labels.
+	selector labels.Selector
+	// minPriority is 0 when pods aren't selected by priority.
+	minPriority int32
+}
+
+func newCriticalPodPolicy(systemNamespace, selector string, minPriority int32) (*criticalPodPolicy, error) {
+	policy := &criticalPodPolicy{systemNamespace: systemNamespace, minPriority: minPriority}
+	if selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid critical pod selector %q: %v", selector, err)
+		}
+		policy.selector = parsed
+	}
+	if minPriority < 0 {
+		return nil, fmt.Errorf("invalid critical pod minimum priority %d", minPriority)
+	}
+	return policy, nil
+}
+
+func (p *criticalPodPolicy) isCritical(pod *apiv1.Pod) bool {
+	if _, found := pod.Annotations[criticalPodAnnotation]; found && pod.Namespace == p.systemNamespace {
+		return true
+	}
+	if p.selector != nil && p.selector.Matches(labels.Set(pod.Labels)) {
+		return true
+	}
+	return p.minPriority > 0 && getPodPriority(pod) >= p.minPriority
+}
+
+// canEvict returns true if pod can be evicted to make room for criticalPod.
+// Critical pods can't be evicted, nor pods of at least the priority of
+// criticalPod when it has one.
+func (p *criticalPodPolicy) canEvict(pod, criticalPod *apiv1.Pod) bool {
+	if p.isCritical(pod) {
+		return false
+	}
+	if _, found := criticalPod.Annotations[podPriorityAnnotationKey]; !found {
+		return true
+	}
+	return getPodPriority(pod) < getPodPriority(criticalPod)
+}
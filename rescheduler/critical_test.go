@@ -0,0 +1,118 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+)
+
+func createPriorityTestPod(name, namespace, priority string) *apiv1.Pod {
+	pod := createTestPod(name, false, 100)
+	pod.Namespace = namespace
+	if priority != "" {
+		pod.Annotations = map[string]string{podPriorityAnnotationKey: priority}
+	}
+	return pod
+}
+
+func TestGetPodPriority(t *testing.T) {
+	assert.Equal(t, int32(0), getPodPriority(createPriorityTestPod("p1", "default", "")))
+	assert.Equal(t, int32(1000), getPodPriority(createPriorityTestPod("p2", "default", "1000")))
+	assert.Equal(t, int32(-5), getPodPriority(createPriorityTestPod("p3", "default", "-5")))
+	assert.Equal(t, int32(0), getPodPriority(createPriorityTestPod("p4", "default", "high")))
+}
+
+func TestCriticalPodPolicy(t *testing.T) {
+	_, err := newCriticalPodPolicy("kube-system", "tier in (", 0)
+	assert.Error(t, err)
+	_, err = newCriticalPodPolicy("kube-system", "", -1)
+	assert.Error(t, err)
+
+	policy, err := newCriticalPodPolicy("kube-system", "", 0)
+	assert.NoError(t, err)
+
+	addon := createTestPod("addon", true, 100)
+	annotated := createTestPod("annotated", true, 100)
+	annotated.Namespace = "default"
+	tier0 := createPriorityTestPod("tier0", "default", "")
+	tier0.Labels = map[string]string{"tier": "0"}
+	important := createPriorityTestPod("important", "default", "1000")
+
+	assert.True(t, policy.isCritical(addon))
+	assert.False(t, policy.isCritical(annotated))
+	assert.False(t, policy.isCritical(tier0))
+	assert.False(t, policy.isCritical(important))
+
+	policy, err = newCriticalPodPolicy("kube-system", "tier=0", 1000)
+	assert.NoError(t, err)
+	assert.True(t, policy.isCritical(addon))
+	assert.False(t, policy.isCritical(annotated))
+	assert.True(t, policy.isCritical(tier0))
+	assert.True(t, policy.isCritical(important))
+	assert.False(t, policy.isCritical(createPriorityTestPod("less-important", "default", "999")))
+}
+
+func TestCanEvict(t *testing.T) {
+	policy, _ := newCriticalPodPolicy("kube-system", "", 1000)
+	low := createPriorityTestPod("low", "default", "10")
+	high := createPriorityTestPod("high", "default", "500")
+	none := createPriorityTestPod("none", "default", "")
+
+	// critical addons without a priority can evict any pod which isn't critical
+	addon := createTestPod("addon", true, 100)
+	assert.True(t, policy.canEvict(low, addon))
+	assert.True(t, policy.canEvict(high, addon))
+	assert.False(t, policy.canEvict(createTestPod("other-addon", true, 100), addon))
+
+	// pods of at least the priority of the critical pod can't be evicted
+	criticalPod := createPriorityTestPod("critical", "default", "500")
+	assert.True(t, policy.canEvict(low, criticalPod))
+	assert.True(t, policy.canEvict(none, criticalPod))
+	assert.False(t, policy.canEvict(high, criticalPod))
+	assert.False(t, policy.canEvict(createPriorityTestPod("important", "default", "1000"), criticalPod))
+}
+
+func TestGroupPodsByPriority(t *testing.T) {
+	policy, _ := newCriticalPodPolicy("kube-system", "", 0)
+	podsOnNode := []apiv1.Pod{
+		*createPriorityTestPod("low", "default", "10"),
+		*createPriorityTestPod("high", "default", "500"),
+		*createTestPod("addon", true, 100),
+	}
+	pods := newTestPodCache()
+	addTestPodsToCache(pods, "node", podsOnNode)
+
+	requiredPods, otherPods, err := groupPods(pods, policy, createTestNode("node", 1000),
+		createPriorityTestPod("critical", "default", "100"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(requiredPods))
+	assert.True(t, podSetOf(requiredPods).HasId("default_high"))
+	assert.True(t, podSetOf(requiredPods).HasId("kube-system_addon"))
+	assert.Equal(t, 1, len(otherPods))
+	assert.Equal(t, "low", otherPods[0].Name)
+}
+
+func podSetOf(pods []*apiv1.Pod) *podSet {
+	set := NewPodSet()
+	for _, pod := range pods {
+		set.Add(pod)
+	}
+	return set
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+)
+
+// maxDecisions is the number of pods decisions are kept for.
+const maxDecisions = 100
+
+// decision is what the rescheduler decided to do for an unschedulable critical pod.
+type decision struct {
+	Time     time.Time `json:"time"`
+	Pod      string    `json:"pod"`
+	DryRun   bool      `json:"dryRun"`
+	Node     string    `json:"node,omitempty"`
+	Victims  []string  `json:"victims,omitempty"`
+	RunnerUp string    `json:"runnerUp,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	// Attempt is the number of attempts to make room for the pod so far.
+	Attempt int `json:"attempt"`
+	// Duration is how long the attempt took, in seconds.
+	Duration float64 `json:"durationSeconds"`
+}
+
+func newDecision(pod *apiv1.Pod, best, runnerUp *nodeCost, dryRun bool) *decision {
+	d := &decision{
+		Time:   time.Now(),
+		Pod:    podId(pod),
+		DryRun: dryRun,
+	}
+	if best == nil {
+		d.Error = "doesn't fit on any node"
+		return d
+	}
+	d.Node = best.node.Name
+	for _, victim := range best.victims {
+		d.Victims = append(d.Victims, podId(victim))
+	}
+	if runnerUp != nil {
+		d.RunnerUp = runnerUp.node.Name
+	}
+	return d
+}
+
+// result returns the result of the attempt, as reported in metrics.
+func (d *decision) result() string {
+	switch {
+	case d.Node == "":
+		return "no_node"
+	case d.Error != "":
+		return "error"
+	case d.DryRun:
+		return "dry_run"
+	}
+	return "placed"
+}
+
+// decisionLog is a thread safe record of the latest decision taken for each
+// critical pod.
+type decisionLog struct {
+	decisions map[string]*decision
+	mutex     sync.Mutex
+}
+
+// NewDecisionLog creates new instance of decisionLog.
+func NewDecisionLog() *decisionLog {
+	return &decisionLog{
+		decisions: make(map[string]*decision),
+	}
+}
+
+// Add records d as the latest decision for its pod, forgetting the oldest
+// decision if there are too many.
+func (l *decisionLog) Add(d *decision) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.decisions[d.Pod] = d
+	if len(l.decisions) > maxDecisions {
+		oldest := d
+		for _, other := range l.decisions {
+			if other.Time.Before(oldest.Time) {
+				oldest = other
+			}
+		}
+		delete(l.decisions, oldest.Pod)
+	}
+}
+
+// List returns the latest decisions, from the newest to the oldest.
+func (l *decisionLog) List() []*decision {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	decisions := make([]*decision, 0, len(l.decisions))
+	for _, d := range l.decisions {
+		decisions = append(decisions, d)
+	}
+	sort.Sort(decisionsByTime(decisions))
+	return decisions
+}
+
+// ServeHTTP serves the latest decisions as JSON.
+func (l *decisionLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(l.List()); err != nil {
+		glog.Warningf("Failed to serve decisions: %v", err)
+	}
+}
+
+type decisionsByTime []*decision
+
+func (d decisionsByTime) Len() int      { return len(d) }
+func (d decisionsByTime) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d decisionsByTime) Less(i, j int) bool {
+	if !d[i].Time.Equal(d[j].Time) {
+		return d[i].Time.After(d[j].Time)
+	}
+	return d[i].Pod < d[j].Pod
+}
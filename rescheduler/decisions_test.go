@@ -0,0 +1,77 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+)
+
+func TestNewDecision(t *testing.T) {
+	pod := createTestPod("critical", true, 500)
+	best := newNodeCost(createTestNode("node1", 1000), []*apiv1.Pod{createTestPod("p1", false, 100)})
+	runnerUp := newNodeCost(createTestNode("node2", 1000), []*apiv1.Pod{createTestPod("p2", false, 200)})
+
+	d := newDecision(pod, best, runnerUp, true)
+	assert.Equal(t, "kube-system_critical", d.Pod)
+	assert.True(t, d.DryRun)
+	assert.Equal(t, "node1", d.Node)
+	assert.Equal(t, []string{"kube-system_p1"}, d.Victims)
+	assert.Equal(t, "node2", d.RunnerUp)
+	assert.Empty(t, d.Error)
+
+	d = newDecision(pod, nil, nil, false)
+	assert.Empty(t, d.Node)
+	assert.NotEmpty(t, d.Error)
+}
+
+func TestDecisionResult(t *testing.T) {
+	assert.Equal(t, "placed", (&decision{Node: "node1"}).result())
+	assert.Equal(t, "dry_run", (&decision{Node: "node1", DryRun: true}).result())
+	assert.Equal(t, "error", (&decision{Node: "node1", Error: "failed"}).result())
+	assert.Equal(t, "no_node", (&decision{Error: "no node"}).result())
+}
+
+func TestDecisionLog(t *testing.T) {
+	log := NewDecisionLog()
+	now := time.Now()
+	for i := 0; i < maxDecisions+1; i++ {
+		log.Add(&decision{Time: now.Add(time.Duration(i) * time.Second), Pod: fmt.Sprintf("pod%d", i)})
+	}
+	// a newer decision replaces the previous one for the same pod
+	log.Add(&decision{Time: now.Add(time.Hour), Pod: "pod5", Node: "node"})
+
+	decisions := log.List()
+	assert.Equal(t, maxDecisions, len(decisions))
+	assert.Equal(t, "pod5", decisions[0].Pod)
+	assert.Equal(t, "node", decisions[0].Node)
+	assert.Equal(t, "pod1", decisions[len(decisions)-1].Pod)
+
+	recorder := httptest.NewRecorder()
+	log.ServeHTTP(recorder, httptest.NewRequest("GET", "/decisions", nil))
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var served []decision
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+	assert.Equal(t, maxDecisions, len(served))
+	assert.Equal(t, "pod5", served[0].Pod)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+	policyv1 "k8s.io/kubernetes/pkg/apis/policy/v1beta1"
+	kube_client "k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
+)
+
+// checkPdbs returns an error if evicting all the pods at once would disrupt
+// more pods than allowed by one of the pod disruption budgets.
+func checkPdbs(pods []*apiv1.Pod, pdbs []*policyv1.PodDisruptionBudget) error {
+	for _, pdb := range pdbs {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			return err
+		}
+		disrupted := int32(0)
+		for _, pod := range pods {
+			if pod.Namespace == pdb.Namespace && selector.Matches(labels.Set(pod.Labels)) {
+				disrupted++
+			}
+		}
+		if disrupted > pdb.Status.PodDisruptionsAllowed {
+			return fmt.Errorf("pod disruption budget %s/%s allows %d disruptions, %d pods would be evicted",
+				pdb.Namespace, pdb.Name, pdb.Status.PodDisruptionsAllowed, disrupted)
+		}
+	}
+	return nil
+}
+
+// newEviction returns the eviction of pod, with its own termination grace period.
+func newEviction(pod *apiv1.Pod) *policyv1.Eviction {
+	return &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+		},
+		DeleteOptions: &metav1.DeleteOptions{
+			GracePeriodSeconds: pod.Spec.TerminationGracePeriodSeconds,
+		},
+	}
+}
+
+// evictPod evicts pod through the eviction subresource, which is refused by
+// the apiserver if it would violate a pod disruption budget.
+func evictPod(client kube_client.Interface, pod *apiv1.Pod) error {
+	return client.PolicyV1beta1().Evictions(pod.Namespace).Evict(newEviction(pod))
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+	policyv1 "k8s.io/kubernetes/pkg/apis/policy/v1beta1"
+)
+
+func TestCheckPdbs(t *testing.T) {
+	web1 := createTestPod("web1", false, 100)
+	web1.Labels = map[string]string{"app": "web"}
+	web2 := createTestPod("web2", false, 100)
+	web2.Labels = map[string]string{"app": "web"}
+	other := createTestPod("other", false, 100)
+	otherNamespace := createTestPod("web3", false, 100)
+	otherNamespace.Namespace = "default"
+	otherNamespace.Labels = map[string]string{"app": "web"}
+
+	pdbs := []*policyv1.PodDisruptionBudget{createTestPdb("web", map[string]string{"app": "web"}, 1)}
+	assert.NoError(t, checkPdbs([]*apiv1.Pod{web1, other, otherNamespace}, pdbs))
+	assert.Error(t, checkPdbs([]*apiv1.Pod{web1, web2}, pdbs))
+	assert.NoError(t, checkPdbs([]*apiv1.Pod{web1, web2}, nil))
+}
+
+func TestNewEviction(t *testing.T) {
+	pod := createTestPod("pod", false, 100)
+	gracePeriod := int64(60)
+	pod.Spec.TerminationGracePeriodSeconds = &gracePeriod
+
+	eviction := newEviction(pod)
+	assert.Equal(t, "kube-system", eviction.Namespace)
+	assert.Equal(t, "pod", eviction.Name)
+	assert.Equal(t, int64(60), *eviction.DeleteOptions.GracePeriodSeconds)
+
+	pod.Spec.TerminationGracePeriodSeconds = nil
+	assert.Nil(t, newEviction(pod).DeleteOptions.GracePeriodSeconds)
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+	kube_client "k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
+
+	"github.com/golang/glog"
+)
+
+// leaderAnnotationKey is the annotation of the endpoints lock holding the
+// leader election record, the same one Kubernetes components use.
+const leaderAnnotationKey = "control-plane.alpha.kubernetes.io/leader"
+
+// leaderElectionRecord is the record stored in the endpoints lock.
+type leaderElectionRecord struct {
+	HolderIdentity       string      `json:"holderIdentity"`
+	LeaseDurationSeconds int         `json:"leaseDurationSeconds"`
+	AcquireTime          metav1.Time `json:"acquireTime"`
+	RenewTime            metav1.Time `json:"renewTime"`
+}
+
+// leaderElector elects a single leader among the rescheduler replicas, using
+// an annotation on an endpoints object as a lock.
+type leaderElector struct {
+	client    kube_client.Interface
+	namespace string
+	name      string
+	identity  string
+
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+
+	// observedRecord is the last record seen in the lock, and observedTime
+	// when it was seen. The lease of another holder expires leaseDuration
+	// after it was last seen renewed, so clock skew doesn't matter.
+	observedRecord string
+	observedTime   time.Time
+
+	now func() time.Time
+}
+
+func newLeaderElector(client kube_client.Interface, namespace, name, identity string,
+	leaseDuration, renewDeadline, retryPeriod time.Duration) *leaderElector {
+	return &leaderElector{
+		client:        client,
+		namespace:     namespace,
+		name:          name,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+		retryPeriod:   retryPeriod,
+		now:           time.Now,
+	}
+}
+
+// run blocks until the lease is acquired, then starts f and keeps renewing the
+// lease. The process exits if the lease can't be renewed, since another
+// replica may already be leading by then.
+func (le *leaderElector) run(f func()) {
+	glog.Infof("Attempting to acquire leader lease %s/%s as %v", le.namespace, le.name, le.identity)
+	wait.PollImmediateInfinite(le.retryPeriod, func() (bool, error) {
+		return le.tryAcquireOrRenew(), nil
+	})
+	glog.Infof("Acquired leader lease %s/%s", le.namespace, le.name)
+
+	go f()
+	for {
+		err := wait.PollImmediate(le.retryPeriod, le.renewDeadline, func() (bool, error) {
+			return le.tryAcquireOrRenew(), nil
+		})
+		if err != nil {
+			glog.Fatalf("Failed to renew leader lease %s/%s: %v", le.namespace, le.name, err)
+		}
+		time.Sleep(le.retryPeriod)
+	}
+}
+
+// tryAcquireOrRenew acquires the lease if it's free or expired, or renews it
+// if it's already held. It returns true on success.
+func (le *leaderElector) tryAcquireOrRenew() bool {
+	now := metav1.NewTime(le.now())
+	record := leaderElectionRecord{
+		HolderIdentity:       le.identity,
+		LeaseDurationSeconds: int(le.leaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	endpoints, err := le.client.CoreV1().Endpoints(le.namespace).Get(le.name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			glog.Errorf("Error while getting leader lease %s/%s: %v", le.namespace, le.name, err)
+			return false
+		}
+		value, err := json.Marshal(record)
+		if err != nil {
+			glog.Errorf("Error while encoding leader election record: %v", err)
+			return false
+		}
+		_, err = le.client.CoreV1().Endpoints(le.namespace).Create(&apiv1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   le.namespace,
+				Name:        le.name,
+				Annotations: map[string]string{leaderAnnotationKey: string(value)},
+			},
+		})
+		if err != nil {
+			glog.Errorf("Error while creating leader lease %s/%s: %v", le.namespace, le.name, err)
+			return false
+		}
+		le.observedRecord, le.observedTime = string(value), le.now()
+		return true
+	}
+
+	var current leaderElectionRecord
+	value, found := endpoints.Annotations[leaderAnnotationKey]
+	if found {
+		if err := json.Unmarshal([]byte(value), &current); err != nil {
+			glog.Errorf("Error while decoding leader election record %q: %v", value, err)
+			return false
+		}
+	}
+	if value != le.observedRecord {
+		le.observedRecord, le.observedTime = value, le.now()
+	}
+	if current.HolderIdentity != "" && current.HolderIdentity != le.identity &&
+		le.observedTime.Add(le.leaseDuration).After(le.now()) {
+		glog.V(4).Infof("Leader lease %s/%s is held by %v", le.namespace, le.name, current.HolderIdentity)
+		return false
+	}
+
+	if current.HolderIdentity == le.identity {
+		record.AcquireTime = current.AcquireTime
+	} else {
+		glog.Infof("Taking over leader lease %s/%s from %q", le.namespace, le.name, current.HolderIdentity)
+	}
+	newValue, err := json.Marshal(record)
+	if err != nil {
+		glog.Errorf("Error while encoding leader election record: %v", err)
+		return false
+	}
+	if endpoints.Annotations == nil {
+		endpoints.Annotations = make(map[string]string)
+	}
+	endpoints.Annotations[leaderAnnotationKey] = string(newValue)
+	// The update fails if another replica changed the lock since it was read.
+	if _, err := le.client.CoreV1().Endpoints(le.namespace).Update(endpoints); err != nil {
+		glog.Errorf("Error while updating leader lease %s/%s: %v", le.namespace, le.name, err)
+		return false
+	}
+	le.observedRecord, le.observedTime = string(newValue), le.now()
+	return true
+}
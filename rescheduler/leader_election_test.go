@@ -0,0 +1,63 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/clientset/fake"
+)
+
+func getLeader(t *testing.T, le *leaderElector) string {
+	endpoints, err := le.client.CoreV1().Endpoints(le.namespace).Get(le.name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	var record leaderElectionRecord
+	assert.NoError(t, json.Unmarshal([]byte(endpoints.Annotations[leaderAnnotationKey]), &record))
+	return record.HolderIdentity
+}
+
+func TestLeaderElection(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset()
+	now := time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	replica1 := newLeaderElector(fakeClient, "kube-system", "rescheduler", "replica1", 15*time.Second, 10*time.Second, 2*time.Second)
+	replica1.now = clock
+	replica2 := newLeaderElector(fakeClient, "kube-system", "rescheduler", "replica2", 15*time.Second, 10*time.Second, 2*time.Second)
+	replica2.now = clock
+
+	// the first replica creates the lock
+	assert.True(t, replica1.tryAcquireOrRenew())
+	assert.Equal(t, "replica1", getLeader(t, replica1))
+	assert.False(t, replica2.tryAcquireOrRenew())
+
+	// the lease is renewed by its holder only
+	now = now.Add(10 * time.Second)
+	assert.True(t, replica1.tryAcquireOrRenew())
+	now = now.Add(10 * time.Second)
+	assert.False(t, replica2.tryAcquireOrRenew())
+
+	// the lease is taken over once it wasn't renewed for its duration
+	now = now.Add(16 * time.Second)
+	assert.True(t, replica2.tryAcquireOrRenew())
+	assert.Equal(t, "replica2", getLeader(t, replica2))
+	assert.False(t, replica1.tryAcquireOrRenew())
+}
@@ -30,15 +30,68 @@ var (
 		},
 		[]string{"k8s_app"})
 	// DeletedPodsCount tracks the number of deletion of pods in order to schedule a critical one.
-	DeletedPodsCount = prometheus.NewCounter(
+	DeletedPodsCount = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "rescheduler",
 			Name:      "deleted_pods_count",
-			Help:      "Number of pods deleted in order to schedule a critical pod.",
+			Help:      "Number of pods deleted in order to schedule a critical pod, by namespace of the deleted pod.",
+		},
+		[]string{"namespace"})
+	// CriticalPodSchedulingLatency tracks how long critical pods the rescheduler made room for stayed unschedulable.
+	CriticalPodSchedulingLatency = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "rescheduler",
+			Name:      "critical_pod_scheduling_latency_seconds",
+			Help:      "Time from a critical pod being unschedulable to it being scheduled, for pods the rescheduler made room for.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		})
+	// TaintErrorsCount tracks the number of failures to add or release taints.
+	TaintErrorsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "taint_errors_count",
+			Help:      "Number of failures to add or release the taint of a node, by operation.",
+		},
+		[]string{"operation"})
+	// SchedulingTimeoutsCount tracks the number of critical pods not scheduled in time after making room for them.
+	SchedulingTimeoutsCount = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "scheduling_timeouts_count",
+			Help:      "Number of times a critical pod wasn't scheduled in time after making room for it.",
+		})
+	// PodsBeingProcessed tracks the number of critical pods the rescheduler is waiting for.
+	PodsBeingProcessed = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "rescheduler",
+			Name:      "pods_being_processed",
+			Help:      "Number of critical pods waited for after making room for them.",
+		})
+	// AttemptsCount tracks the number of attempts to make room for a critical pod.
+	AttemptsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "rescheduler",
+			Name:      "attempts_count",
+			Help:      "Number of attempts to make room for a critical pod, by result.",
+		},
+		[]string{"result"})
+	// AttemptDuration tracks how long attempts to make room for a critical pod take.
+	AttemptDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "rescheduler",
+			Name:      "attempt_duration_seconds",
+			Help:      "Time spent making room for a critical pod.",
+			Buckets:   prometheus.DefBuckets,
 		})
 )
 
 func init() {
 	prometheus.MustRegister(UnschedulableCriticalPodsCount)
 	prometheus.MustRegister(DeletedPodsCount)
+	prometheus.MustRegister(CriticalPodSchedulingLatency)
+	prometheus.MustRegister(TaintErrorsCount)
+	prometheus.MustRegister(SchedulingTimeoutsCount)
+	prometheus.MustRegister(PodsBeingProcessed)
+	prometheus.MustRegister(AttemptsCount)
+	prometheus.MustRegister(AttemptDuration)
 }
@@ -0,0 +1,162 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	ca_simulator "k8s.io/contrib/cluster-autoscaler/simulator"
+	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+	"k8s.io/kubernetes/pkg/kubelet/qos"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm/predicates"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+)
+
+// qosRank returns how expensive it is to evict a pod, based on its QoS class.
+func qosRank(pod *apiv1.Pod) int {
+	switch qos.GetPodQOS(pod) {
+	case apiv1.PodQOSGuaranteed:
+		return 2
+	case apiv1.PodQOSBurstable:
+		return 1
+	}
+	return 0
+}
+
+// podsByCost sorts pods from the most to the least expensive to evict: by
+// priority, then by QoS class, then by requested cpu and memory.
+type podsByCost []*apiv1.Pod
+
+func (p podsByCost) Len() int      { return len(p) }
+func (p podsByCost) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p podsByCost) Less(i, j int) bool {
+	if pi, pj := getPodPriority(p[i]), getPodPriority(p[j]); pi != pj {
+		return pi > pj
+	}
+	if qi, qj := qosRank(p[i]), qosRank(p[j]); qi != qj {
+		return qi > qj
+	}
+	ri, rj := predicates.GetResourceRequest(p[i]), predicates.GetResourceRequest(p[j])
+	if ri.MilliCPU != rj.MilliCPU {
+		return ri.MilliCPU > rj.MilliCPU
+	}
+	if ri.Memory != rj.Memory {
+		return ri.Memory > rj.Memory
+	}
+	return podId(p[i]) < podId(p[j])
+}
+
+// nodeCost is the cost of making room for a critical pod on a node, i.e. of
+// deleting the pods which don't fit next to it.
+type nodeCost struct {
+	node    *apiv1.Node
+	victims []*apiv1.Pod
+
+	// maxPriority and maxQoSRank are the highest priority and QoS rank of
+	// the victims, the lowest possible values if there are none.
+	maxPriority int32
+	maxQoSRank  int
+	milliCPU    int64
+	memory      int64
+}
+
+func newNodeCost(node *apiv1.Node, victims []*apiv1.Pod) *nodeCost {
+	cost := &nodeCost{node: node, victims: victims, maxPriority: math.MinInt32, maxQoSRank: -1}
+	for _, pod := range victims {
+		if priority := getPodPriority(pod); priority > cost.maxPriority {
+			cost.maxPriority = priority
+		}
+		if rank := qosRank(pod); rank > cost.maxQoSRank {
+			cost.maxQoSRank = rank
+		}
+		request := predicates.GetResourceRequest(pod)
+		cost.milliCPU += request.MilliCPU
+		cost.memory += request.Memory
+	}
+	return cost
+}
+
+// less returns true if c is cheaper than other. Deleting pods of a lower
+// priority or QoS class is always cheaper, then deleting fewer pods, then
+// freeing less resources.
+func (c *nodeCost) less(other *nodeCost) bool {
+	if c.maxPriority != other.maxPriority {
+		return c.maxPriority < other.maxPriority
+	}
+	if c.maxQoSRank != other.maxQoSRank {
+		return c.maxQoSRank < other.maxQoSRank
+	}
+	if len(c.victims) != len(other.victims) {
+		return len(c.victims) < len(other.victims)
+	}
+	if c.milliCPU != other.milliCPU {
+		return c.milliCPU < other.milliCPU
+	}
+	if c.memory != other.memory {
+		return c.memory < other.memory
+	}
+	return c.node.Name < other.node.Name
+}
+
+func (c *nodeCost) String() string {
+	if len(c.victims) == 0 {
+		return fmt.Sprintf("%v (no pods to delete)", c.node.Name)
+	}
+	return fmt.Sprintf("%v (%d pods to delete, max priority %d, max QoS rank %d, %dm cpu, %d bytes of memory)",
+		c.node.Name, len(c.victims), c.maxPriority, c.maxQoSRank, c.milliCPU, c.memory)
+}
+
+// describeChoice returns a description of the chosen node and of the runner-up.
+func describeChoice(best, runnerUp *nodeCost) string {
+	if runnerUp == nil {
+		return fmt.Sprintf("Chose node %v, no other node fits.", best)
+	}
+	return fmt.Sprintf("Chose node %v, runner-up %v.", best, runnerUp)
+}
+
+// simulateEviction returns the pods of otherPods that have to be deleted for
+// criticalPod to fit on node, next to requiredPods. The most expensive pods
+// are the first to be kept, so the cheapest ones are deleted. It returns an
+// error if criticalPod doesn't fit even without otherPods.
+func simulateEviction(predicateChecker *ca_simulator.PredicateChecker, node *apiv1.Node,
+	requiredPods, otherPods []*apiv1.Pod, criticalPod *apiv1.Pod) ([]*apiv1.Pod, error) {
+	nodeInfo := schedulercache.NewNodeInfo(requiredPods...)
+	nodeInfo.SetNode(node)
+	if err := predicateChecker.CheckPredicates(criticalPod, nodeInfo); err != nil {
+		return nil, fmt.Errorf("Pod %s doesn't fit to node %v: %v", podId(criticalPod), node.Name, err)
+	}
+
+	pods := append([]*apiv1.Pod{}, requiredPods...)
+	pods = append(pods, criticalPod)
+	nodeInfo = schedulercache.NewNodeInfo(pods...)
+	nodeInfo.SetNode(node)
+
+	candidates := append([]*apiv1.Pod{}, otherPods...)
+	sort.Sort(podsByCost(candidates))
+	victims := []*apiv1.Pod{}
+	for _, p := range candidates {
+		if err := predicateChecker.CheckPredicates(p, nodeInfo); err != nil {
+			victims = append(victims, p)
+		} else {
+			nodeInfo = schedulercache.NewNodeInfo(append(nodeInfo.Pods(), p)...)
+			nodeInfo.SetNode(node)
+		}
+	}
+	return victims, nil
+}
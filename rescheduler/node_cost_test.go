@@ -0,0 +1,88 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+)
+
+func createGuaranteedTestPod(name string, cpu int64) *apiv1.Pod {
+	pod := createTestPod(name, false, cpu)
+	pod.Spec.Containers[0].Resources.Requests[apiv1.ResourceMemory] = *resource.NewQuantity(1000, resource.DecimalSI)
+	pod.Spec.Containers[0].Resources.Limits = pod.Spec.Containers[0].Resources.Requests
+	return pod
+}
+
+func TestQoSRank(t *testing.T) {
+	assert.Equal(t, 2, qosRank(createGuaranteedTestPod("p1", 100)))
+	assert.Equal(t, 1, qosRank(createTestPod("p2", false, 100)))
+	assert.Equal(t, 0, qosRank(&apiv1.Pod{Spec: apiv1.PodSpec{Containers: []apiv1.Container{{}}}}))
+}
+
+func TestSimulateEviction(t *testing.T) {
+	node := createTestNode("node", 1000)
+	required := []*apiv1.Pod{createTestPod("required", true, 200)}
+	guaranteed := createGuaranteedTestPod("guaranteed", 300)
+	small := createTestPod("small", false, 100)
+	big := createTestPod("big", false, 400)
+	critical := createTestPod("critical", true, 400)
+
+	predicateChecker := simulator.NewTestPredicateChecker()
+
+	// the guaranteed pod is kept, even though it is not the biggest one
+	victims, err := simulateEviction(predicateChecker, node, required, []*apiv1.Pod{small, big, guaranteed}, critical)
+	assert.NoError(t, err)
+	assert.Equal(t, []*apiv1.Pod{big}, victims)
+
+	_, err = simulateEviction(predicateChecker, node, required, nil, createTestPod("huge", true, 900))
+	assert.Error(t, err)
+}
+
+func TestNodeCost(t *testing.T) {
+	node1 := createTestNode("node1", 1000)
+	node2 := createTestNode("node2", 1000)
+	guaranteed := createGuaranteedTestPod("guaranteed", 100)
+	small := createTestPod("small", false, 100)
+	big := createTestPod("big", false, 400)
+
+	none := newNodeCost(node1, nil)
+	assert.Equal(t, -1, none.maxQoSRank)
+
+	// fewer burstable pods are cheaper than a single guaranteed one
+	burstable := newNodeCost(node1, []*apiv1.Pod{small, big})
+	assert.Equal(t, 1, burstable.maxQoSRank)
+	assert.Equal(t, int64(500), burstable.milliCPU)
+	assert.True(t, none.less(burstable))
+	assert.True(t, burstable.less(newNodeCost(node2, []*apiv1.Pod{guaranteed})))
+
+	// then fewer pods, and less resources
+	assert.True(t, newNodeCost(node2, []*apiv1.Pod{big}).less(burstable))
+	assert.True(t, newNodeCost(node2, []*apiv1.Pod{small}).less(newNodeCost(node1, []*apiv1.Pod{big})))
+
+	// pods of a higher priority are more expensive than any QoS class
+	prioritized := createTestPod("prioritized", false, 100)
+	prioritized.Annotations = map[string]string{podPriorityAnnotationKey: "10"}
+	assert.True(t, newNodeCost(node1, []*apiv1.Pod{guaranteed, big}).less(newNodeCost(node2, []*apiv1.Pod{prioritized})))
+
+	// ties are broken by the node name
+	assert.True(t, newNodeCost(node1, []*apiv1.Pod{small}).less(newNodeCost(node2, []*apiv1.Pod{small})))
+}
@@ -27,19 +27,16 @@ import (
 	ca_drain "k8s.io/contrib/cluster-autoscaler/utils/drain"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/apimachinery/pkg/util/wait"
 	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
 	clientv1 "k8s.io/client-go/pkg/api/v1"
 	kube_restclient "k8s.io/client-go/rest"
 	kube_record "k8s.io/client-go/tools/record"
-	kube_utils "k8s.io/contrib/cluster-autoscaler/utils/kubernetes"
 	"k8s.io/contrib/rescheduler/metrics"
 	"k8s.io/kubernetes/pkg/api"
 	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+	policyv1 "k8s.io/kubernetes/pkg/apis/policy/v1beta1"
 	kube_client "k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
 	kubectl_util "k8s.io/kubernetes/pkg/kubectl/cmd/util"
-	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
@@ -52,6 +49,11 @@ const (
 	// TaintsAnnotationKey represents the key of taints data (json serialized)
 	// in the Annotations of a Node.
 	TaintsAnnotationKey string = "scheduler.alpha.kubernetes.io/taints"
+	// criticalPodDeadlinesAnnotationKey is the annotation of a tainted node
+	// holding until when each critical pod it was tainted for is waited for,
+	// as a json map keyed by pod id, so that a new leader can resume waiting
+	// for them.
+	criticalPodDeadlinesAnnotationKey = "rescheduler.alpha.kubernetes.io/critical-pod-deadlines"
 )
 
 var (
@@ -67,11 +69,21 @@ var (
 		`Content type of requests sent to apiserver.`)
 
 	housekeepingInterval = flags.Duration("housekeeping-interval", 10*time.Second,
-		`How often rescheduler takes actions.`)
+		`How often rescheduler re-examines unschedulable critical pods and releases
+		 taints, in addition to reacting to pod changes.`)
 
 	systemNamespace = flags.String("system-namespace", metav1.NamespaceSystem,
 		`Namespace to watch for critical addons.`)
 
+	criticalPodSelector = flags.String("critical-pod-selector", "",
+		`Optional, label selector of pods that are critical in any namespace,
+		 in addition to the annotated critical addons of the system namespace.`)
+
+	criticalPodMinPriority = flags.Int32("critical-pod-min-priority", 0,
+		`Optional, pods in any namespace with at least this priority are critical.
+		 The priority of a pod is read from the `+podPriorityAnnotationKey+` annotation.
+		 Zero disables it.`)
+
 	initialDelay = flags.Duration("initial-delay", 2*time.Minute,
 		`How long should rescheduler wait after start to make sure
 		 all critical addons had a chance to start.`)
@@ -81,15 +93,39 @@ var (
 		 after evicting pods to make a spot for it.`)
 
 	listenAddress = flags.String("listen-address", "localhost:9235",
-		`Address to listen on for serving prometheus metrics`)
+		`Address to listen on for serving prometheus metrics and the latest decisions`)
+
+	dryRun = flags.Bool("dry-run", false,
+		`If true, rescheduler only records which node it would choose for critical
+		 pods and which pods it would evict, without tainting nodes or evicting pods.`)
+
+	leaderElect = flags.Bool("leader-elect", false,
+		`If true, rescheduler elects a leader among its replicas before taking any action,
+		 using an endpoints object in the system namespace as a lock.`)
+
+	leaderElectLockName = flags.String("leader-elect-lock-name", "rescheduler",
+		`Name of the endpoints object used as a leader election lock.`)
+
+	leaderElectLeaseDuration = flags.Duration("leader-elect-lease-duration", 15*time.Second,
+		`How long other replicas wait before taking over the lease when the leader
+		 stops renewing it.`)
+
+	leaderElectRenewDeadline = flags.Duration("leader-elect-renew-deadline", 10*time.Second,
+		`How long the leader keeps trying to renew its lease before giving up. It must
+		 be shorter than the lease duration.`)
+
+	leaderElectRetryPeriod = flags.Duration("leader-elect-retry-period", 2*time.Second,
+		`How long replicas wait between attempts to acquire or renew the lease.`)
 )
 
 func main() {
 	glog.Infof("Running Rescheduler")
 	flags.Parse(os.Args)
 
+	decisions := NewDecisionLog()
 	go func() {
 		http.Handle("/metrics", prometheus.Handler())
+		http.Handle("/decisions", decisions)
 		err := http.ListenAndServe(*listenAddress, nil)
 		glog.Fatalf("Failed to start metrics: %v", err)
 	}()
@@ -109,87 +145,32 @@ func main() {
 		glog.Fatalf("Failed to create predicate checker: %v", err)
 	}
 
-	stopChannel := make(chan struct{})
-	unschedulablePodLister := kube_utils.NewUnschedulablePodInNamespaceLister(kubeClient, *systemNamespace, stopChannel)
-	nodeLister := kube_utils.NewReadyNodeLister(kubeClient, stopChannel)
-
-	// TODO(piosz): consider reseting this set once every few hours.
-	podsBeingProcessed := NewPodSet()
-
-	// As tolerations/taints feature changed from being specified in annotations
-	// to being specified in fields in Kubernetes 1.6, we need to make sure that
-	// any annotations that were created in the previous versions are removed.
-	releaseAllTaintsDeprecated(kubeClient, nodeLister)
-
-	releaseAllTaints(kubeClient, nodeLister, podsBeingProcessed)
-
-	for {
-		select {
-		case <-time.After(*housekeepingInterval):
-			{
-				allUnschedulablePods, err := unschedulablePodLister.List()
-				if err != nil {
-					glog.Errorf("Failed to list unscheduled pods: %v", err)
-					continue
-				}
-
-				criticalPods := filterCriticalPods(allUnschedulablePods, podsBeingProcessed)
-
-				if len(criticalPods) > 0 {
-					for _, pod := range criticalPods {
-						glog.Infof("Critical pod %s is unschedulable. Trying to find a spot for it.", podId(pod))
-						k8sApp := "unknown"
-						if l, found := pod.ObjectMeta.Labels["k8s-app"]; found {
-							k8sApp = l
-						}
-						metrics.UnschedulableCriticalPodsCount.WithLabelValues(k8sApp).Inc()
-						nodes, err := nodeLister.List()
-						if err != nil {
-							glog.Errorf("Failed to list nodes: %v", err)
-							continue
-						}
-
-						node := findNodeForPod(kubeClient, predicateChecker, nodes, pod)
-						if node == nil {
-							glog.Errorf("Pod %s can't be scheduled on any existing node.", podId(pod))
-							recorder.Eventf(pod, apiv1.EventTypeNormal, "PodDoestFitAnyNode",
-								"Critical pod %s doesn't fit on any node.", podId(pod))
-							continue
-						}
-						glog.Infof("Trying to place the pod on node %v", node.Name)
-
-						err = prepareNodeForPod(kubeClient, recorder, predicateChecker, node, pod)
-						if err != nil {
-							glog.Warningf("%+v", err)
-						} else {
-							podsBeingProcessed.Add(pod)
-							go waitForScheduled(kubeClient, podsBeingProcessed, pod)
-						}
-					}
-				}
-
-				releaseAllTaints(kubeClient, nodeLister, podsBeingProcessed)
-			}
-		}
+	policy, err := newCriticalPodPolicy(*systemNamespace, *criticalPodSelector, *criticalPodMinPriority)
+	if err != nil {
+		glog.Fatalf("Failed to create critical pod policy: %v", err)
 	}
-}
 
-func waitForScheduled(client kube_client.Interface, podsBeingProcessed *podSet, pod *apiv1.Pod) {
-	glog.Infof("Waiting for pod %s to be scheduled", podId(pod))
-	err := wait.Poll(time.Second, *podScheduledTimeout, func() (bool, error) {
-		p, err := client.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
-		if err != nil {
-			glog.Warningf("Error while getting pod %s: %v", podId(pod), err)
-			return false, nil
-		}
-		return p.Spec.NodeName != "", nil
-	})
+	if !*leaderElect {
+		run(kubeClient, recorder, predicateChecker, policy, decisions)
+		return
+	}
+	identity, err := os.Hostname()
 	if err != nil {
-		glog.Warningf("Timeout while waiting for pod %s to be scheduled after %v.", podId(pod), *podScheduledTimeout)
-	} else {
-		glog.Infof("Pod %v was successfully scheduled.", podId(pod))
+		glog.Fatalf("Failed to get hostname: %v", err)
 	}
-	podsBeingProcessed.Remove(pod)
+	elector := newLeaderElector(kubeClient, *systemNamespace, *leaderElectLockName, identity,
+		*leaderElectLeaseDuration, *leaderElectRenewDeadline, *leaderElectRetryPeriod)
+	elector.run(func() {
+		run(kubeClient, recorder, predicateChecker, policy, decisions)
+	})
+}
+
+// run is the main loop of the rescheduler, it never returns.
+func run(kubeClient kube_client.Interface, recorder kube_record.EventRecorder,
+	predicateChecker *ca_simulator.PredicateChecker, policy *criticalPodPolicy, decisions *decisionLog) {
+	stopChannel := make(chan struct{})
+	newRescheduler(kubeClient, recorder, predicateChecker, policy, decisions, stopChannel).Run(stopChannel)
+	glog.Fatalf("Rescheduler stopped")
 }
 
 func createKubeClient(flags *flag.FlagSet, inCluster bool) (kube_client.Interface, error) {
@@ -227,7 +208,7 @@ func getTaintsFromNodeAnnotations(annotations map[string]string) ([]apiv1.Taint,
 	return taints, nil
 }
 
-func releaseAllTaintsDeprecated(client kube_client.Interface, nodeLister *kube_utils.ReadyNodeLister) {
+func releaseAllTaintsDeprecated(client kube_client.Interface, nodeLister nodeLister) {
 	glog.Infof("Removing all annotation taints because they are no longer supported.")
 	nodes, err := nodeLister.List()
 	if err != nil {
@@ -264,6 +245,7 @@ func releaseTaintsOnNodesDeprecated(client kube_client.Interface, nodes []*apiv1
 			node.Annotations[TaintsAnnotationKey] = string(taintsJson)
 			_, err = client.CoreV1().Nodes().Update(node)
 			if err != nil {
+				metrics.TaintErrorsCount.WithLabelValues("release").Inc()
 				glog.Warningf("Error while releasing taints on node %v: %v", node.Name, err)
 			} else {
 				glog.Infof("Successfully released all taints on node %v", node.Name)
@@ -272,7 +254,7 @@ func releaseTaintsOnNodesDeprecated(client kube_client.Interface, nodes []*apiv1
 	}
 }
 
-func releaseAllTaints(client kube_client.Interface, nodeLister *kube_utils.ReadyNodeLister, podsBeingProcessed *podSet) {
+func releaseAllTaints(client kube_client.Interface, nodeLister nodeLister, podsBeingProcessed *podSet) {
 	nodes, err := nodeLister.List()
 	if err != nil {
 		glog.Warningf("Cannot release taints - error while listing nodes: %v", err)
@@ -281,12 +263,47 @@ func releaseAllTaints(client kube_client.Interface, nodeLister *kube_utils.Ready
 	releaseTaintsOnNodes(client, nodes, podsBeingProcessed)
 }
 
+type podToResume struct {
+	pod     *apiv1.Pod
+	timeout time.Duration
+}
+
+// resumePodsOnNodes adds the critical pods that nodes are tainted for to
+// podsBeingProcessed, and returns them along with how long they can still be
+// waited for. Pods whose deadline has passed are left out.
+func resumePodsOnNodes(nodes []*apiv1.Node, podsBeingProcessed *podSet, now time.Time) []podToResume {
+	var pods []podToResume
+	for _, node := range nodes {
+		deadlines := getPodDeadlines(node)
+		for _, taint := range node.Spec.Taints {
+			if taint.Key != criticalAddonsOnlyTaintKey || podsBeingProcessed.HasId(taint.Value) {
+				continue
+			}
+			deadline, found := deadlines[taint.Value]
+			if !found || !deadline.After(now) {
+				continue
+			}
+			pod, err := podFromId(taint.Value)
+			if err != nil {
+				glog.Warningf("Unexpected taint %+v on node %v: %v", taint, node.Name, err)
+				continue
+			}
+			glog.Infof("Resuming waiting for pod %s on node %v until %v", taint.Value, node.Name, deadline)
+			podsBeingProcessed.Add(pod)
+			pods = append(pods, podToResume{pod: pod, timeout: deadline.Sub(now)})
+		}
+	}
+	return pods
+}
+
 func releaseTaintsOnNodes(client kube_client.Interface, nodes []*apiv1.Node, podsBeingProcessed *podSet) {
 	for _, node := range nodes {
+		deadlines := getPodDeadlines(node)
 		newTaints := make([]apiv1.Taint, 0)
 		for _, taint := range node.Spec.Taints {
 			if taint.Key == criticalAddonsOnlyTaintKey && !podsBeingProcessed.HasId(taint.Value) {
 				glog.Infof("Releasing taint %+v on node %v", taint, node.Name)
+				delete(deadlines, taint.Value)
 			} else {
 				newTaints = append(newTaints, taint)
 			}
@@ -294,8 +311,13 @@ func releaseTaintsOnNodes(client kube_client.Interface, nodes []*apiv1.Node, pod
 
 		if len(newTaints) != len(node.Spec.Taints) {
 			node.Spec.Taints = newTaints
+			if err := setPodDeadlines(node, deadlines); err != nil {
+				glog.Warningf("Error while releasing taints on node %v: %v", node.Name, err)
+				continue
+			}
 			_, err := client.CoreV1().Nodes().Update(node)
 			if err != nil {
+				metrics.TaintErrorsCount.WithLabelValues("release").Inc()
 				glog.Warningf("Error while releasing taints on node %v: %v", node.Name, err)
 			} else {
 				glog.Infof("Successfully released all taints on node %v", node.Name)
@@ -305,49 +327,42 @@ func releaseTaintsOnNodes(client kube_client.Interface, nodes []*apiv1.Node, pod
 }
 
 // The caller of this function must remove the taint if this function returns error.
-func prepareNodeForPod(client kube_client.Interface, recorder kube_record.EventRecorder, predicateChecker *ca_simulator.PredicateChecker, originalNode *apiv1.Node, criticalPod *apiv1.Pod) error {
+// choice describes why the node was chosen, it is added to the events of the evicted pods.
+// deadline is until when the critical pod is waited for, it is stored on the node.
+func prepareNodeForPod(client kube_client.Interface, recorder kube_record.EventRecorder, predicateChecker *ca_simulator.PredicateChecker, pods *podCache, policy *criticalPodPolicy, originalNode *apiv1.Node, pdbs []*policyv1.PodDisruptionBudget, criticalPod *apiv1.Pod, choice string, deadline time.Time) error {
 	// Operate on a copy of the node to ensure pods running on the node will pass CheckPredicates below.
 	node, err := copyNode(originalNode)
 	if err != nil {
 		return fmt.Errorf("Error while copying node: %v", err)
 	}
-	err = addTaint(client, originalNode, podId(criticalPod))
+	err = addTaint(client, originalNode, podId(criticalPod), deadline)
 	if err != nil {
+		metrics.TaintErrorsCount.WithLabelValues("add").Inc()
 		return fmt.Errorf("Error while adding taint: %v", err)
 	}
 
-	requiredPods, otherPods, err := groupPods(client, node)
+	requiredPods, otherPods, err := groupPods(pods, policy, node, criticalPod)
 	if err != nil {
 		return err
 	}
 
-	nodeInfo := schedulercache.NewNodeInfo(requiredPods...)
-	nodeInfo.SetNode(node)
-
-	// check whether critical pod still fit
-	if err := predicateChecker.CheckPredicates(criticalPod, nodeInfo); err != nil {
-		return fmt.Errorf("Pod %s doesn't fit to node %v: %v", podId(criticalPod), node.Name, err)
+	// check whether critical pod still fit, and which pods have to go
+	victims, err := simulateEviction(predicateChecker, node, requiredPods, otherPods, criticalPod)
+	if err != nil {
+		return err
+	}
+	if err := checkPdbs(victims, pdbs); err != nil {
+		return fmt.Errorf("Can't make room for pod %s on node %v: %v", podId(criticalPod), node.Name, err)
 	}
-	requiredPods = append(requiredPods, criticalPod)
-	nodeInfo = schedulercache.NewNodeInfo(requiredPods...)
-	nodeInfo.SetNode(node)
 
-	for _, p := range otherPods {
-		if err := predicateChecker.CheckPredicates(p, nodeInfo); err != nil {
-			glog.Infof("Pod %s will be deleted in order to schedule critical pod %s.", podId(p), podId(criticalPod))
-			recorder.Eventf(p, apiv1.EventTypeNormal, "DeletedByRescheduler",
-				"Deleted by rescheduler in order to schedule critical pod %s.", podId(criticalPod))
-			// TODO(piosz): add better support of graceful deletion
-			delErr := client.CoreV1().Pods(p.Namespace).Delete(p.Name, metav1.NewDeleteOptions(10))
-			if delErr != nil {
-				return fmt.Errorf("Failed to delete pod %s: %v", podId(p), delErr)
-			}
-			metrics.DeletedPodsCount.Inc()
-		} else {
-			newPods := append(nodeInfo.Pods(), p)
-			nodeInfo = schedulercache.NewNodeInfo(newPods...)
-			nodeInfo.SetNode(node)
+	for _, p := range victims {
+		glog.Infof("Pod %s will be evicted in order to schedule critical pod %s.", podId(p), podId(criticalPod))
+		if evictErr := evictPod(client, p); evictErr != nil {
+			return fmt.Errorf("Failed to evict pod %s: %v", podId(p), evictErr)
 		}
+		recorder.Eventf(p, apiv1.EventTypeNormal, "DeletedByRescheduler",
+			"Evicted by rescheduler in order to schedule critical pod %s. %s", podId(criticalPod), choice)
+		metrics.DeletedPodsCount.WithLabelValues(p.Namespace).Inc()
 	}
 
 	// TODO(piosz): how to reset scheduler backoff?
@@ -366,12 +381,19 @@ func copyNode(node *apiv1.Node) (*apiv1.Node, error) {
 	return copied, nil
 }
 
-func addTaint(client kube_client.Interface, node *apiv1.Node, value string) error {
+// addTaint taints node for the critical pod value, and stores on the node
+// until when the pod is waited for.
+func addTaint(client kube_client.Interface, node *apiv1.Node, value string, deadline time.Time) error {
 	node.Spec.Taints = append(node.Spec.Taints, apiv1.Taint{
 		Key:    criticalAddonsOnlyTaintKey,
 		Value:  value,
 		Effect: apiv1.TaintEffectNoSchedule,
 	})
+	deadlines := getPodDeadlines(node)
+	deadlines[value] = deadline.UTC()
+	if err := setPodDeadlines(node, deadlines); err != nil {
+		return err
+	}
 
 	if _, err := client.CoreV1().Nodes().Update(node); err != nil {
 		return err
@@ -379,29 +401,78 @@ func addTaint(client kube_client.Interface, node *apiv1.Node, value string) erro
 	return nil
 }
 
-// Currently the logic choose a random node which satisfies requirements (a critical pod fits there).
-// TODO(piosz): add a prioritization to this logic
-func findNodeForPod(client kube_client.Interface, predicateChecker *ca_simulator.PredicateChecker, nodes []*apiv1.Node, pod *apiv1.Pod) *apiv1.Node {
+// getPodDeadlines returns until when each critical pod node is tainted for is
+// waited for, keyed by pod id. An invalid annotation is treated as empty.
+func getPodDeadlines(node *apiv1.Node) map[string]time.Time {
+	deadlines := make(map[string]time.Time)
+	value := node.Annotations[criticalPodDeadlinesAnnotationKey]
+	if value == "" {
+		return deadlines
+	}
+	if err := json.Unmarshal([]byte(value), &deadlines); err != nil {
+		glog.Warningf("Invalid annotation %s on node %v: %v", criticalPodDeadlinesAnnotationKey, node.Name, err)
+		return make(map[string]time.Time)
+	}
+	return deadlines
+}
+
+// setPodDeadlines stores deadlines on node, removing the annotation when
+// there are none left.
+func setPodDeadlines(node *apiv1.Node, deadlines map[string]time.Time) error {
+	if len(deadlines) == 0 {
+		delete(node.Annotations, criticalPodDeadlinesAnnotationKey)
+		return nil
+	}
+	value, err := json.Marshal(deadlines)
+	if err != nil {
+		return err
+	}
+	if node.Annotations == nil {
+		node.Annotations = make(map[string]string)
+	}
+	node.Annotations[criticalPodDeadlinesAnnotationKey] = string(value)
+	return nil
+}
+
+// findNodeForPod simulates making room for pod on every node, and returns the
+// cheapest node along with the runner-up. Both are nil if the pod doesn't fit
+// on any node, even after deleting all the pods that can be deleted. Nodes
+// where the evictions would violate a pod disruption budget are skipped.
+func findNodeForPod(pods *podCache, predicateChecker *ca_simulator.PredicateChecker, policy *criticalPodPolicy,
+	nodes []*apiv1.Node, pdbs []*policyv1.PodDisruptionBudget, pod *apiv1.Pod) (best, runnerUp *nodeCost) {
 	for _, node := range nodes {
 		// ignore nodes with taints
 		if err := checkTaints(node); err != nil {
 			glog.Warningf("Skipping node %v due to %v", node.Name, err)
+			continue
 		}
 
-		requiredPods, _, err := groupPods(client, node)
+		requiredPods, otherPods, err := groupPods(pods, policy, node, pod)
 		if err != nil {
 			glog.Warningf("Skipping node %v due to error: %v", node.Name, err)
 			continue
 		}
 
-		nodeInfo := schedulercache.NewNodeInfo(requiredPods...)
-		nodeInfo.SetNode(node)
+		victims, err := simulateEviction(predicateChecker, node, requiredPods, otherPods, pod)
+		if err != nil {
+			glog.V(4).Infof("Skipping node %v: %v", node.Name, err)
+			continue
+		}
+		if err := checkPdbs(victims, pdbs); err != nil {
+			glog.Infof("Skipping node %v: %v", node.Name, err)
+			continue
+		}
 
-		if err := predicateChecker.CheckPredicates(pod, nodeInfo); err == nil {
-			return node
+		cost := newNodeCost(node, victims)
+		glog.V(2).Infof("Cost of placing pod %s on node %v", podId(pod), cost)
+		switch {
+		case best == nil || cost.less(best):
+			best, runnerUp = cost, best
+		case runnerUp == nil || cost.less(runnerUp):
+			runnerUp = cost
 		}
 	}
-	return nil
+	return best, runnerUp
 }
 
 func checkTaints(node *apiv1.Node) error {
@@ -413,25 +484,23 @@ func checkTaints(node *apiv1.Node) error {
 	return nil
 }
 
-// groupPods divides pods running on <node> into those which can't be deleted and the others
-func groupPods(client kube_client.Interface, node *apiv1.Node) ([]*apiv1.Pod, []*apiv1.Pod, error) {
-	podsOnNode, err := client.CoreV1().Pods(apiv1.NamespaceAll).List(
-		metav1.ListOptions{FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": node.Name}).String()})
+// groupPods divides pods running on <node> into those which can't be deleted to make room for <criticalPod> and the others
+func groupPods(pods *podCache, policy *criticalPodPolicy, node *apiv1.Node, criticalPod *apiv1.Pod) ([]*apiv1.Pod, []*apiv1.Pod, error) {
+	podsOnNode, err := pods.podsOnNode(node.Name)
 	if err != nil {
 		return []*apiv1.Pod{}, []*apiv1.Pod{}, err
 	}
 
 	requiredPods := make([]*apiv1.Pod, 0)
 	otherPods := make([]*apiv1.Pod, 0)
-	for i := range podsOnNode.Items {
-		pod := &podsOnNode.Items[i]
+	for _, pod := range podsOnNode {
 
 		creatorRef, err := ca_drain.CreatorRefKind(pod)
 		if err != nil {
 			return []*apiv1.Pod{}, []*apiv1.Pod{}, err
 		}
 
-		if ca_drain.IsMirrorPod(pod) || creatorRef == "DaemonSet" || isCriticalPod(pod) {
+		if ca_drain.IsMirrorPod(pod) || creatorRef == "DaemonSet" || !policy.canEvict(pod, criticalPod) {
 			requiredPods = append(requiredPods, pod)
 		} else {
 			otherPods = append(otherPods, pod)
@@ -440,18 +509,3 @@ func groupPods(client kube_client.Interface, node *apiv1.Node) ([]*apiv1.Pod, []
 
 	return requiredPods, otherPods, nil
 }
-
-func filterCriticalPods(allPods []*apiv1.Pod, podsBeingProcessed *podSet) []*apiv1.Pod {
-	criticalPods := []*apiv1.Pod{}
-	for _, pod := range allPods {
-		if isCriticalPod(pod) && !podsBeingProcessed.Has(pod) {
-			criticalPods = append(criticalPods, pod)
-		}
-	}
-	return criticalPods
-}
-
-func isCriticalPod(pod *apiv1.Pod) bool {
-	_, found := pod.ObjectMeta.Annotations[criticalPodAnnotation]
-	return found
-}
@@ -26,53 +26,15 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
 	kube_record "k8s.io/client-go/tools/record"
 	"k8s.io/contrib/cluster-autoscaler/simulator"
 	apiv1 "k8s.io/kubernetes/pkg/api/v1"
+	policyv1 "k8s.io/kubernetes/pkg/apis/policy/v1beta1"
 	"k8s.io/kubernetes/pkg/client/clientset_generated/clientset/fake"
 )
 
-func TestWaitForScheduled(t *testing.T) {
-	pod := createTestPod("test-pod", true, 150)
-	counter := 0
-	fakeClient := &fake.Clientset{}
-	fakeClient.Fake.AddReactor("get", "pods", func(action core.Action) (bool, runtime.Object, error) {
-		counter++
-		if counter > 2 {
-			pod.Spec.NodeName = "node1"
-		}
-		return true, pod, nil
-	})
-
-	podsBeingProcessed := NewPodSet()
-	podsBeingProcessed.Add(pod)
-
-	assert.True(t, podsBeingProcessed.HasId("kube-system_test-pod"))
-	waitForScheduled(fakeClient, podsBeingProcessed, pod)
-	assert.False(t, podsBeingProcessed.HasId("kube-system_test-pod"))
-}
-
-func TestFilterCriticalPods(t *testing.T) {
-	allPods := []*apiv1.Pod{}
-	podsBeingProcessed := NewPodSet()
-	filtered := filterCriticalPods(allPods, podsBeingProcessed)
-	assert.Equal(t, 0, len(filtered))
-
-	allPods = []*apiv1.Pod{
-		createTestPod("heapster", true, 0),
-		createTestPod("random", false, 0),
-		createTestPod("dns", true, 0),
-	}
-	filtered = filterCriticalPods(allPods, podsBeingProcessed)
-	assert.Equal(t, 2, len(filtered))
-	assert.Equal(t, "heapster", filtered[0].Name)
-	assert.Equal(t, "dns", filtered[1].Name)
-
-	podsBeingProcessed.Add(allPods[0])
-	filtered = filterCriticalPods(allPods, podsBeingProcessed)
-	assert.Equal(t, 1, len(filtered))
-	assert.Equal(t, "dns", filtered[0].Name)
-}
+var testPolicy = &criticalPodPolicy{systemNamespace: "kube-system"}
 
 func TestReleaseTaintsOnNodes(t *testing.T) {
 	updatedNodes := make(chan string, 10)
@@ -100,6 +62,65 @@ func TestReleaseTaintsOnNodes(t *testing.T) {
 	assert.Equal(t, "Nothing returned", getStringFromChan(updatedNodes))
 }
 
+func TestAddTaint(t *testing.T) {
+	fakeClient := &fake.Clientset{}
+	fakeClient.Fake.AddReactor("update", "nodes", func(action core.Action) (bool, runtime.Object, error) {
+		return true, action.(core.UpdateAction).GetObject(), nil
+	})
+
+	node := createTestNode("node1", 1000)
+	deadline := time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, addTaint(fakeClient, node, "kube-system_heapster", deadline))
+	assert.Equal(t, "kube-system_heapster", node.Spec.Taints[0].Value)
+	assert.NoError(t, addTaint(fakeClient, node, "kube-system_dns", deadline.Add(time.Minute)))
+	assert.Equal(t, `{"kube-system_dns":"2017-05-01T12:01:00Z","kube-system_heapster":"2017-05-01T12:00:00Z"}`,
+		node.Annotations[criticalPodDeadlinesAnnotationKey])
+
+	// only the deadlines of the released taints are removed
+	podsBeingProcessed := NewPodSet()
+	podsBeingProcessed.Add(createTestPod("dns", true, 200))
+	releaseTaintsOnNodes(fakeClient, []*apiv1.Node{node}, podsBeingProcessed)
+	assert.Equal(t, 1, len(node.Spec.Taints))
+	assert.Equal(t, `{"kube-system_dns":"2017-05-01T12:01:00Z"}`, node.Annotations[criticalPodDeadlinesAnnotationKey])
+
+	releaseTaintsOnNodes(fakeClient, []*apiv1.Node{node}, NewPodSet())
+	assert.Empty(t, node.Spec.Taints)
+	_, found := node.Annotations[criticalPodDeadlinesAnnotationKey]
+	assert.False(t, found)
+}
+
+func TestResumePodsOnNodes(t *testing.T) {
+	now := time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC)
+	nodes := []*apiv1.Node{
+		createTestNode("node1", 1000),
+		createTestNode("node2", 1000),
+		createTestNode("node3", 1000),
+		createTestNode("node4", 1000),
+	}
+	addTaintToNode(nodes[0], "kube-system_heapster")
+	addTaintToNode(nodes[0], "kube-system_kube-dns")
+	nodes[0].Annotations = map[string]string{criticalPodDeadlinesAnnotationKey: `{"kube-system_heapster":"2017-05-01T12:05:00Z","kube-system_kube-dns":"2017-05-01T12:02:00Z"}`}
+	addTaintToNode(nodes[1], "kube-system_dns")
+	nodes[1].Annotations = map[string]string{criticalPodDeadlinesAnnotationKey: `{"kube-system_dns":"2017-05-01T11:55:00Z"}`}
+	// tainted by a previous version, without a deadline
+	addTaintToNode(nodes[2], "kube-system_kube-proxy")
+
+	podsBeingProcessed := NewPodSet()
+	pods := resumePodsOnNodes(nodes, podsBeingProcessed, now)
+	assert.Equal(t, 2, len(pods))
+	assert.Equal(t, "kube-system", pods[0].pod.Namespace)
+	assert.Equal(t, "heapster", pods[0].pod.Name)
+	assert.Equal(t, 5*time.Minute, pods[0].timeout)
+	assert.Equal(t, "kube-dns", pods[1].pod.Name)
+	assert.Equal(t, 2*time.Minute, pods[1].timeout)
+	assert.True(t, podsBeingProcessed.HasId("kube-system_heapster"))
+	assert.True(t, podsBeingProcessed.HasId("kube-system_kube-dns"))
+	assert.False(t, podsBeingProcessed.HasId("kube-system_dns"))
+
+	// pods already being processed are not resumed twice
+	assert.Empty(t, resumePodsOnNodes(nodes, podsBeingProcessed, now))
+}
+
 func TestReleaseTaintsOnNodesDeprecated(t *testing.T) {
 	updatedNodes := make(chan string, 10)
 	fakeClient := &fake.Clientset{}
@@ -145,47 +166,51 @@ func TestFindNodeForPod(t *testing.T) {
 		*createTestPod("p3n3", false, 300),
 	}
 
-	fakeClient := &fake.Clientset{}
-	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
-		listAction, ok := action.(core.ListAction)
-		assert.True(t, ok)
-		restrictions := listAction.GetListRestrictions().Fields.String()
-
-		podList := &apiv1.PodList{}
-		switch restrictions {
-		case "spec.nodeName=node1":
-			podList.Items = pods1
-		case "spec.nodeName=node2":
-			podList.Items = pods2
-		case "spec.nodeName=node3":
-			podList.Items = pods3
-		default:
-			t.Fatalf("unexpected list restrictions: %v", restrictions)
-		}
-		return true, podList, nil
-	})
+	pods := newTestPodCache()
+	addTestPodsToCache(pods, "node1", pods1)
+	addTestPodsToCache(pods, "node2", pods2)
+	addTestPodsToCache(pods, "node3", pods3)
 
 	pod1 := createTestPod("pod1", true, 100)
 	pod2 := createTestPod("pod2", true, 500)
 	pod3 := createTestPod("pod3", true, 800)
 	pod4 := createTestPod("pod4", true, 2200)
 
-	node := findNodeForPod(fakeClient, predicateChecker, nodes, pod1)
-	assert.Equal(t, "node1", node.Name)
-
-	node = findNodeForPod(fakeClient, predicateChecker, nodes, pod2)
-	assert.Equal(t, "node2", node.Name)
-
-	node = findNodeForPod(fakeClient, predicateChecker, nodes, pod3)
-	assert.Equal(t, "node3", node.Name)
-
-	node = findNodeForPod(fakeClient, predicateChecker, nodes, pod4)
-	assert.Nil(t, node)
+	// fits everywhere without deleting anything
+	best, runnerUp := findNodeForPod(pods, predicateChecker, testPolicy, nodes, nil, pod1)
+	assert.Equal(t, "node1", best.node.Name)
+	assert.Equal(t, 0, len(best.victims))
+	assert.Equal(t, "node2", runnerUp.node.Name)
+
+	// fits on node2 after deleting p1n2, and on node3 without deleting anything
+	best, runnerUp = findNodeForPod(pods, predicateChecker, testPolicy, nodes, nil, pod2)
+	assert.Equal(t, "node3", best.node.Name)
+	assert.Equal(t, 0, len(best.victims))
+	assert.Equal(t, "node2", runnerUp.node.Name)
+	assert.Equal(t, []*apiv1.Pod{&pods2[0]}, runnerUp.victims)
+
+	// only fits on node3, after deleting the smallest pod
+	best, runnerUp = findNodeForPod(pods, predicateChecker, testPolicy, nodes, nil, pod3)
+	assert.Equal(t, "node3", best.node.Name)
+	assert.Equal(t, 1, len(best.victims))
+	assert.Equal(t, "p3n3", best.victims[0].Name)
+	assert.Nil(t, runnerUp)
+
+	best, runnerUp = findNodeForPod(pods, predicateChecker, testPolicy, nodes, nil, pod4)
+	assert.Nil(t, best)
+	assert.Nil(t, runnerUp)
+
+	// node2 is skipped when p1n2 can't be disrupted
+	pdbs := []*policyv1.PodDisruptionBudget{createTestPdb("pdb", map[string]string{"app": "p1n2"}, 0)}
+	pods2[0].Labels = map[string]string{"app": "p1n2"}
+	best, runnerUp = findNodeForPod(pods, predicateChecker, testPolicy, nodes, pdbs, pod2)
+	assert.Equal(t, "node3", best.node.Name)
+	assert.Nil(t, runnerUp)
 
 }
 
 func TestPrepareNodeForPod(t *testing.T) {
-	deletedPods := make(chan string, 10)
+	evictedPods := make(chan string, 10)
 	fakeClient := &fake.Clientset{}
 	fakeRecorder := kube_record.NewFakeRecorder(10)
 	predicateChecker := simulator.NewTestPredicateChecker()
@@ -200,21 +225,57 @@ func TestPrepareNodeForPod(t *testing.T) {
 	}
 	criticalPod := createTestPod("critical-pod", true, 500)
 
-	fakeClient.Fake.AddReactor("list", "pods", func(action core.Action) (bool, runtime.Object, error) {
-		return true, &apiv1.PodList{Items: podsOnNode}, nil
+	pods := newTestPodCache()
+	addTestPodsToCache(pods, node.Name, podsOnNode)
+	deadline := time.Now().Add(time.Minute)
+
+	fakeClient.Fake.AddReactor("post", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		assert.Equal(t, "eviction", action.GetSubresource())
+		evictedPods <- action.GetNamespace()
+		return true, nil, nil
 	})
 	fakeClient.Fake.AddReactor("delete", "pods", func(action core.Action) (bool, runtime.Object, error) {
-		deleteAction := action.(core.DeleteAction)
-		deletedPods <- deleteAction.GetName()
+		t.Fatalf("unexpected delete of pod %v", action.(core.DeleteAction).GetName())
 		return true, nil, nil
 	})
 
-	err := prepareNodeForPod(fakeClient, fakeRecorder, predicateChecker, node, criticalPod)
+	err := prepareNodeForPod(fakeClient, fakeRecorder, predicateChecker, pods, testPolicy, node, nil, criticalPod, "", deadline)
 	assert.NoError(t, err)
 
-	assert.Equal(t, podsOnNode[2].Name, getStringFromChan(deletedPods))
-	assert.Equal(t, podsOnNode[3].Name, getStringFromChan(deletedPods))
-	assert.Equal(t, "Nothing returned", getStringFromChan(deletedPods))
+	// p3 and p4 are evicted
+	assert.Equal(t, "kube-system", getStringFromChan(evictedPods))
+	assert.Equal(t, "kube-system", getStringFromChan(evictedPods))
+	assert.Equal(t, "Nothing returned", getStringFromChan(evictedPods))
+	assert.Equal(t, 2, len(fakeRecorder.Events))
+
+	// nothing is evicted when a victim is protected by a pod disruption budget
+	podsOnNode[3].Labels = map[string]string{"app": "p4"}
+	pdbs := []*policyv1.PodDisruptionBudget{createTestPdb("pdb", map[string]string{"app": "p4"}, 0)}
+	err = prepareNodeForPod(fakeClient, fakeRecorder, predicateChecker, pods, testPolicy, node, pdbs, criticalPod, "", deadline)
+	assert.Error(t, err)
+	assert.Equal(t, "Nothing returned", getStringFromChan(evictedPods))
+
+	// no event is recorded for pods which failed to be evicted
+	failingClient := &fake.Clientset{}
+	failingClient.Fake.AddReactor("post", "pods", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("eviction failed")
+	})
+	err = prepareNodeForPod(failingClient, fakeRecorder, predicateChecker, pods, testPolicy, node, nil, criticalPod, "", deadline)
+	assert.Error(t, err)
+	assert.Equal(t, 2, len(fakeRecorder.Events))
+}
+
+func newTestPodCache() *podCache {
+	return &podCache{indexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{nodeNameIndex: nodeNameIndexFunc})}
+}
+
+// addTestPodsToCache adds pods running on the node to the cache. The cache
+// holds pointers to the pods, so that tests can change them afterwards.
+func addTestPodsToCache(pods *podCache, nodeName string, podsOnNode []apiv1.Pod) {
+	for i := range podsOnNode {
+		podsOnNode[i].Spec.NodeName = nodeName
+		pods.indexer.Add(&podsOnNode[i])
+	}
 }
 
 func createTestPod(name string, isCritical bool, cpu int64) *apiv1.Pod {
@@ -242,6 +303,21 @@ func createTestPod(name string, isCritical bool, cpu int64) *apiv1.Pod {
 	return pod
 }
 
+func createTestPdb(name string, selector map[string]string, allowed int32) *policyv1.PodDisruptionBudget {
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "kube-system",
+			Name:      name,
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+		},
+		Status: policyv1.PodDisruptionBudgetStatus{
+			PodDisruptionsAllowed: allowed,
+		},
+	}
+}
+
 func createTestNode(name string, cpu int64) *apiv1.Node {
 	node := &apiv1.Node{
 		ObjectMeta: metav1.ObjectMeta{
@@ -18,8 +18,10 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	apiv1 "k8s.io/kubernetes/pkg/api/v1"
 )
 
@@ -27,6 +29,16 @@ func podId(pod *apiv1.Pod) string {
 	return fmt.Sprintf("%s_%s", pod.Namespace, pod.Name)
 }
 
+// podFromId returns a pod with only the namespace and name from the id, as
+// returned by podId. Namespaces can't contain underscores.
+func podFromId(id string) (*apiv1.Pod, error) {
+	parts := strings.SplitN(id, "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid pod id %q", id)
+	}
+	return &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: parts[0], Name: parts[1]}}, nil
+}
+
 // Thread safe implementation of set of Pods.
 type podSet struct {
 	set   map[string]struct{}
@@ -60,6 +72,13 @@ func (s *podSet) Has(pod *apiv1.Pod) bool {
 	return s.HasId(podId(pod))
 }
 
+// Len returns the number of pods in the set.
+func (s *podSet) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.set)
+}
+
 // HasId checks whether the pod is in the set.
 func (s *podSet) HasId(pod string) bool {
 	s.mutex.Lock()
@@ -0,0 +1,36 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPodFromId(t *testing.T) {
+	pod, err := podFromId("kube-system_kube-dns_v20")
+	assert.NoError(t, err)
+	assert.Equal(t, "kube-system", pod.Namespace)
+	assert.Equal(t, "kube-dns_v20", pod.Name)
+	assert.Equal(t, "kube-system_kube-dns_v20", podId(pod))
+
+	for _, id := range []string{"", "kube-dns", "_kube-dns", "kube-system_"} {
+		_, err := podFromId(id)
+		assert.Error(t, err, id)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/types"
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+const eventSource = "service-loadbalancer"
+
+// eventRecorder records events about the services and ingresses exposed by
+// the controller.
+type eventRecorder interface {
+	Eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{})
+	// Forget drops what is known about obj once it is deleted.
+	Forget(obj interface{})
+}
+
+// serviceEventRecorder is an eventRecorder that creates events through the
+// apiserver. Since every sync looks at all the objects again, an event is only
+// created the first time a message is recorded for a given object. The
+// messages of an object are kept until it is deleted.
+type serviceEventRecorder struct {
+	client client.EventNamespacer
+
+	lock     sync.Mutex
+	recorded map[types.UID]sets.String
+}
+
+func newServiceEventRecorder(c client.EventNamespacer) *serviceEventRecorder {
+	return &serviceEventRecorder{client: c, recorded: map[types.UID]sets.String{}}
+}
+
+// objectKind returns the kind and api version of the objects events are
+// recorded for.
+func objectKind(obj runtime.Object) (string, string) {
+	switch obj.(type) {
+	case *extensions.Ingress:
+		return "Ingress", "extensions/v1beta1"
+	default:
+		return "Service", "v1"
+	}
+}
+
+// Eventf creates an event about obj, unless the same one was already created.
+func (r *serviceEventRecorder) Eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	s, err := api.ObjectMetaFor(obj)
+	if err != nil {
+		glog.Errorf("Failed to record event %v for %+v: %v", reason, obj, err)
+		return
+	}
+	kind, apiVersion := objectKind(obj)
+	message := fmt.Sprintf(messageFmt, args...)
+	key := fmt.Sprintf("%v/%v", reason, message)
+	r.lock.Lock()
+	recorded, ok := r.recorded[s.UID]
+	if !ok {
+		recorded = sets.NewString()
+		r.recorded[s.UID] = recorded
+	}
+	if recorded.Has(key) {
+		r.lock.Unlock()
+		return
+	}
+	recorded.Insert(key)
+	r.lock.Unlock()
+
+	glog.Infof("Event(%v %v/%v): %v %v: %v", kind, s.Namespace, s.Name, eventType, reason, message)
+	now := unversioned.Now()
+	event := &api.Event{
+		ObjectMeta: api.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", s.Name, now.UnixNano()),
+			Namespace: s.Namespace,
+		},
+		InvolvedObject: api.ObjectReference{
+			Kind:            kind,
+			APIVersion:      apiVersion,
+			Namespace:       s.Namespace,
+			Name:            s.Name,
+			UID:             s.UID,
+			ResourceVersion: s.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Source:         api.EventSource{Component: eventSource},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+	if _, err := r.client.Events(s.Namespace).Create(event); err != nil {
+		glog.Errorf("Failed to create event for %v %v/%v: %v", kind, s.Namespace, s.Name, err)
+	}
+}
+
+// Forget drops the messages recorded for obj, which can be the tombstone of a
+// deleted object.
+func (r *serviceEventRecorder) Forget(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	o, ok := obj.(runtime.Object)
+	if !ok {
+		return
+	}
+	s, err := api.ObjectMetaFor(o)
+	if err != nil {
+		return
+	}
+	r.lock.Lock()
+	delete(r.recorded, s.UID)
+	r.lock.Unlock()
+}
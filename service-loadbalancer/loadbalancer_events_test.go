@@ -0,0 +1,66 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// fakeEvents counts the events created through it.
+type fakeEvents struct {
+	client.EventInterface
+	created *int
+}
+
+func (e fakeEvents) Create(event *api.Event) (*api.Event, error) {
+	*e.created++
+	return event, nil
+}
+
+type fakeEventNamespacer struct {
+	created int
+}
+
+func (n *fakeEventNamespacer) Events(namespace string) client.EventInterface {
+	return fakeEvents{created: &n.created}
+}
+
+func TestServiceEventRecorder(t *testing.T) {
+	events := &fakeEventNamespacer{}
+	r := newServiceEventRecorder(events)
+	s := getService(nil)
+	s.ObjectMeta.UID = "uid-1"
+
+	r.Eventf(s, api.EventTypeWarning, reasonConflict, "backend %v is already used", "foo")
+	r.Eventf(s, api.EventTypeWarning, reasonConflict, "backend %v is already used", "foo")
+	if events.created != 1 {
+		t.Fatalf("Expected the same event to be created once, got %v", events.created)
+	}
+
+	r.Forget(cache.DeletedFinalStateUnknown{Key: "default/svc", Obj: s})
+	if len(r.recorded) != 0 {
+		t.Fatalf("Expected the events of deleted services to be forgotten, got %v", r.recorded)
+	}
+	r.Eventf(s, api.EventTypeWarning, reasonConflict, "backend %v is already used", "foo")
+	if events.created != 2 {
+		t.Fatalf("Expected the event to be created again, got %v", events.created)
+	}
+}
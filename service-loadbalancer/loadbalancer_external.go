@@ -0,0 +1,100 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/util/intstr"
+	"k8s.io/kubernetes/pkg/util/validation"
+)
+
+// resolvConf is the resolver configuration the nameservers of external
+// services are read from, if --dns-resolvers is not set.
+const resolvConf = "/etc/resolv.conf"
+
+// getExternalName returns the dns name or ip of the out-of-cluster backend of
+// s, if it has one. Invalid names are ignored and recorded as events on s.
+func (lbc *loadBalancerController) getExternalName(s *api.Service) (string, bool) {
+	name, ok := serviceAnnotations(s.ObjectMeta.Annotations).getExternalName()
+	if !ok {
+		return "", false
+	}
+	if net.ParseIP(name) == nil && !validation.IsDNS1123Subdomain(name) {
+		if lbc.recorder != nil {
+			lbc.recorder.Eventf(s, api.EventTypeWarning, reasonInvalidAnnotation,
+				"Ignoring annotation %v=%q: must be a dns name or an ip", lbExternalNameKey, name)
+		}
+		return "", false
+	}
+	return name, true
+}
+
+// getExternalPort returns the port of the external backend of servicePort:
+// the target port if it is a number, the service port otherwise.
+func getExternalPort(servicePort *api.ServicePort) int {
+	if servicePort.TargetPort.Type == intstr.Int && servicePort.TargetPort.IntValue() > 0 {
+		return servicePort.TargetPort.IntValue()
+	}
+	return servicePort.Port
+}
+
+// parseNameservers parses a comma separated list of ip[:port] nameservers,
+// port 53 is used if missing.
+func parseNameservers(nameservers string) ([]string, error) {
+	result := []string{}
+	for _, ns := range strings.Split(nameservers, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(ns)
+		if err != nil {
+			host, port = ns, "53"
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid nameserver %v", ns)
+		}
+		result = append(result, net.JoinHostPort(host, port))
+	}
+	return result, nil
+}
+
+// readResolvConf returns the nameservers listed in the resolv.conf at path.
+func readResolvConf(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nameservers := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" || net.ParseIP(fields[1]) == nil {
+			continue
+		}
+		nameservers = append(nameservers, net.JoinHostPort(fields[1], "53"))
+	}
+	return nameservers, scanner.Err()
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+func TestHeadlessServices(t *testing.T) {
+	servicePorts := []api.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}}
+	endpointPorts := []api.EndpointPort{{Port: 8080, Protocol: "TCP"}}
+	addresses := []api.EndpointAddress{{IP: "1.2.3.4"}, {IP: "5.6.7.8"}}
+
+	vip := getService(servicePorts)
+	vip.ObjectMeta.Name = "vip"
+	vip.Spec.ClusterIP = "10.0.0.10"
+	headless := getService(servicePorts)
+	headless.ObjectMeta.Name = "headless"
+	headless.Spec.ClusterIP = api.ClusterIPNone
+	flb := newFakeLoadBalancerController([]*api.Endpoints{
+		getEndpoints(vip, addresses, endpointPorts),
+		getEndpoints(headless, addresses, endpointPorts),
+	}, []*api.Service{vip, headless})
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+	flb.cfg = parseCfg(cfg, "roundrobin", "", "")
+	flb.forwardServices = true
+
+	http, _, _ := flb.getServices()
+	if len(http) != 2 {
+		t.Fatalf("Expected 2 services, got %+v", http)
+	}
+	expected := map[string][]string{
+		"headless": {"1.2.3.4:8080", "5.6.7.8:8080"},
+		"vip":      {"10.0.0.10:80"},
+	}
+	for _, svc := range http {
+		if !reflect.DeepEqual(svc.Ep, expected[svc.Name]) {
+			t.Errorf("Expected endpoints %v for %v, got %v", expected[svc.Name], svc.Name, svc.Ep)
+		}
+	}
+}
+
+func TestExternalServices(t *testing.T) {
+	external := getService([]api.ServicePort{{Port: 80, TargetPort: intstr.FromInt(5432)}})
+	external.ObjectMeta.Name = "db"
+	external.ObjectMeta.Annotations = map[string]string{lbExternalNameKey: "db.example.com"}
+	invalid := getService([]api.ServicePort{{Port: 80, TargetPort: intstr.FromInt(5432)}})
+	invalid.ObjectMeta.Name = "invalid"
+	invalid.ObjectMeta.Annotations = map[string]string{lbExternalNameKey: "db example com"}
+	flb := newFakeLoadBalancerController(nil, []*api.Service{external, invalid})
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+	flb.cfg = parseCfg(cfg, "roundrobin", "", "")
+	recorder := &fakeRecorder{}
+	flb.recorder = recorder
+
+	http, _, _ := flb.getServices()
+	if len(http) != 1 {
+		t.Fatalf("Expected only the valid external service, got %+v", http)
+	}
+	svc := http[0]
+	if svc.ExternalName != "db.example.com" || !reflect.DeepEqual(svc.Ep, []string{"db.example.com:5432"}) ||
+		svc.BackendPort != 5432 {
+		t.Fatalf("Unexpected external service %+v", svc)
+	}
+	if len(recorder.events) != 1 || !strings.Contains(recorder.events[0], lbExternalNameKey) {
+		t.Errorf("Expected an event for the invalid external name, got %v", recorder.events)
+	}
+
+	flb.cfg.nameservers = []string{"10.0.0.53:53"}
+	var buf bytes.Buffer
+	if err := flb.cfg.render(map[string][]service{"http": http}, &buf); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	for _, line := range []string{
+		"resolvers dns\n    nameserver dns0 10.0.0.53:53\n",
+		"server db.example.com:5432 db.example.com:5432 resolvers dns resolve-prefer ipv4 check port 5432",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected %q in the rendered config:\n%v", line, buf.String())
+		}
+	}
+}
+
+func TestNameservers(t *testing.T) {
+	f, err := ioutil.TempFile("", "resolv.conf")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("search default.svc.cluster.local svc.cluster.local\nnameserver 10.0.0.10\nnameserver fe80::1\noptions ndots:5\n")
+	f.Close()
+
+	nameservers, err := readResolvConf(f.Name())
+	expected := []string{"10.0.0.10:53", "[fe80::1]:53"}
+	if err != nil || !reflect.DeepEqual(nameservers, expected) {
+		t.Errorf("Expected nameservers %v, got %v: %v", expected, nameservers, err)
+	}
+
+	nameservers, err = parseNameservers("10.0.0.10, 10.0.0.11:5353")
+	expected = []string{"10.0.0.10:53", "10.0.0.11:5353"}
+	if err != nil || !reflect.DeepEqual(nameservers, expected) {
+		t.Errorf("Expected nameservers %v, got %v: %v", expected, nameservers, err)
+	}
+	if _, err := parseNameservers("dns.example.com"); err == nil {
+		t.Errorf("Expected nameservers to be ips")
+	}
+}
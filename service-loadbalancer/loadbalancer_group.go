@@ -0,0 +1,228 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// defaultServiceWeight is the weight of grouped services without a weight
+	// annotation.
+	defaultServiceWeight = 100
+	// maxServiceWeight is the highest weight accepted in the weight annotation.
+	maxServiceWeight = 1000
+	// maxServerWeight is the highest server weight supported by haproxy.
+	maxServerWeight = 256
+
+	// reasonInvalidGroup is the reason of the events recorded for services
+	// whose group can't share a backend.
+	reasonInvalidGroup = "InvalidGroup"
+)
+
+// setGroup sets the group and weight of newSvc from the annotations of s. The
+// group backend is named like services, after the group, the namespace and
+// the service port.
+func (lbc *loadBalancerController) setGroup(s *api.Service, newSvc *service, servicePort int) {
+	annotations := serviceAnnotations(s.ObjectMeta.Annotations)
+	group, ok := annotations.getGroup()
+	if !ok || group == "" {
+		return
+	}
+	newSvc.Group = lbc.getNameForLBRule(group, s.Namespace, servicePort)
+
+	newSvc.Weight = defaultServiceWeight
+	if val, ok := annotations.getWeight(); ok {
+		w, err := strconv.Atoi(val)
+		if err == nil && (w < 0 || w > maxServiceWeight) {
+			err = fmt.Errorf("must be between 0 and %v", maxServiceWeight)
+		}
+		if err != nil {
+			if lbc.recorder != nil {
+				lbc.recorder.Eventf(s, api.EventTypeWarning, reasonInvalidAnnotation,
+					"Ignoring annotation %v=%q: %v", lbWeightKey, val, err)
+			}
+		} else {
+			newSvc.Weight = w
+		}
+	}
+}
+
+// ungroupMixedServices rejects the groups whose members are found in several
+// of the lists of services, ie: with and without ssl termination or tcp mode,
+// since a backend can't be both. The members of such groups are exposed as
+// standalone services, and an event is recorded for each of them.
+func (lbc *loadBalancerController) ungroupMixedServices(lists ...[]service) {
+	found := map[string]map[int]bool{}
+	for i, services := range lists {
+		for _, svc := range services {
+			if svc.Group == "" {
+				continue
+			}
+			if found[svc.Group] == nil {
+				found[svc.Group] = map[int]bool{}
+			}
+			found[svc.Group][i] = true
+		}
+	}
+
+	for _, services := range lists {
+		for i := range services {
+			svc := &services[i]
+			if svc.Group == "" || len(found[svc.Group]) < 2 {
+				continue
+			}
+			glog.Warningf("Not grouping service %v in %v, the members of the group disagree on ssl termination or tcp mode",
+				svc.Name, svc.Group)
+			if lbc.recorder != nil && svc.source != nil {
+				lbc.recorder.Eventf(svc.source, api.EventTypeWarning, reasonInvalidGroup,
+					"Not grouping %v in %v, the members of the group disagree on ssl termination or tcp mode",
+					svc.Name, svc.Group)
+			}
+			svc.Group = ""
+			svc.Weight = 0
+		}
+	}
+}
+
+// ungroupReservedNames rejects the groups named like a service that isn't
+// grouped, since both would render a backend with the same name. The service
+// keeps its backend, the members of the group are exposed as standalone
+// services, and an event is recorded for each of them.
+func (lbc *loadBalancerController) ungroupReservedNames(lists ...[]service) {
+	reserved := map[string]bool{}
+	for _, services := range lists {
+		for _, svc := range services {
+			if svc.Group == "" {
+				reserved[svc.Name] = true
+			}
+		}
+	}
+
+	for _, services := range lists {
+		for i := range services {
+			svc := &services[i]
+			if svc.Group == "" || !reserved[svc.Group] {
+				continue
+			}
+			glog.Warningf("Not grouping service %v in %v, the name of the group is used by another service",
+				svc.Name, svc.Group)
+			if lbc.recorder != nil && svc.source != nil {
+				lbc.recorder.Eventf(svc.source, api.EventTypeWarning, reasonInvalidGroup,
+					"Not grouping %v in %v, the name of the group is used by another service",
+					svc.Name, svc.Group)
+			}
+			svc.Group = ""
+			svc.Weight = 0
+		}
+	}
+}
+
+// groupServices replaces the services of every group with a single backend
+// holding the endpoints of all of them, weighted so that every service gets
+// its share of the traffic regardless of its number of endpoints. The
+// frontend settings of the backend are the ones of the service with the
+// highest weight. An endpoint shared by several services is a single server.
+func groupServices(services []service) []service {
+	groups := map[string][]service{}
+	result := []service{}
+	for _, svc := range services {
+		if svc.Group == "" {
+			result = append(result, svc)
+			continue
+		}
+		groups[svc.Group] = append(groups[svc.Group], svc)
+	}
+
+	for name, members := range groups {
+		sort.Sort(serviceByWeight(members))
+		grouped := members[0]
+		grouped.Name = name
+		grouped.Ep = []string{}
+		grouped.Weights = serverWeights(members)
+		seen := map[string]bool{}
+		for _, member := range members {
+			if member.BackendPort != grouped.BackendPort {
+				glog.Warningf("Service %v of group %v uses port %v instead of %v, health checks use the latter",
+					member.Name, name, member.BackendPort, grouped.BackendPort)
+			}
+			for _, ep := range member.Ep {
+				if !seen[ep] {
+					seen[ep] = true
+					grouped.Ep = append(grouped.Ep, ep)
+				}
+			}
+		}
+		grouped.Servers = endpointServers(grouped.Ep)
+		for i := range grouped.Servers {
+			grouped.Servers[i].Weight = grouped.Weights[grouped.Servers[i].Addr]
+		}
+		glog.Infof("Found service group %v: %v", name, grouped.Weights)
+		result = append(result, grouped)
+	}
+	return result
+}
+
+// serverWeights returns the haproxy weight of every endpoint of services. The
+// weight of a service is split among its endpoints, and the result is scaled
+// to the range of haproxy weights. An endpoint shared by several services gets
+// the sum of their shares.
+func serverWeights(services []service) map[string]int {
+	share := func(svc service) float64 {
+		return float64(svc.Weight) / float64(len(svc.Ep))
+	}
+	max := 0.0
+	for _, svc := range services {
+		max = math.Max(max, share(svc))
+	}
+
+	weights := map[string]int{}
+	for _, svc := range services {
+		w := 0
+		if svc.Weight > 0 {
+			w = int(math.Max(1, math.Floor(share(svc)/max*maxServerWeight+0.5)))
+		}
+		for _, ep := range svc.Ep {
+			weights[ep] = int(math.Min(float64(weights[ep]+w), maxServerWeight))
+		}
+	}
+	return weights
+}
+
+// serviceByWeight sorts services by decreasing weight, then by name.
+type serviceByWeight []service
+
+func (s serviceByWeight) Len() int {
+	return len(s)
+}
+
+func (s serviceByWeight) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s serviceByWeight) Less(i, j int) bool {
+	if s[i].Weight != s[j].Weight {
+		return s[i].Weight > s[j].Weight
+	}
+	return s[i].Name < s[j].Name
+}
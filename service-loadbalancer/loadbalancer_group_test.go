@@ -0,0 +1,209 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+func TestGroupServices(t *testing.T) {
+	servicePorts := []api.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}}
+	endpointPorts := []api.EndpointPort{{Port: 8080, Protocol: "TCP"}}
+
+	stable := getService(servicePorts)
+	stable.ObjectMeta.Name = "app"
+	stable.ObjectMeta.Annotations = map[string]string{
+		lbGroupKey:  "app-group",
+		lbWeightKey: "90",
+		lbHostKey:   "app.foo.bar",
+	}
+	canary := getService(servicePorts)
+	canary.ObjectMeta.Name = "app-canary"
+	canary.ObjectMeta.Annotations = map[string]string{
+		lbGroupKey:  "app-group",
+		lbWeightKey: "10",
+	}
+	other := getService(servicePorts)
+	other.ObjectMeta.Name = "other"
+	endpoints := []*api.Endpoints{
+		getEndpoints(stable, []api.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}, {IP: "10.0.0.3"}}, endpointPorts),
+		getEndpoints(canary, []api.EndpointAddress{{IP: "10.0.1.1"}}, endpointPorts),
+		getEndpoints(other, []api.EndpointAddress{{IP: "10.0.2.1"}}, endpointPorts),
+	}
+
+	flb := newFakeLoadBalancerController(endpoints, []*api.Service{stable, canary, other})
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+	flb.cfg = parseCfg(cfg, "roundrobin", "", "")
+	http, _, _ := flb.getServices()
+	if len(http) != 2 || http[0].Name != "app-group" || http[1].Name != "other" {
+		t.Fatalf("Expected the grouped services to share a backend, got %+v", http)
+	}
+
+	grouped := http[0]
+	if grouped.Host != "app.foo.bar" {
+		t.Errorf("Expected the host of the service with the highest weight, got %q", grouped.Host)
+	}
+	// 90% of the traffic over 3 endpoints, 10% over 1.
+	expected := map[string]int{
+		"10.0.0.1:8080": 256,
+		"10.0.0.2:8080": 256,
+		"10.0.0.3:8080": 256,
+		"10.0.1.1:8080": 85,
+	}
+	if !reflect.DeepEqual(grouped.Weights, expected) {
+		t.Errorf("Expected weights %v, got %v", expected, grouped.Weights)
+	}
+	if http[1].Weights != nil {
+		t.Errorf("Expected services without a group not to be weighted, got %v", http[1].Weights)
+	}
+
+	var buf bytes.Buffer
+	if err := flb.cfg.render(map[string][]service{"http": http}, &buf); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	for _, line := range []string{
+		"server 10.0.0.1:8080 10.0.0.1:8080 weight 256 check",
+		"server 10.0.1.1:8080 10.0.1.1:8080 weight 85 check",
+		"server 10.0.2.1:8080 10.0.2.1:8080 check",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected %q in the rendered config:\n%v", line, buf.String())
+		}
+	}
+}
+
+func TestGroupMixedServices(t *testing.T) {
+	servicePorts := []api.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}}
+	endpointPorts := []api.EndpointPort{{Port: 8080, Protocol: "TCP"}}
+
+	stable := getService(servicePorts)
+	stable.ObjectMeta.Name = "app"
+	stable.ObjectMeta.Annotations = map[string]string{lbGroupKey: "app-group", lbSslTerm: "true"}
+	canary := getService(servicePorts)
+	canary.ObjectMeta.Name = "app-canary"
+	canary.ObjectMeta.Annotations = map[string]string{lbGroupKey: "app-group"}
+	endpoints := []*api.Endpoints{
+		getEndpoints(stable, []api.EndpointAddress{{IP: "10.0.0.1"}}, endpointPorts),
+		getEndpoints(canary, []api.EndpointAddress{{IP: "10.0.1.1"}}, endpointPorts),
+	}
+
+	flb := newFakeLoadBalancerController(endpoints, []*api.Service{stable, canary})
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+	flb.cfg = parseCfg(cfg, "roundrobin", "", "")
+	recorder := &fakeRecorder{}
+	flb.recorder = recorder
+	http, httpsTerm, _ := flb.getServices()
+	if len(http) != 1 || http[0].Name != "app-canary" || http[0].Group != "" {
+		t.Errorf("Expected the canary to be exposed on its own, got %+v", http)
+	}
+	if len(httpsTerm) != 1 || httpsTerm[0].Name != "app" || httpsTerm[0].Group != "" {
+		t.Errorf("Expected the stable service to be exposed on its own, got %+v", httpsTerm)
+	}
+	if len(recorder.events) != 2 || !strings.Contains(recorder.events[0], reasonInvalidGroup) {
+		t.Errorf("Expected an event for each member of the rejected group, got %v", recorder.events)
+	}
+}
+
+func TestGroupReservedName(t *testing.T) {
+	servicePorts := []api.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}}
+	endpointPorts := []api.EndpointPort{{Port: 8080, Protocol: "TCP"}}
+
+	existing := getService(servicePorts)
+	existing.ObjectMeta.Name = "app"
+	stable := getService(servicePorts)
+	stable.ObjectMeta.Name = "app-v1"
+	stable.ObjectMeta.Annotations = map[string]string{lbGroupKey: "app"}
+	canary := getService(servicePorts)
+	canary.ObjectMeta.Name = "app-v2"
+	canary.ObjectMeta.Annotations = map[string]string{lbGroupKey: "app"}
+	endpoints := []*api.Endpoints{
+		getEndpoints(existing, []api.EndpointAddress{{IP: "10.0.0.1"}}, endpointPorts),
+		getEndpoints(stable, []api.EndpointAddress{{IP: "10.0.1.1"}}, endpointPorts),
+		getEndpoints(canary, []api.EndpointAddress{{IP: "10.0.2.1"}}, endpointPorts),
+	}
+
+	flb := newFakeLoadBalancerController(endpoints, []*api.Service{existing, stable, canary})
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+	flb.cfg = parseCfg(cfg, "roundrobin", "", "")
+	recorder := &fakeRecorder{}
+	flb.recorder = recorder
+	http, _, _ := flb.getServices()
+	names := []string{}
+	for _, svc := range http {
+		names = append(names, svc.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"app", "app-v1", "app-v2"}) {
+		t.Errorf("Expected the service to keep its name and the group to be rejected, got %v", names)
+	}
+	if len(recorder.events) != 2 || !strings.Contains(recorder.events[0], reasonInvalidGroup) {
+		t.Errorf("Expected an event for each member of the rejected group, got %v", recorder.events)
+	}
+}
+
+func TestGroupSharedEndpoints(t *testing.T) {
+	services := []service{
+		{Name: "a", Group: "app", Weight: 100, Ep: []string{"10.0.0.1:80", "10.0.0.2:80"}},
+		{Name: "b", Group: "app", Weight: 100, Ep: []string{"10.0.0.2:80"}},
+	}
+	grouped := groupServices(services)
+	if len(grouped) != 1 {
+		t.Fatalf("Expected a single backend, got %+v", grouped)
+	}
+	if !reflect.DeepEqual(grouped[0].Ep, []string{"10.0.0.1:80", "10.0.0.2:80"}) {
+		t.Errorf("Expected a shared endpoint to be listed once, got %v", grouped[0].Ep)
+	}
+	if len(grouped[0].Servers) != 2 {
+		t.Errorf("Expected a server per endpoint, got %+v", grouped[0].Servers)
+	}
+	// the shared endpoint gets both shares
+	expected := map[string]int{"10.0.0.1:80": 128, "10.0.0.2:80": 256}
+	if !reflect.DeepEqual(grouped[0].Weights, expected) {
+		t.Errorf("Expected weights %v, got %v", expected, grouped[0].Weights)
+	}
+}
+
+func TestServerWeights(t *testing.T) {
+	testCases := []struct {
+		services []service
+		expected map[string]int
+	}{
+		{
+			[]service{{Weight: 100, Ep: []string{"a", "b"}}, {Weight: 100, Ep: []string{"c"}}},
+			map[string]int{"a": 128, "b": 128, "c": 256},
+		},
+		{
+			[]service{{Weight: 1000, Ep: []string{"a"}}, {Weight: 1, Ep: []string{"b"}}},
+			map[string]int{"a": 256, "b": 1},
+		},
+		{
+			[]service{{Weight: 100, Ep: []string{"a"}}, {Weight: 0, Ep: []string{"b"}}},
+			map[string]int{"a": 256, "b": 0},
+		},
+	}
+	for i, tc := range testCases {
+		if weights := serverWeights(tc.services); !reflect.DeepEqual(weights, tc.expected) {
+			t.Errorf("%v: expected weights %v, got %v", i, tc.expected, weights)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// defaultHealthCheckInterval is the haproxy check interval, in milliseconds,
+	// used if a service doesn't specify one.
+	defaultHealthCheckInterval = 5
+
+	// reasonInvalidAnnotation is the reason of the events recorded for
+	// annotations that can't be parsed.
+	reasonInvalidAnnotation = "InvalidAnnotation"
+)
+
+// parsePositiveInt parses an annotation that must be a positive integer.
+func parsePositiveInt(val string) (int, error) {
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, err
+	}
+	if i <= 0 {
+		return 0, fmt.Errorf("must be greater than 0")
+	}
+	return i, nil
+}
+
+// setHealthCheck configures the health checks and connection limit of newSvc
+// from the annotations of s. checkByDefault tells whether the backend is
+// checked if s doesn't enable or disable checks explicitly. Invalid values are
+// ignored and recorded as events on s.
+func (lbc *loadBalancerController) setHealthCheck(s *api.Service, newSvc *service, checkByDefault bool) {
+	annotations := serviceAnnotations(s.ObjectMeta.Annotations)
+	invalid := func(key, val string, err error) {
+		if lbc.recorder != nil {
+			lbc.recorder.Eventf(s, api.EventTypeWarning, reasonInvalidAnnotation,
+				"Ignoring annotation %v=%q: %v", key, val, err)
+		}
+	}
+
+	newSvc.HealthCheck = checkByDefault
+	if val, ok := annotations.getHealthCheck(); ok {
+		if b, err := strconv.ParseBool(val); err != nil {
+			invalid(lbHealthCheckKey, val, err)
+		} else {
+			newSvc.HealthCheck = b
+		}
+	}
+
+	if val, ok := annotations.getHealthCheckPath(); ok {
+		if !strings.HasPrefix(val, "/") || strings.IndexAny(val, " \t\r\n") != -1 {
+			invalid(lbHealthCheckPathKey, val, fmt.Errorf("must be an absolute path without whitespaces"))
+		} else {
+			newSvc.HealthCheckPath = val
+		}
+	}
+
+	newSvc.HealthCheckInterval = defaultHealthCheckInterval
+	if val, ok := annotations.getHealthCheckInterval(); ok {
+		if d, err := time.ParseDuration(val); err != nil {
+			invalid(lbHealthCheckIntervalKey, val, err)
+		} else if d < time.Millisecond {
+			invalid(lbHealthCheckIntervalKey, val, fmt.Errorf("must be at least 1ms"))
+		} else {
+			newSvc.HealthCheckInterval = int(d / time.Millisecond)
+		}
+	}
+
+	for _, opt := range []struct {
+		key   string
+		get   func() (string, bool)
+		field *int
+	}{
+		{lbHealthCheckRiseKey, annotations.getHealthCheckRise, &newSvc.HealthCheckRise},
+		{lbHealthCheckFallKey, annotations.getHealthCheckFall, &newSvc.HealthCheckFall},
+		{lbMaxConnKey, annotations.getMaxConn, &newSvc.MaxConn},
+	} {
+		if val, ok := opt.get(); ok {
+			if i, err := parsePositiveInt(val); err != nil {
+				invalid(opt.key, val, err)
+			} else {
+				*opt.field = i
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/runtime"
+)
+
+// fakeRecorder keeps the messages of the recorded events.
+type fakeRecorder struct {
+	events []string
+}
+
+func (r *fakeRecorder) Eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	r.events = append(r.events, fmt.Sprintf("%v %v %v", eventType, reason, fmt.Sprintf(messageFmt, args...)))
+}
+
+func (r *fakeRecorder) Forget(obj interface{}) {}
+
+func TestSetHealthCheck(t *testing.T) {
+	testCases := []struct {
+		annotations    map[string]string
+		checkByDefault bool
+		expected       service
+		events         int
+	}{
+		{nil, true, service{HealthCheck: true, HealthCheckInterval: 5}, 0},
+		{nil, false, service{HealthCheckInterval: 5}, 0},
+		{
+			map[string]string{
+				lbHealthCheckKey:         "true",
+				lbHealthCheckPathKey:     "/healthz",
+				lbHealthCheckIntervalKey: "2s",
+				lbHealthCheckRiseKey:     "3",
+				lbHealthCheckFallKey:     "2",
+				lbMaxConnKey:             "100",
+			},
+			false,
+			service{HealthCheck: true, HealthCheckPath: "/healthz", HealthCheckInterval: 2000,
+				HealthCheckRise: 3, HealthCheckFall: 2, MaxConn: 100},
+			0,
+		},
+		{map[string]string{lbHealthCheckKey: "false"}, true, service{HealthCheckInterval: 5}, 0},
+		{
+			map[string]string{
+				lbHealthCheckKey:         "maybe",
+				lbHealthCheckPathKey:     "healthz",
+				lbHealthCheckIntervalKey: "0s",
+				lbHealthCheckRiseKey:     "-1",
+				lbHealthCheckFallKey:     "two",
+				lbMaxConnKey:             "0",
+			},
+			true,
+			service{HealthCheck: true, HealthCheckInterval: 5},
+			6,
+		},
+	}
+
+	for i, tc := range testCases {
+		recorder := &fakeRecorder{}
+		flb := newFakeLoadBalancerController(nil, nil)
+		flb.recorder = recorder
+		s := getService(nil)
+		s.ObjectMeta.Annotations = tc.annotations
+		var svc service
+		flb.setHealthCheck(s, &svc, tc.checkByDefault)
+		if !reflect.DeepEqual(svc, tc.expected) {
+			t.Errorf("%v: expected %+v, got %+v", i, tc.expected, svc)
+		}
+		if len(recorder.events) != tc.events {
+			t.Errorf("%v: expected %v events, got %v", i, tc.events, recorder.events)
+		}
+		for _, event := range recorder.events {
+			if !strings.HasPrefix(event, api.EventTypeWarning+" "+reasonInvalidAnnotation) {
+				t.Errorf("%v: unexpected event %q", i, event)
+			}
+		}
+	}
+}
+
+func TestHealthCheckOptions(t *testing.T) {
+	flb := buildTestLoadBalancer("")
+	servers := []backendServer{{Name: "slot0", Addr: "1.2.3.4:8080"}}
+	services := map[string][]service{
+		"http": {{
+			Name: "foo", Servers: servers, BackendPort: 8080, FrontendPort: 80, Algorithm: "roundrobin",
+			HealthCheck: true, HealthCheckPath: "/healthz", HealthCheckInterval: 2000,
+			HealthCheckRise: 3, HealthCheckFall: 2, MaxConn: 100,
+		}},
+		"tcp": {{
+			Name: "bar", Servers: servers, BackendPort: 8080, FrontendPort: 3306, Algorithm: "roundrobin",
+			MaxConn: 10,
+		}},
+	}
+	var buf bytes.Buffer
+	if err := flb.cfg.render(services, &buf); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	cfg := buf.String()
+	for _, line := range []string{
+		"option httpchk GET /healthz\n",
+		"server slot0 1.2.3.4:8080 check port 8080 inter 2000 rise 3 fall 2 maxconn 100\n",
+		"server slot0 1.2.3.4:8080 maxconn 10\n",
+	} {
+		if !strings.Contains(cfg, line) {
+			t.Errorf("Expected %q in the rendered config:\n%v", line, cfg)
+		}
+	}
+}
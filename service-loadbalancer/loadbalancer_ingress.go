@@ -0,0 +1,193 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+// getIngressServices returns a service for every host/path rule of the
+// Ingress resources. Rules whose host is listed in the tls section of their
+// Ingress terminate ssl. The backends are named after the Ingress and its
+// service, and never take one of the reserved names.
+func (lbc *loadBalancerController) getIngressServices(reserved sets.String) (httpSvc []service, httpsTermSvc []service) {
+	names := sets.NewString(reserved.List()...)
+	for _, obj := range lbc.ingLister.List() {
+		ing := obj.(*extensions.Ingress)
+		if !lbc.watchesNamespace(ing.Namespace) {
+			continue
+		}
+
+		// A tls entry without hosts applies to every rule of the Ingress.
+		tlsSecrets := map[string]string{}
+		defaultTLS, defaultSecret := false, ""
+		for _, tls := range ing.Spec.TLS {
+			if len(tls.Hosts) == 0 {
+				defaultTLS, defaultSecret = true, tls.SecretName
+			}
+			for _, host := range tls.Hosts {
+				tlsSecrets[host] = tls.SecretName
+			}
+		}
+
+		rules := append([]extensions.IngressRule{}, ing.Spec.Rules...)
+		if ing.Spec.Backend != nil {
+			rules = append(rules, extensions.IngressRule{
+				IngressRuleValue: extensions.IngressRuleValue{
+					HTTP: &extensions.HTTPIngressRuleValue{
+						Paths: []extensions.HTTPIngressPath{{Backend: *ing.Spec.Backend}},
+					},
+				},
+			})
+		}
+
+		for _, rule := range rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				newSvc, ok := lbc.getIngressService(ing, rule.Host, &path)
+				if !ok {
+					continue
+				}
+				// The same backend can be used by several rules, each one
+				// needs its own haproxy backend, which can't be the one of
+				// an annotated service either.
+				name := newSvc.Name
+				for i := 1; names.Has(newSvc.Name); i++ {
+					newSvc.Name = fmt.Sprintf("%v-%v", name, i)
+				}
+				names.Insert(newSvc.Name)
+
+				secret, ok := tlsSecrets[rule.Host]
+				if !ok && defaultTLS {
+					secret, ok = defaultSecret, true
+				}
+				if ok {
+					newSvc.SslTerm = true
+					if secret != "" {
+						newSvc.SslSecret = fmt.Sprintf("%v/%v", ing.Namespace, secret)
+					}
+					httpsTermSvc = append(httpsTermSvc, newSvc)
+				} else {
+					httpSvc = append(httpSvc, newSvc)
+				}
+				glog.Infof("Found ingress rule: %+v", newSvc)
+			}
+		}
+	}
+	return
+}
+
+// backendNames returns the names of the haproxy backends of services, the
+// ones of their groups included.
+func backendNames(lists ...[]service) sets.String {
+	names := sets.NewString()
+	for _, services := range lists {
+		for _, svc := range services {
+			names.Insert(svc.Name)
+			if svc.Group != "" {
+				names.Insert(svc.Group)
+			}
+		}
+	}
+	return names
+}
+
+// getIngressService returns the service for the backend of a single Ingress
+// path. It returns false if the backend doesn't exist or has no endpoints.
+func (lbc *loadBalancerController) getIngressService(
+	ing *extensions.Ingress, host string, path *extensions.HTTPIngressPath) (service, bool) {
+	backend := path.Backend
+	if lbc.targetService != "" && lbc.targetService != backend.ServiceName &&
+		lbc.targetService != fmt.Sprintf("%v/%v", ing.Namespace, backend.ServiceName) {
+		glog.Infof("Ignoring %v: %+v", ing.Name, backend)
+		return service{}, false
+	}
+	obj, exists, err := lbc.svcLister.Store.GetByKey(fmt.Sprintf("%v/%v", ing.Namespace, backend.ServiceName))
+	if err != nil || !exists {
+		glog.Infof("Service %v referenced by ingress %v not found", backend.ServiceName, ing.Name)
+		return service{}, false
+	}
+	s := obj.(*api.Service)
+	servicePort := getIngressServicePort(s, backend.ServicePort)
+	if servicePort == nil {
+		glog.Infof("Service %v referenced by ingress %v has no port %v",
+			backend.ServiceName, ing.Name, backend.ServicePort.String())
+		return service{}, false
+	}
+	ep := lbc.getServiceEndpoints(s, servicePort)
+	if len(ep) == 0 {
+		glog.Infof("No endpoints found for service %v, port %+v", s.Name, servicePort)
+		return service{}, false
+	}
+
+	newSvc := service{
+		Name:         fmt.Sprintf("%v-%v", ing.Name, lbc.getServiceNameForLBRule(s, servicePort.Port)),
+		Ep:           ep,
+		Servers:      endpointServers(ep),
+		BackendPort:  getTargetPort(servicePort),
+		FrontendPort: lbc.httpPort,
+		Host:         host,
+		Path:         path.Path,
+		Algorithm:    lbc.cfg.lbDefAlgorithm,
+		source:       ing,
+	}
+	newSvc.ExternalName, _ = lbc.getExternalName(s)
+	if newSvc.ExternalName != "" {
+		newSvc.BackendPort = getExternalPort(servicePort)
+	}
+	if newSvc.Path == "" {
+		newSvc.Path = "/"
+	}
+	if val, ok := serviceAnnotations(s.ObjectMeta.Annotations).getAlgorithm(); ok {
+		for _, current := range supportedAlgorithms {
+			if val == current {
+				newSvc.Algorithm = val
+				break
+			}
+		}
+	}
+	newSvc.SessionAffinity = s.Spec.SessionAffinity != ""
+	lbc.setHealthCheck(s, &newSvc, true)
+	return newSvc, true
+}
+
+// getIngressServicePort returns the port of s referenced by an Ingress
+// backend, either by number or by name.
+func getIngressServicePort(s *api.Service, port intstr.IntOrString) *api.ServicePort {
+	for i := range s.Spec.Ports {
+		servicePort := &s.Spec.Ports[i]
+		switch port.Type {
+		case intstr.Int:
+			if servicePort.Port == port.IntValue() {
+				return servicePort
+			}
+		case intstr.String:
+			if servicePort.Name == port.StrVal {
+				return servicePort
+			}
+		}
+	}
+	return nil
+}
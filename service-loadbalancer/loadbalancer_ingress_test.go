@@ -0,0 +1,191 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/util/intstr"
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+func newIngressPath(path, serviceName string, servicePort intstr.IntOrString) extensions.HTTPIngressPath {
+	return extensions.HTTPIngressPath{
+		Path: path,
+		Backend: extensions.IngressBackend{
+			ServiceName: serviceName,
+			ServicePort: servicePort,
+		},
+	}
+}
+
+func newIngressRule(host string, paths ...extensions.HTTPIngressPath) extensions.IngressRule {
+	return extensions.IngressRule{
+		Host: host,
+		IngressRuleValue: extensions.IngressRuleValue{
+			HTTP: &extensions.HTTPIngressRuleValue{Paths: paths},
+		},
+	}
+}
+
+func buildTestIngressLoadBalancer(ingresses ...*extensions.Ingress) *loadBalancerController {
+	endpointAddresses := []api.EndpointAddress{
+		{IP: "1.2.3.4"},
+		{IP: "5.6.7.8"},
+	}
+	endpointPorts := []api.EndpointPort{
+		{Port: 8080, Protocol: "TCP", Name: "http"},
+	}
+	servicePorts := []api.ServicePort{
+		{Port: 80, Name: "http", TargetPort: intstr.FromInt(8080)},
+	}
+	svc1 := getService(servicePorts)
+	svc1.ObjectMeta.Name = "svc-1"
+	svc2 := getService(servicePorts)
+	svc2.ObjectMeta.Name = "svc-2"
+	endpoints := []*api.Endpoints{
+		getEndpoints(svc1, endpointAddresses, endpointPorts),
+		getEndpoints(svc2, endpointAddresses, endpointPorts),
+	}
+	flb := newFakeLoadBalancerController(endpoints, []*api.Service{svc1, svc2})
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+	flb.cfg = parseCfg(cfg, "roundrobin", "", "")
+
+	flb.ingLister = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, ing := range ingresses {
+		flb.ingLister.Add(ing)
+	}
+	return flb
+}
+
+func TestGetIngressServices(t *testing.T) {
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "ing", Namespace: api.NamespaceDefault},
+		Spec: extensions.IngressSpec{
+			Backend: &extensions.IngressBackend{ServiceName: "svc-2", ServicePort: intstr.FromInt(80)},
+			TLS: []extensions.IngressTLS{
+				{Hosts: []string{"secure.foo.bar"}, SecretName: "foo-tls"},
+			},
+			Rules: []extensions.IngressRule{
+				newIngressRule("foo.bar",
+					newIngressPath("/api", "svc-1", intstr.FromString("http")),
+					newIngressPath("/api/v2", "svc-2", intstr.FromInt(80)),
+					newIngressPath("/missing", "svc-3", intstr.FromInt(80)),
+					newIngressPath("/badport", "svc-1", intstr.FromInt(81)),
+				),
+				newIngressRule("secure.foo.bar",
+					newIngressPath("", "svc-1", intstr.FromInt(80)),
+				),
+			},
+		},
+	}
+	flb := buildTestIngressLoadBalancer(ing)
+	httpSvc, httpsTermSvc := flb.getIngressServices(sets.NewString())
+	sort.Sort(serviceByName(httpSvc))
+
+	expected := []service{
+		{Name: "ing-svc-2", Host: "foo.bar", Path: "/api/v2"},
+		{Name: "ing-svc-1", Host: "foo.bar", Path: "/api"},
+		{Name: "ing-svc-2-1", Path: "/"},
+	}
+	if len(httpSvc) != len(expected) {
+		t.Fatalf("Expected %v http services, got %+v", len(expected), httpSvc)
+	}
+	for i, svc := range httpSvc {
+		if svc.Name != expected[i].Name || svc.Host != expected[i].Host || svc.Path != expected[i].Path {
+			t.Errorf("Expected service %+v, got %+v", expected[i], svc)
+		}
+		if svc.BackendPort != 8080 || svc.FrontendPort != 80 || len(svc.Ep) != 2 {
+			t.Errorf("Unexpected backend for service %+v", svc)
+		}
+	}
+
+	if len(httpsTermSvc) != 1 {
+		t.Fatalf("Expected 1 https service, got %+v", httpsTermSvc)
+	}
+	if svc := httpsTermSvc[0]; svc.Host != "secure.foo.bar" || svc.Path != "/" ||
+		!svc.SslTerm || svc.SslSecret != "default/foo-tls" {
+		t.Fatalf("Unexpected https service %+v", svc)
+	}
+}
+
+func TestIngressReservedNames(t *testing.T) {
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "ing", Namespace: api.NamespaceDefault},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{
+				newIngressRule("foo.bar", newIngressPath("/api", "svc-1", intstr.FromInt(80))),
+			},
+		},
+	}
+	flb := buildTestIngressLoadBalancer(ing)
+	// an annotated service and a group already use the names the ingress
+	// backend would get.
+	reserved := backendNames([]service{
+		{Name: "ing-svc-1"},
+		{Name: "svc-3", Group: "ing-svc-1-1"},
+	})
+	httpSvc, _ := flb.getIngressServices(reserved)
+	if len(httpSvc) != 1 || httpSvc[0].Name != "ing-svc-1-2" {
+		t.Fatalf("Expected the ingress backend not to take a reserved name, got %+v", httpSvc)
+	}
+}
+
+func TestIngressRules(t *testing.T) {
+	ing := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "ing", Namespace: api.NamespaceDefault},
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{
+				newIngressRule("foo.bar", newIngressPath("/api", "svc-1", intstr.FromInt(80))),
+			},
+		},
+	}
+	flb := buildTestIngressLoadBalancer(ing)
+	httpSvc, _, _ := flb.getServices()
+	httpIng, _ := flb.getIngressServices(backendNames(httpSvc))
+	httpSvc = append(httpSvc, httpIng...)
+	sort.Sort(serviceByName(httpSvc))
+	if httpSvc[0].Name != "ing-svc-1" {
+		t.Fatalf("Expected ingress rules to be evaluated first, got %+v", httpSvc)
+	}
+
+	var buf bytes.Buffer
+	if err := flb.cfg.render(map[string][]service{"http": httpSvc}, &buf); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	cfg := buf.String()
+	for _, line := range []string{
+		"acl url_acl_ing-svc-1 path_beg /api",
+		"acl host_acl_ing-svc-1 hdr(host) foo.bar",
+		"use_backend ing-svc-1 if host_acl_ing-svc-1 url_acl_ing-svc-1",
+		"use_backend svc-1 if url_acl_svc-1",
+	} {
+		if !strings.Contains(cfg, line) {
+			t.Errorf("Expected %q in the rendered config", line)
+		}
+	}
+	if strings.Contains(cfg, "/ing-svc-1[/]?") {
+		t.Errorf("Expected the path of ingress rules not to be rewritten")
+	}
+}
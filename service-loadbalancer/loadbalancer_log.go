@@ -17,14 +17,85 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/ziutek/syslog"
 )
 
+// httpLogRegexp matches the haproxy http log format (option httplog):
+//
+//	client:port [accept date] frontend backend/server Tq/Tw/Tc/Tr/Tt status bytes
+//	req_cookie res_cookie termination_state actconn/feconn/beconn/srv_conn/retries
+//	srv_queue/backend_queue {captured request headers} {captured response headers} "request"
+var httpLogRegexp = regexp.MustCompile(`^(\S+):(\d+) \[([^\]]+)\] (\S+) ([^/\s]+)/(\S+) ` +
+	`(-?\d+)/(-?\d+)/(-?\d+)/(-?\d+)/\+?(-?\d+) (-?\d+) \+?(\d+) \S+ \S+ (\S{4}) ` +
+	`\d+/\d+/\d+/\d+/\+?\d+ \d+/\d+ (?:\{[^}]*\} ){0,2}"(.*)"$`)
+
+// httpLogRecord is a structured haproxy http log. Timings are in milliseconds,
+// -1 if the step was not reached.
+type httpLogRecord struct {
+	Time             string `json:"time"`
+	Client           string `json:"client"`
+	Frontend         string `json:"frontend"`
+	Backend          string `json:"backend"`
+	Server           string `json:"server"`
+	Status           int    `json:"status"`
+	Bytes            int64  `json:"bytes"`
+	TimeRequest      int    `json:"timeRequest"`
+	TimeQueue        int    `json:"timeQueue"`
+	TimeConnect      int    `json:"timeConnect"`
+	TimeResponse     int    `json:"timeResponse"`
+	TimeTotal        int    `json:"timeTotal"`
+	TerminationState string `json:"terminationState"`
+	Request          string `json:"request"`
+}
+
+// parseHTTPLog parses a haproxy http log line. It returns false if the line
+// uses a different format, like the logs of tcp frontends or haproxy events.
+func parseHTTPLog(line string) (*httpLogRecord, bool) {
+	m := httpLogRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+	atoi := func(s string) int {
+		i, _ := strconv.Atoi(s)
+		return i
+	}
+	bytes, _ := strconv.ParseInt(m[13], 10, 64)
+	return &httpLogRecord{
+		Time:             m[3],
+		Client:           m[1] + ":" + m[2],
+		Frontend:         m[4],
+		Backend:          m[5],
+		Server:           m[6],
+		TimeRequest:      atoi(m[7]),
+		TimeQueue:        atoi(m[8]),
+		TimeConnect:      atoi(m[9]),
+		TimeResponse:     atoi(m[10]),
+		TimeTotal:        atoi(m[11]),
+		Status:           atoi(m[12]),
+		Bytes:            bytes,
+		TerminationState: m[14],
+		Request:          m[15],
+	}, true
+}
+
+// observe updates the request metrics of the backend of r.
+func (r *httpLogRecord) observe() {
+	requestsCount.WithLabelValues(r.Frontend, r.Backend, strconv.Itoa(r.Status)).Inc()
+	if r.TimeResponse >= 0 {
+		responseLatency.WithLabelValues(r.Backend).Observe(
+			(time.Duration(r.TimeResponse) * time.Millisecond).Seconds())
+	}
+}
+
 type handler struct {
 	*syslog.BaseHandler
 }
@@ -62,6 +133,13 @@ func (h *handler) mainLoop() {
 			break
 		}
 
+		if record, ok := parseHTTPLog(message.Content1); ok {
+			record.observe()
+			if b, err := json.Marshal(record); err == nil {
+				fmt.Printf("%s\n", b)
+				continue
+			}
+		}
 		fmt.Printf("servicelb [%s] %s%s\n", strings.ToUpper(message.Severity.String()), message.Tag, message.Content)
 	}
 
@@ -17,7 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"strings"
 	"testing"
+
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestSyslogSocket(t *testing.T) {
@@ -28,3 +32,91 @@ func TestSyslogSocket(t *testing.T) {
 
 	server.Shutdown()
 }
+
+func TestParseHTTPLog(t *testing.T) {
+	line := `10.0.1.2:33317 [06/Feb/2009:12:14:14.655] httpfrontend-80 foo/slot1 10/0/30/69/109 ` +
+		`503 2750 - - ---- 1/1/1/1/0 0/0 {1wt.eu} {} "GET /foo/index.html HTTP/1.1"`
+	record, ok := parseHTTPLog(line)
+	if !ok {
+		t.Fatalf("Expected %q to be parsed as an http log", line)
+	}
+	expected := httpLogRecord{
+		Time:             "06/Feb/2009:12:14:14.655",
+		Client:           "10.0.1.2:33317",
+		Frontend:         "httpfrontend-80",
+		Backend:          "foo",
+		Server:           "slot1",
+		Status:           503,
+		Bytes:            2750,
+		TimeRequest:      10,
+		TimeQueue:        0,
+		TimeConnect:      30,
+		TimeResponse:     69,
+		TimeTotal:        109,
+		TerminationState: "----",
+		Request:          "GET /foo/index.html HTTP/1.1",
+	}
+	if *record != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, *record)
+	}
+
+	record.observe()
+	var m dto.Metric
+	requestsCount.WithLabelValues("httpfrontend-80", "foo", "503").Write(&m)
+	if m.GetCounter().GetValue() != 1 {
+		t.Errorf("Expected the request to be counted, got %v", m.GetCounter().GetValue())
+	}
+
+	aborted := `10.0.1.2:33318 [06/Feb/2009:12:14:14.655] httpfrontend-80 foo/<NOSRV> -1/-1/-1/-1/+5 ` +
+		`-1 +0 - - CR-- 1/1/0/0/0 0/0 "<BADREQ>"`
+	if record, ok := parseHTTPLog(aborted); !ok || record.Status != -1 || record.TimeTotal != 5 {
+		t.Errorf("Expected an aborted request to be parsed, got %+v", record)
+	}
+
+	for _, line := range []string{
+		"Proxy foo started.",
+		"10.0.1.2:33317 [06/Feb/2009:12:14:14.655] tcpfrontend mysql/slot0 1/0/5 212 -- 1/1/1/1/0 0/0",
+	} {
+		if _, ok := parseHTTPLog(line); ok {
+			t.Errorf("Expected %q not to be parsed as an http log", line)
+		}
+	}
+}
+
+func TestHTTPFrontendsLogFormat(t *testing.T) {
+	flb := buildTestLoadBalancer("")
+	flb.cfg.crtList = "/etc/haproxy/certs/crt-list"
+	services := map[string][]service{
+		"http":      {{Name: "foo", Algorithm: "roundrobin"}},
+		"httpsTerm": {{Name: "bar", Host: "bar.foo", SslTerm: true, Algorithm: "roundrobin"}},
+	}
+	var buf bytes.Buffer
+	if err := flb.cfg.render(services, &buf); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+
+	// haproxy only uses the log format of the frontend, the one of the
+	// backends is ignored
+	for _, frontend := range []string{"httpfrontend", "httpsfrontend"} {
+		section := configSection(buf.String(), "frontend "+frontend)
+		if !strings.Contains(section, "option httplog") {
+			t.Errorf("Expected %v to log in http format:\n%v", frontend, section)
+		}
+	}
+}
+
+// configSection returns the lines of the haproxy config section starting with
+// header, up to the next section.
+func configSection(cfg, header string) string {
+	var section []string
+	in := false
+	for _, line := range strings.Split(cfg, "\n") {
+		if line != "" && line[0] != ' ' && line[0] != '#' {
+			in = line == header
+		}
+		if in {
+			section = append(section, line)
+		}
+	}
+	return strings.Join(section, "\n")
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+// reasonConflict is the reason of the events recorded for backends that are
+// not exposed because another object already claims their host, acl or port.
+const reasonConflict = "Conflict"
+
+// watchesNamespace returns true if the services and ingresses of namespace
+// are exposed.
+func (lbc *loadBalancerController) watchesNamespace(namespace string) bool {
+	if lbc.namespaces.Len() > 0 && !lbc.namespaces.Has(namespace) {
+		return false
+	}
+	if lbc.nsSelector == nil {
+		return true
+	}
+	obj, exists, err := lbc.nsLister.GetByKey(namespace)
+	if err != nil || !exists {
+		return false
+	}
+	return lbc.nsSelector.Matches(labels.Set(obj.(*api.Namespace).Labels))
+}
+
+// matchesService returns true if name refers to s, either by name or by
+// namespace/name.
+func matchesService(name string, s *api.Service) bool {
+	return name == s.Name || name == fmt.Sprintf("%v/%v", s.Namespace, s.Name)
+}
+
+// lookupServicePort returns the port listed for s in ports, which is keyed by
+// service name or namespace/name. The latter takes precedence.
+func lookupServicePort(ports map[string]int, s *api.Service) (int, bool) {
+	if port, ok := ports[fmt.Sprintf("%v/%v", s.Namespace, s.Name)]; ok {
+		return port, true
+	}
+	port, ok := ports[s.Name]
+	return port, ok
+}
+
+// sourceKey returns a kind/namespace/name key identifying the source of svc.
+func sourceKey(svc *service) string {
+	if svc.source == nil {
+		return ""
+	}
+	meta, err := api.ObjectMetaFor(svc.source)
+	if err != nil {
+		return ""
+	}
+	kind, _ := objectKind(svc.source)
+	return fmt.Sprintf("%v/%v/%v", kind, meta.Namespace, meta.Name)
+}
+
+// routeClaims returns what svc claims in the frontend of kind: its backend
+// name, and the host, acl, host/path rule or port used to route traffic to it.
+func routeClaims(kind string, svc *service) []string {
+	claims := []string{fmt.Sprintf("backend %v", svc.Name)}
+	switch {
+	case kind == "tcp":
+		claims = append(claims, fmt.Sprintf("port %v", svc.FrontendPort))
+	case svc.Path != "":
+		claims = append(claims, fmt.Sprintf("%v rule %v%v", kind, svc.Host, svc.Path))
+	default:
+		if svc.Host != "" {
+			claims = append(claims, fmt.Sprintf("%v host %v", kind, svc.Host))
+		}
+		if svc.AclMatch != "" {
+			claims = append(claims, fmt.Sprintf("%v acl %v", kind, svc.AclMatch))
+		}
+	}
+	return claims
+}
+
+// claimant is a backend competing for its route claims.
+type claimant struct {
+	kind    string
+	index   int
+	svc     *service
+	key     string
+	created int64
+}
+
+// claimantsByAge sorts claimants by creation time of their source, oldest
+// first, then by source and backend name.
+type claimantsByAge []claimant
+
+func (c claimantsByAge) Len() int {
+	return len(c)
+}
+
+func (c claimantsByAge) Swap(i, j int) {
+	c[i], c[j] = c[j], c[i]
+}
+
+func (c claimantsByAge) Less(i, j int) bool {
+	if c[i].created != c[j].created {
+		return c[i].created < c[j].created
+	}
+	if c[i].key != c[j].key {
+		return c[i].key < c[j].key
+	}
+	return c[i].svc.Name < c[j].svc.Name
+}
+
+// resolveConflicts removes the backends claiming a backend name, host, acl,
+// host/path rule or tcp port already claimed by a different Service or
+// Ingress, and records an event on their source. The oldest object wins, so
+// the routing of existing backends doesn't change when a conflicting one is
+// created. Backends created for the same object never conflict.
+func (lbc *loadBalancerController) resolveConflicts(services map[string][]service) map[string][]service {
+	claimants := []claimant{}
+	for kind, svcs := range services {
+		for i := range svcs {
+			c := claimant{kind: kind, index: i, svc: &svcs[i], key: sourceKey(&svcs[i])}
+			if svcs[i].source != nil {
+				if meta, err := api.ObjectMetaFor(svcs[i].source); err == nil {
+					c.created = meta.CreationTimestamp.UnixNano()
+				}
+			}
+			claimants = append(claimants, c)
+		}
+	}
+	sort.Sort(claimantsByAge(claimants))
+
+	owners := map[string]claimant{}
+	rejected := sets.NewString()
+	for _, c := range claimants {
+		claims := routeClaims(c.kind, c.svc)
+		conflict := ""
+		for _, claim := range claims {
+			if owner, ok := owners[claim]; ok && owner.key != c.key {
+				conflict = fmt.Sprintf("%v is already used by %v", claim, owner.key)
+				break
+			}
+		}
+		if conflict != "" {
+			glog.Warningf("Ignoring backend %v of %v: %v", c.svc.Name, c.key, conflict)
+			if lbc.recorder != nil && c.svc.source != nil {
+				lbc.recorder.Eventf(c.svc.source, api.EventTypeWarning, reasonConflict,
+					"Backend %v not exposed: %v", c.svc.Name, conflict)
+			}
+			rejected.Insert(fmt.Sprintf("%v/%v", c.kind, c.index))
+			continue
+		}
+		for _, claim := range claims {
+			if _, ok := owners[claim]; !ok {
+				owners[claim] = c
+			}
+		}
+	}
+
+	result := make(map[string][]service, len(services))
+	for kind, svcs := range services {
+		kept := []service{}
+		for i, svc := range svcs {
+			if !rejected.Has(fmt.Sprintf("%v/%v", kind, i)) {
+				kept = append(kept, svc)
+			}
+		}
+		result[kind] = kept
+	}
+	return result
+}
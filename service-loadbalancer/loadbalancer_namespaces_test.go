@@ -0,0 +1,177 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util/intstr"
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+func newNamespacedService(name, namespace string, created time.Time, annotations map[string]string) *api.Service {
+	s := getService([]api.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}})
+	s.ObjectMeta.Name = name
+	s.ObjectMeta.Namespace = namespace
+	s.ObjectMeta.CreationTimestamp = unversioned.NewTime(created)
+	s.ObjectMeta.Annotations = annotations
+	return s
+}
+
+func buildTestNamespacedLoadBalancer(services ...*api.Service) *loadBalancerController {
+	endpoints := []*api.Endpoints{}
+	for _, s := range services {
+		endpoints = append(endpoints, getEndpoints(s,
+			[]api.EndpointAddress{{IP: "1.2.3.4"}},
+			[]api.EndpointPort{{Port: 8080, Protocol: "TCP"}}))
+	}
+	flb := newFakeLoadBalancerController(endpoints, services)
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+	flb.cfg = parseCfg(cfg, "roundrobin", "", "")
+	return flb
+}
+
+func TestNamespacedServices(t *testing.T) {
+	now := time.Now()
+	flb := buildTestNamespacedLoadBalancer(
+		newNamespacedService("foo", api.NamespaceDefault, now, nil),
+		newNamespacedService("foo", "team-a", now, nil),
+		newNamespacedService("foo", "team-b", now, nil),
+	)
+	flb.namespacedNames = true
+
+	http, _, _ := flb.getServices()
+	names := []string{}
+	for _, svc := range http {
+		names = append(names, svc.Name)
+	}
+	if strings.Join(names, ",") != "foo,foo.team-a,foo.team-b" {
+		t.Fatalf("Expected namespaced backend names, got %v", names)
+	}
+
+	flb.namespaces = sets.NewString("team-a", "team-b")
+	flb.nsSelector, _ = labels.Parse("loadbalancer=public")
+	flb.nsLister = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	flb.nsLister.Add(&api.Namespace{ObjectMeta: api.ObjectMeta{Name: "team-a",
+		Labels: map[string]string{"loadbalancer": "public"}}})
+	flb.nsLister.Add(&api.Namespace{ObjectMeta: api.ObjectMeta{Name: "team-b"}})
+	http, _, _ = flb.getServices()
+	if len(http) != 1 || http[0].Name != "foo.team-a" {
+		t.Fatalf("Expected only the services of the selected namespaces, got %+v", http)
+	}
+}
+
+func TestUnsuffixedNamespacedServices(t *testing.T) {
+	now := time.Now()
+	flb := buildTestNamespacedLoadBalancer(
+		newNamespacedService("foo", "team-a", now.Add(-time.Hour), nil),
+		newNamespacedService("foo", "team-b", now, nil),
+		newNamespacedService("bar", "team-b", now, nil),
+	)
+	recorder := &fakeRecorder{}
+	flb.recorder = recorder
+
+	http, _, _ := flb.getServices()
+	services := flb.resolveConflicts(map[string][]service{"http": http})
+	names := sets.NewString()
+	for i := range services["http"] {
+		names.Insert(sourceKey(&services["http"][i]))
+	}
+	// without --namespaced-names the names don't change on upgrade, and the
+	// oldest of two services with the same name keeps the backend.
+	if !names.Equal(sets.NewString("Service/team-a/foo", "Service/team-b/bar")) {
+		t.Fatalf("Expected unsuffixed backend names, got %v", names.List())
+	}
+	if len(recorder.events) != 1 || !strings.Contains(recorder.events[0], "backend foo is already used by Service/team-a/foo") {
+		t.Fatalf("Expected a conflict event for team-b/foo, got %v", recorder.events)
+	}
+}
+
+func TestLookupServicePort(t *testing.T) {
+	ports := parseServicePorts("mysql:3306,team-a/mysql:3307", api.ProtocolTCP)
+	testCases := []struct {
+		namespace string
+		expected  int
+	}{
+		{api.NamespaceDefault, 3306},
+		{"team-a", 3307},
+		{"team-b", 3306},
+	}
+	for _, tc := range testCases {
+		s := newNamespacedService("mysql", tc.namespace, time.Now(), nil)
+		if port, ok := lookupServicePort(ports, s); !ok || port != tc.expected {
+			t.Errorf("Expected port %v for %v/mysql, got %v", tc.expected, tc.namespace, port)
+		}
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	now := time.Now()
+	host := map[string]string{lbHostKey: "foo.bar"}
+	flb := buildTestNamespacedLoadBalancer(
+		newNamespacedService("old", "team-a", now.Add(-time.Hour), host),
+		newNamespacedService("new", "team-b", now, host),
+		newNamespacedService("other", "team-b", now, nil),
+		// its name collides with other in team-b
+		newNamespacedService("other.team-b", api.NamespaceDefault, now.Add(time.Hour), nil),
+	)
+	multiPort := newNamespacedService("multi", api.NamespaceDefault, now, host)
+	recorder := &fakeRecorder{}
+	flb.recorder = recorder
+	flb.namespacedNames = true
+
+	http, _, _ := flb.getServices()
+	http = append(http,
+		service{Name: "multi", Host: "bar.baz", source: multiPort},
+		service{Name: "multi:81", Host: "bar.baz", source: multiPort})
+	services := flb.resolveConflicts(map[string][]service{"http": http})
+
+	names := sets.NewString()
+	for _, svc := range services["http"] {
+		names.Insert(svc.Name)
+	}
+	expected := sets.NewString("old.team-a", "other.team-b", "multi", "multi:81")
+	if !names.Equal(expected) {
+		t.Fatalf("Expected backends %v, got %v", expected.List(), names.List())
+	}
+	if len(recorder.events) != 2 {
+		t.Fatalf("Expected an event for every rejected backend, got %v", recorder.events)
+	}
+	for _, event := range recorder.events {
+		if !strings.Contains(event, reasonConflict) ||
+			!(strings.Contains(event, "http host foo.bar is already used by Service/team-a/old") ||
+				strings.Contains(event, "backend other.team-b is already used by Service/team-b/other")) {
+			t.Errorf("Unexpected event %q", event)
+		}
+	}
+
+	tcp := []service{
+		{Name: "mysql", FrontendPort: 3306, source: newNamespacedService("mysql", "team-a", now, nil)},
+		{Name: "mysql-2", FrontendPort: 3306, source: newNamespacedService("mysql-2", "team-a", now, nil)},
+	}
+	services = flb.resolveConflicts(map[string][]service{"tcp": tcp})
+	if len(services["tcp"]) != 1 || services["tcp"][0].Name != "mysql" {
+		t.Fatalf("Expected a single tcp service on port 3306, got %+v", services["tcp"])
+	}
+}
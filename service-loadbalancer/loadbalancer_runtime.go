@@ -0,0 +1,325 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+const (
+	// haproxyStatsSocket is the admin socket configured in template.cfg.
+	haproxyStatsSocket = "/tmp/haproxy"
+	// statsSocketTimeout bounds a single command sent to the stats socket.
+	statsSocketTimeout = 5 * time.Second
+	// freeSlotAddr is the address rendered for server slots without an endpoint.
+	freeSlotAddr = "127.0.0.1:1"
+)
+
+// backendServer is a single server entry of a backend in the load balancer config.
+type backendServer struct {
+	Name string
+	Addr string
+
+	// Disabled is set for server slots that don't have an endpoint assigned.
+	Disabled bool
+
+	// Weight is the haproxy weight of the server in weighted backends.
+	Weight int
+}
+
+// endpointServers returns one server per endpoint, named after the endpoint.
+func endpointServers(ep []string) []backendServer {
+	servers := make([]backendServer, 0, len(ep))
+	for _, e := range ep {
+		servers = append(servers, backendServer{Name: e, Addr: e})
+	}
+	return servers
+}
+
+// runtimeUpdater applies endpoint changes to a running haproxy through its
+// stats socket. Every backend is rendered with a number of server slots, so
+// endpoints can be swapped in and out of the slots without a reload.
+type runtimeUpdater struct {
+	socket string
+
+	// slots is the minimum number of server slots of a backend. Backends with
+	// more endpoints grow in multiples of it, which requires a reload.
+	slots int
+
+	// backends maps a backend name to the endpoint assigned to each of its
+	// slots. An empty string marks a free slot.
+	backends map[string][]string
+
+	// loaded is the skeleton of the config currently loaded by haproxy, nil
+	// if unknown.
+	loaded map[string][]service
+
+	// live maps a backend name to the endpoints haproxy uses for its slots.
+	live map[string][]string
+
+	// weights maps a weighted backend name to the weights haproxy uses for
+	// its slots.
+	weights map[string][]int
+}
+
+// newRuntimeUpdater returns a runtimeUpdater that talks to the stats socket
+// at path and allocates at least slots servers per backend.
+func newRuntimeUpdater(path string, slots int) *runtimeUpdater {
+	return &runtimeUpdater{
+		socket:   path,
+		slots:    slots,
+		backends: map[string][]string{},
+		live:     map[string][]string{},
+		weights:  map[string][]int{},
+	}
+}
+
+// assign distributes the endpoints of every service over its server slots.
+// Endpoints keep the slot they were assigned to by previous calls.
+func (r *runtimeUpdater) assign(services map[string][]service) {
+	seen := sets.NewString()
+	for _, svcs := range services {
+		for i := range svcs {
+			svc := &svcs[i]
+			// haproxy resolves the servers of external services by itself.
+			if svc.ExternalName != "" {
+				continue
+			}
+			seen.Insert(svc.Name)
+			slots := r.assignSlots(r.backends[svc.Name], svc.Ep)
+			r.backends[svc.Name] = slots
+
+			svc.Servers = make([]backendServer, 0, len(slots))
+			for j, ep := range slots {
+				srv := backendServer{Name: fmt.Sprintf("slot%v", j), Addr: ep, Weight: svc.Weights[ep]}
+				if ep == "" {
+					srv.Addr = freeSlotAddr
+					srv.Disabled = true
+				}
+				svc.Servers = append(svc.Servers, srv)
+			}
+		}
+	}
+	for name := range r.backends {
+		if !seen.Has(name) {
+			delete(r.backends, name)
+		}
+	}
+}
+
+// assignSlots returns the slots of a backend holding ep, reusing the
+// positions of the endpoints already present in current.
+func (r *runtimeUpdater) assignSlots(current []string, ep []string) []string {
+	pending := sets.NewString(ep...)
+	slots := make([]string, len(current))
+	for i, addr := range current {
+		if pending.Has(addr) {
+			slots[i] = addr
+			pending.Delete(addr)
+		}
+	}
+
+	next := 0
+	for _, addr := range ep {
+		if !pending.Has(addr) {
+			continue
+		}
+		pending.Delete(addr)
+		for next < len(slots) && slots[next] != "" {
+			next++
+		}
+		if next == len(slots) {
+			slots = append(slots, addr)
+		} else {
+			slots[next] = addr
+		}
+	}
+
+	size := r.slots
+	for size < len(slots) {
+		size += r.slots
+	}
+	for len(slots) < size {
+		slots = append(slots, "")
+	}
+	return slots
+}
+
+// skeleton returns a copy of services without their endpoints. Two configs
+// with the same skeleton only differ in the addresses and weights of their
+// server slots.
+func skeleton(services map[string][]service) map[string][]service {
+	s := make(map[string][]service, len(services))
+	for key, svcs := range services {
+		copies := make([]service, 0, len(svcs))
+		for _, svc := range svcs {
+			servers := make([]backendServer, 0, len(svc.Servers))
+			for _, srv := range svc.Servers {
+				servers = append(servers, backendServer{Name: srv.Name})
+			}
+			svc.Ep = nil
+			if svc.Weights != nil {
+				// only whether the servers have a weight matters
+				svc.Weights = map[string]int{}
+			}
+			svc.Servers = servers
+			copies = append(copies, svc)
+		}
+		s[key] = copies
+	}
+	return s
+}
+
+// slotWeights returns the weight of each slot.
+func slotWeights(servers []backendServer) []int {
+	weights := make([]int, 0, len(servers))
+	for _, srv := range servers {
+		weights = append(weights, srv.Weight)
+	}
+	return weights
+}
+
+// slotAddrs returns the endpoint used by each slot, empty for disabled slots.
+func slotAddrs(servers []backendServer) []string {
+	addrs := make([]string, 0, len(servers))
+	for _, srv := range servers {
+		if srv.Disabled {
+			addrs = append(addrs, "")
+		} else {
+			addrs = append(addrs, srv.Addr)
+		}
+	}
+	return addrs
+}
+
+// canUpdate returns true if services can be applied without a reload, i.e. the
+// set of backends and frontends and the number of server slots didn't change.
+func (r *runtimeUpdater) canUpdate(services map[string][]service) bool {
+	return r.loaded != nil && reflect.DeepEqual(r.loaded, skeleton(services))
+}
+
+// setLoaded records services as the config loaded by haproxy.
+func (r *runtimeUpdater) setLoaded(services map[string][]service) {
+	r.loaded = skeleton(services)
+	r.live = map[string][]string{}
+	r.weights = map[string][]int{}
+	for _, svcs := range services {
+		for _, svc := range svcs {
+			r.live[svc.Name] = slotAddrs(svc.Servers)
+			if len(svc.Weights) > 0 {
+				r.weights[svc.Name] = slotWeights(svc.Servers)
+			}
+		}
+	}
+}
+
+// update applies the endpoints of services, and the weights of the servers of
+// weighted backends, to the running haproxy. Callers must check canUpdate
+// first.
+func (r *runtimeUpdater) update(services map[string][]service) error {
+	for _, svcs := range services {
+		for _, svc := range svcs {
+			if len(svc.Weights) > 0 {
+				if err := r.updateWeights(svc); err != nil {
+					return err
+				}
+			}
+			live := r.live[svc.Name]
+			addrs := slotAddrs(svc.Servers)
+			for j, addr := range addrs {
+				if j < len(live) && live[j] == addr {
+					continue
+				}
+				if err := r.setServer(svc.Name, svc.Servers[j].Name, addr); err != nil {
+					return err
+				}
+			}
+			r.live[svc.Name] = addrs
+		}
+	}
+	return nil
+}
+
+// updateWeights sets the weight of the enabled slots of svc whose weight
+// changed, before their address is updated so that a new endpoint doesn't
+// receive traffic with the weight of the previous one.
+func (r *runtimeUpdater) updateWeights(svc service) error {
+	live := r.weights[svc.Name]
+	weights := slotWeights(svc.Servers)
+	for j, srv := range svc.Servers {
+		if srv.Disabled || (j < len(live) && live[j] == weights[j]) {
+			continue
+		}
+		id := fmt.Sprintf("%v/%v", svc.Name, srv.Name)
+		glog.Infof("Setting the weight of server %v to %v", id, weights[j])
+		if err := r.command(fmt.Sprintf("set weight %v %v", id, weights[j])); err != nil {
+			return err
+		}
+	}
+	r.weights[svc.Name] = weights
+	return nil
+}
+
+// setServer points a server slot to addr, or disables it if addr is empty.
+func (r *runtimeUpdater) setServer(backend, server, addr string) error {
+	id := fmt.Sprintf("%v/%v", backend, server)
+	if addr == "" {
+		glog.Infof("Disabling server %v", id)
+		return r.command(fmt.Sprintf("disable server %v", id))
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	glog.Infof("Setting server %v to %v", id, addr)
+	if err := r.command(fmt.Sprintf("set server %v addr %v port %v", id, host, port)); err != nil {
+		return err
+	}
+	return r.command(fmt.Sprintf("enable server %v", id))
+}
+
+// command sends cmd to the stats socket. haproxy answers with an empty response
+// or a report of the changed address on success, anything else is an error.
+func (r *runtimeUpdater) command(cmd string) error {
+	conn, err := net.DialTimeout("unix", r.socket, statsSocketTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(statsSocketTimeout))
+
+	if _, err := fmt.Fprintf(conn, "%v\n", cmd); err != nil {
+		return err
+	}
+	response, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	msg := strings.TrimSpace(string(response))
+	if msg != "" && !strings.Contains(msg, "changed from") && !strings.HasPrefix(msg, "no need to change") {
+		return fmt.Errorf("%v: %v", cmd, msg)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeStatsSocket is a stand-in for the haproxy stats socket. It records the
+// commands it receives and only knows about the servers in its servers set.
+type fakeStatsSocket struct {
+	path     string
+	listener net.Listener
+	servers  map[string]bool
+
+	lock     sync.Mutex
+	commands []string
+}
+
+func newFakeStatsSocket(t *testing.T, servers ...string) *fakeStatsSocket {
+	dir, err := ioutil.TempDir("", "stats-socket")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v", err)
+	}
+	f := &fakeStatsSocket{path: filepath.Join(dir, "haproxy"), servers: map[string]bool{}}
+	for _, s := range servers {
+		f.servers[s] = true
+	}
+	f.listener, err = net.Listen("unix", f.path)
+	if err != nil {
+		t.Fatalf("Unexpected error listening on %v: %v", f.path, err)
+	}
+	go f.serve()
+	return f
+}
+
+func (f *fakeStatsSocket) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		cmd, _ := bufio.NewReader(conn).ReadString('\n')
+		cmd = strings.TrimSpace(cmd)
+		f.lock.Lock()
+		f.commands = append(f.commands, cmd)
+		f.lock.Unlock()
+
+		fields := strings.Fields(cmd)
+		switch {
+		case len(fields) < 3:
+			fmt.Fprintf(conn, "Unknown command.\n")
+		case !f.servers[fields[2]]:
+			fmt.Fprintf(conn, "No such server.\n")
+		case fields[0] == "set" && fields[1] == "weight":
+		case fields[0] == "set":
+			fmt.Fprintf(conn, "IP changed from '127.0.0.1' to '%v', port changed from '1' to '%v' by 'stats socket command'\n", fields[4], fields[6])
+		}
+		conn.Close()
+	}
+}
+
+func (f *fakeStatsSocket) close() {
+	f.listener.Close()
+	os.RemoveAll(filepath.Dir(f.path))
+}
+
+func (f *fakeStatsSocket) received() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.commands
+}
+
+func TestAssignSlots(t *testing.T) {
+	r := newRuntimeUpdater("", 4)
+	testCases := []struct {
+		current  []string
+		ep       []string
+		expected []string
+	}{
+		{nil, []string{"1.2.3.4:80"}, []string{"1.2.3.4:80", "", "", ""}},
+		{[]string{"1.2.3.4:80", "5.6.7.8:80", "", ""}, []string{"5.6.7.8:80", "9.9.9.9:80"}, []string{"9.9.9.9:80", "5.6.7.8:80", "", ""}},
+		{[]string{"a", "b", "c", "d"}, []string{"e", "d", "c", "b", "a"}, []string{"a", "b", "c", "d", "e", "", "", ""}},
+		{[]string{"a", "b", "c", "d", "e", "", "", ""}, []string{"a"}, []string{"a", "", "", "", "", "", "", ""}},
+	}
+	for i, tc := range testCases {
+		slots := r.assignSlots(tc.current, tc.ep)
+		if !reflect.DeepEqual(slots, tc.expected) {
+			t.Errorf("%v: expected slots %v, got %v", i, tc.expected, slots)
+		}
+	}
+}
+
+func TestRuntimeUpdate(t *testing.T) {
+	fake := newFakeStatsSocket(t, "svc/slot0", "svc/slot1")
+	defer fake.close()
+	r := newRuntimeUpdater(fake.path, 2)
+
+	services := map[string][]service{
+		"http": {{Name: "svc", Ep: []string{"1.2.3.4:80"}, FrontendPort: 80}},
+	}
+	r.assign(services)
+	if r.canUpdate(services) {
+		t.Fatalf("Expected a reload before haproxy loaded any config")
+	}
+	r.setLoaded(services)
+
+	services = map[string][]service{
+		"http": {{Name: "svc", Ep: []string{"5.6.7.8:8080"}, FrontendPort: 80}},
+	}
+	r.assign(services)
+	if !r.canUpdate(services) {
+		t.Fatalf("Expected endpoint changes to be applied without a reload")
+	}
+	if err := r.update(services); err != nil {
+		t.Fatalf("Unexpected error updating endpoints: %v", err)
+	}
+	expected := []string{
+		"set server svc/slot0 addr 5.6.7.8 port 8080",
+		"enable server svc/slot0",
+	}
+	if received := fake.received(); !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Expected commands %v, got %v", expected, received)
+	}
+
+	services = map[string][]service{
+		"http": {{Name: "svc", Ep: []string{"1.1.1.1:80"}, FrontendPort: 80}},
+	}
+	r.assign(services)
+	if err := r.update(services); err != nil {
+		t.Fatalf("Unexpected error updating endpoints: %v", err)
+	}
+	expected = append(expected,
+		"set server svc/slot0 addr 1.1.1.1 port 80",
+		"enable server svc/slot0",
+	)
+	if received := fake.received(); !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Expected commands %v, got %v", expected, received)
+	}
+
+	services = map[string][]service{
+		"http": {{Name: "svc", Ep: []string{}, FrontendPort: 80}},
+	}
+	r.assign(services)
+	if err := r.update(services); err != nil {
+		t.Fatalf("Unexpected error updating endpoints: %v", err)
+	}
+	expected = append(expected, "disable server svc/slot0")
+	if received := fake.received(); !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Expected commands %v, got %v", expected, received)
+	}
+
+	services = map[string][]service{
+		"http": {{Name: "svc", Ep: []string{"1.1.1.1:80", "2.2.2.2:80", "5.6.7.8:8080"}, FrontendPort: 80}},
+	}
+	r.assign(services)
+	if r.canUpdate(services) {
+		t.Fatalf("Expected a reload when the number of slots changes")
+	}
+
+	services = map[string][]service{
+		"http": {{Name: "svc", Ep: []string{"5.6.7.8:8080"}, FrontendPort: 80, Host: "foo.bar"}},
+	}
+	r.assign(services)
+	if r.canUpdate(services) {
+		t.Fatalf("Expected a reload when the frontend changes")
+	}
+}
+
+func TestRuntimeUpdateWeights(t *testing.T) {
+	fake := newFakeStatsSocket(t, "app/slot0", "app/slot1")
+	defer fake.close()
+	r := newRuntimeUpdater(fake.path, 2)
+
+	services := map[string][]service{
+		"http": {{Name: "app", Ep: []string{"1.1.1.1:80", "2.2.2.2:80"}, FrontendPort: 80,
+			Weights: map[string]int{"1.1.1.1:80": 256, "2.2.2.2:80": 256}}},
+	}
+	r.assign(services)
+	r.setLoaded(services)
+
+	// scaling a member of a group changes the weights of the others
+	services = map[string][]service{
+		"http": {{Name: "app", Ep: []string{"1.1.1.1:80", "3.3.3.3:80"}, FrontendPort: 80,
+			Weights: map[string]int{"1.1.1.1:80": 128, "3.3.3.3:80": 256}}},
+	}
+	r.assign(services)
+	if !r.canUpdate(services) {
+		t.Fatalf("Expected weight changes to be applied without a reload")
+	}
+	if err := r.update(services); err != nil {
+		t.Fatalf("Unexpected error updating endpoints: %v", err)
+	}
+	expected := []string{
+		"set weight app/slot0 128",
+		"set server app/slot1 addr 3.3.3.3 port 80",
+		"enable server app/slot1",
+	}
+	if received := fake.received(); !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Expected commands %v, got %v", expected, received)
+	}
+
+	services["http"][0].Weights = nil
+	r.assign(services)
+	if r.canUpdate(services) {
+		t.Fatalf("Expected a reload when a backend stops being weighted")
+	}
+}
+
+func TestRuntimeUpdateUnknownServer(t *testing.T) {
+	fake := newFakeStatsSocket(t)
+	defer fake.close()
+	r := newRuntimeUpdater(fake.path, 1)
+
+	services := map[string][]service{
+		"tcp": {{Name: "mysql:3306", Ep: []string{"1.2.3.4:3306"}, FrontendPort: 3306}},
+	}
+	r.assign(services)
+	r.setLoaded(services)
+	services["tcp"][0].Ep = []string{"5.6.7.8:3306"}
+	r.assign(services)
+	if err := r.update(services); err == nil {
+		t.Fatalf("Expected an error for a server unknown to haproxy")
+	}
+}
+
+func TestServerSlots(t *testing.T) {
+	flb := buildTestLoadBalancer("")
+	flb.cfg.runtimeAPI = true
+	flb.runtime = newRuntimeUpdater(haproxyStatsSocket, 3)
+	httpSvc, _, tcpSvc := flb.getServices()
+	services := map[string][]service{
+		"http": httpSvc,
+		"tcp":  tcpSvc,
+	}
+	flb.runtime.assign(services)
+	var buf bytes.Buffer
+	if err := flb.cfg.render(services, &buf); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	cfg := buf.String()
+	for _, line := range []string{
+		"stats socket /tmp/haproxy level admin",
+		"server slot0 1.2.3.4:80 check port 80 inter 5",
+		"server slot1 5.6.7.8:80 check port 80 inter 5",
+		"server slot2 127.0.0.1:1 disabled check port 80 inter 5",
+	} {
+		if !strings.Contains(cfg, line) {
+			t.Errorf("Expected %q in the rendered config", line)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/util/sets"
+)
+
+const (
+	// certsSubdir is the subdirectory of the cert dir holding the files
+	// written by the controller. Any other file in it is removed.
+	certsSubdir = "secrets"
+	// crtListFile is the name of the haproxy crt-list written to the certs subdir.
+	crtListFile = "crt-list"
+	// pemSuffix is the suffix of the certificate bundles written to the certs subdir.
+	pemSuffix = ".pem"
+	// reasonInvalidCertificate is the reason of the events recorded for the
+	// services whose TLS secret was rejected by the load balancer.
+	reasonInvalidCertificate = "InvalidCertificate"
+)
+
+// isTLSSecret returns true if obj is a secret holding a certificate and key.
+func isTLSSecret(obj interface{}) bool {
+	secret, ok := obj.(*api.Secret)
+	if !ok {
+		return false
+	}
+	_, hasCert := secret.Data[api.TLSCertKey]
+	_, hasKey := secret.Data[api.TLSPrivateKeyKey]
+	return hasCert && hasKey
+}
+
+// getPemName returns the file name of the certificate bundle for a
+// namespace/name secret key.
+func getPemName(secret string) string {
+	return strings.Replace(secret, "/", "_", -1) + pemSuffix
+}
+
+// crtListEntry selects the certificate bundle pem for the SNI host, or for any
+// host if empty.
+type crtListEntry struct {
+	pem  string
+	host string
+}
+
+// renderCrtList returns the content of a crt-list with the entries, the
+// bundles being in dir.
+func renderCrtList(dir string, entries []crtListEntry) []byte {
+	lines := sets.NewString()
+	for _, entry := range entries {
+		line := filepath.Join(dir, entry.pem)
+		if entry.host != "" {
+			line += " " + entry.host
+		}
+		lines.Insert(line)
+	}
+	return []byte(strings.Join(lines.List(), "\n") + "\n")
+}
+
+// writeCerts writes a PEM bundle for every secret referenced by the httpsTerm
+// services to the certs subdir of the cert dir, along with a crt-list
+// selecting them by SNI, and removes the bundles no longer referenced. Changed
+// certificates are validated along with the configuration of services before
+// being written, so that a broken secret doesn't reach the load balancer: a
+// rejected bundle is left out, or keeps its previous version, without
+// affecting the others. It returns true if any file was changed.
+func (lbc *loadBalancerController) writeCerts(services map[string][]service) (bool, error) {
+	dir := filepath.Join(lbc.sslCertDir, certsSubdir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, err
+	}
+
+	pems := map[string][]byte{}
+	entries := []crtListEntry{}
+	for _, svc := range services["httpsTerm"] {
+		if svc.SslSecret == "" {
+			continue
+		}
+		obj, exists, err := lbc.secretLister.GetByKey(svc.SslSecret)
+		if err != nil || !exists || !isTLSSecret(obj) {
+			glog.Warningf("TLS secret %v of service %v not found, using the default certificate", svc.SslSecret, svc.Name)
+			continue
+		}
+		name := getPemName(svc.SslSecret)
+		if _, ok := pems[name]; !ok {
+			secret := obj.(*api.Secret)
+			var pem bytes.Buffer
+			pem.Write(secret.Data[api.TLSCertKey])
+			if !bytes.HasSuffix(secret.Data[api.TLSCertKey], []byte("\n")) {
+				pem.WriteString("\n")
+			}
+			pem.Write(secret.Data[api.TLSPrivateKeyKey])
+			pems[name] = pem.Bytes()
+		}
+		entries = append(entries, crtListEntry{pem: name, host: svc.Host})
+	}
+
+	if certsChanged(dir, pems, entries) {
+		err := lbc.validateCerts(services, pems, entries)
+		if _, ok := err.(*rejectedCertsError); ok {
+			pems, entries = lbc.dropRejectedCerts(services, dir, pems, entries)
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	changed := false
+	for name, pem := range pems {
+		written, err := writeFileIfChanged(filepath.Join(dir, name), pem)
+		if err != nil {
+			return false, err
+		}
+		if written {
+			glog.Infof("Updated certificate %v", filepath.Join(dir, name))
+			changed = true
+		}
+	}
+	crtList := filepath.Join(dir, crtListFile)
+	stale, err := staleCerts(dir, pems)
+	if err != nil {
+		return false, err
+	}
+	for _, file := range stale {
+		glog.Infof("Removing unused certificate %v", file)
+		if err := os.Remove(file); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	lbc.cfg.crtList = ""
+	if len(entries) == 0 {
+		if err := os.Remove(crtList); err == nil {
+			changed = true
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+		return changed, nil
+	}
+	written, err := writeFileIfChanged(crtList, renderCrtList(dir, entries))
+	if err != nil {
+		return false, err
+	}
+	lbc.cfg.crtList = crtList
+	return changed || written, nil
+}
+
+// staleCerts returns the files of the certs subdir dir, which are neither one
+// of the bundles nor the crt-list. Only the files of the certs subdir are
+// considered, the cert dir may hold other files like the default certificate.
+func staleCerts(dir string, pems map[string][]byte) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	stale := []string{}
+	for _, file := range files {
+		if _, ok := pems[filepath.Base(file)]; !ok && filepath.Base(file) != crtListFile {
+			stale = append(stale, file)
+		}
+	}
+	return stale, nil
+}
+
+// certsChanged returns true if writing the bundles and the crt-list of the
+// entries to dir would change any file.
+func certsChanged(dir string, pems map[string][]byte, entries []crtListEntry) bool {
+	if stale, err := staleCerts(dir, pems); err != nil || len(stale) > 0 {
+		return true
+	}
+	var crtListContent []byte
+	if len(entries) > 0 {
+		crtListContent = renderCrtList(dir, entries)
+	}
+	if !fileHasContent(filepath.Join(dir, crtListFile), crtListContent) {
+		return true
+	}
+	for name, pem := range pems {
+		if !fileHasContent(filepath.Join(dir, name), pem) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectedCertsError is returned by validateCerts when the configuration using
+// the staged bundles is invalid.
+type rejectedCertsError struct {
+	err error
+}
+
+func (e *rejectedCertsError) Error() string {
+	return fmt.Sprintf("rejected certificates: %v", e.err)
+}
+
+// validateCerts stages the certificate bundles and their crt-list in a
+// temporary directory, and validates the configuration of services using
+// them. It's a noop if no validate cmd was specified.
+func (lbc *loadBalancerController) validateCerts(services map[string][]service, pems map[string][]byte, entries []crtListEntry) error {
+	if lbc.cfg.ValidateCmd == "" {
+		return nil
+	}
+	staging, err := ioutil.TempDir(lbc.sslCertDir, ".staging")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	for name, pem := range pems {
+		if err := ioutil.WriteFile(filepath.Join(staging, name), pem, 0600); err != nil {
+			return err
+		}
+	}
+	cfg := *lbc.cfg
+	cfg.crtList = ""
+	if len(entries) > 0 {
+		cfg.crtList = filepath.Join(staging, crtListFile)
+		if err := ioutil.WriteFile(cfg.crtList, renderCrtList(staging, entries), 0600); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.render(services, &buf); err != nil {
+		return err
+	}
+	path := filepath.Join(staging, filepath.Base(cfg.Config))
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := cfg.validate(path); err != nil {
+		return &rejectedCertsError{err}
+	}
+	return nil
+}
+
+// dropRejectedCerts is called once the bundles were rejected together. It
+// validates every changed bundle along with the unchanged ones, and returns
+// the bundles and crt-list entries to write: a rejected bundle keeps its
+// current version if it has one, otherwise it's left out along with its
+// entries. A warning event is recorded on the services using it.
+func (lbc *loadBalancerController) dropRejectedCerts(services map[string][]service, dir string, pems map[string][]byte, entries []crtListEntry) (map[string][]byte, []crtListEntry) {
+	valid := map[string][]byte{}
+	changed := []string{}
+	for name, pem := range pems {
+		if fileHasContent(filepath.Join(dir, name), pem) {
+			valid[name] = pem
+		} else {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	rejected := map[string]error{}
+	for _, name := range changed {
+		candidate := map[string][]byte{name: pems[name]}
+		for n, pem := range valid {
+			candidate[n] = pem
+		}
+		if err := lbc.validateCerts(services, candidate, filterCrtList(entries, candidate)); err != nil {
+			rejected[name] = err
+			continue
+		}
+		valid[name] = pems[name]
+	}
+
+	for name, err := range rejected {
+		current, readErr := ioutil.ReadFile(filepath.Join(dir, name))
+		keep := readErr == nil
+		if keep {
+			valid[name] = current
+		}
+		glog.Warningf("Certificate %v %v", name, err)
+		for _, svc := range services["httpsTerm"] {
+			if svc.SslSecret == "" || getPemName(svc.SslSecret) != name || lbc.recorder == nil || svc.source == nil {
+				continue
+			}
+			if keep {
+				lbc.recorder.Eventf(svc.source, api.EventTypeWarning, reasonInvalidCertificate,
+					"TLS secret %v was rejected, keeping its previous version: %v", svc.SslSecret, err)
+			} else {
+				lbc.recorder.Eventf(svc.source, api.EventTypeWarning, reasonInvalidCertificate,
+					"TLS secret %v was rejected, using the default certificate: %v", svc.SslSecret, err)
+			}
+		}
+	}
+	return valid, filterCrtList(entries, valid)
+}
+
+// filterCrtList returns the entries whose bundle is in pems.
+func filterCrtList(entries []crtListEntry, pems map[string][]byte) []crtListEntry {
+	filtered := []crtListEntry{}
+	for _, entry := range entries {
+		if _, ok := pems[entry.pem]; ok {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// fileHasContent returns true if the file at path has the given content, or
+// doesn't exist and content is nil.
+func fileHasContent(path string, content []byte) bool {
+	current, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return content == nil
+	}
+	return err == nil && content != nil && bytes.Equal(current, content)
+}
+
+// writeFileIfChanged atomically replaces the file at path with data, unless it
+// already has that content. It returns true if the file was written.
+func writeFileIfChanged(path string, data []byte) (bool, error) {
+	if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return false, err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+)
+
+func newTLSSecret(name, cert, key string) *api.Secret {
+	return &api.Secret{
+		ObjectMeta: api.ObjectMeta{Name: name, Namespace: api.NamespaceDefault},
+		Type:       api.SecretTypeTLS,
+		Data: map[string][]byte{
+			api.TLSCertKey:       []byte(cert),
+			api.TLSPrivateKeyKey: []byte(key),
+		},
+	}
+}
+
+func TestWriteCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	flb := buildTestLoadBalancer("")
+	flb.sslCertDir = dir
+	flb.secretLister = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	flb.secretLister.Add(newTLSSecret("foo-tls", "foo-cert", "foo-key\n"))
+	flb.secretLister.Add(newTLSSecret("bar-tls", "bar-cert\n", "bar-key\n"))
+
+	// A certificate of the user in the cert dir is left alone
+	userPem := filepath.Join(dir, "default.pem")
+	ioutil.WriteFile(userPem, []byte("user-cert"), 0600)
+
+	httpsTerm := []service{
+		{Name: "foo", Host: "foo.bar", SslTerm: true, SslSecret: "default/foo-tls"},
+		{Name: "foo-api", Host: "api.foo.bar", SslTerm: true, SslSecret: "default/foo-tls"},
+		{Name: "bar", SslTerm: true, SslSecret: "default/bar-tls"},
+		{Name: "missing", SslTerm: true, SslSecret: "default/missing"},
+		{Name: "default", SslTerm: true},
+	}
+	services := map[string][]service{"httpsTerm": httpsTerm}
+	changed, err := flb.writeCerts(services)
+	if err != nil {
+		t.Fatalf("Unexpected error writing certificates: %v", err)
+	}
+	if !changed {
+		t.Fatalf("Expected new certificates to be reported as a change")
+	}
+
+	certsDir := filepath.Join(dir, certsSubdir)
+	fooPem := filepath.Join(certsDir, "default_foo-tls.pem")
+	barPem := filepath.Join(certsDir, "default_bar-tls.pem")
+	pem, err := ioutil.ReadFile(fooPem)
+	if err != nil || !bytes.Equal(pem, []byte("foo-cert\nfoo-key\n")) {
+		t.Fatalf("Unexpected certificate bundle %q: %v", pem, err)
+	}
+	if flb.cfg.crtList != filepath.Join(certsDir, crtListFile) {
+		t.Fatalf("Expected the crt-list to be used, got %q", flb.cfg.crtList)
+	}
+	crtList, _ := ioutil.ReadFile(flb.cfg.crtList)
+	expected := strings.Join([]string{barPem, fooPem + " api.foo.bar", fooPem + " foo.bar"}, "\n") + "\n"
+	if string(crtList) != expected {
+		t.Fatalf("Expected crt-list %q, got %q", expected, crtList)
+	}
+
+	if changed, _ := flb.writeCerts(services); changed {
+		t.Fatalf("Expected unchanged secrets not to change the certificates")
+	}
+
+	flb.secretLister.Update(newTLSSecret("bar-tls", "new-bar-cert\n", "bar-key\n"))
+	if changed, _ := flb.writeCerts(services); !changed {
+		t.Fatalf("Expected a rotated secret to change the certificates")
+	}
+
+	changed, err = flb.writeCerts(map[string][]service{"httpsTerm": httpsTerm[4:]})
+	if err != nil || !changed {
+		t.Fatalf("Expected removed certificates to be reported as a change: %v", err)
+	}
+	if flb.cfg.crtList != "" {
+		t.Fatalf("Expected no crt-list without certificates, got %q", flb.cfg.crtList)
+	}
+	if files, _ := filepath.Glob(filepath.Join(certsDir, "*")); len(files) != 0 {
+		t.Fatalf("Expected unused certificates to be removed, found %v", files)
+	}
+	if _, err := os.Stat(userPem); err != nil {
+		t.Fatalf("Expected the certificate of the user to be kept: %v", err)
+	}
+}
+
+func TestWriteCertsValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	flb := buildTestLoadBalancer("")
+	flb.sslCertDir = dir
+	flb.secretLister = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	flb.secretLister.Add(newTLSSecret("foo-tls", "foo-cert\n", "foo-key\n"))
+	services := map[string][]service{
+		"httpsTerm": {{Name: "foo", Host: "foo.bar", SslTerm: true, SslSecret: "default/foo-tls", Algorithm: "roundrobin"}},
+	}
+
+	// The staged crt-list must be referenced by the validated config
+	flb.cfg.ValidateCmd = `sh -c 'grep -q "crt-list .*/.staging[0-9]*/crt-list" $0'`
+	if _, err := flb.writeCerts(services); err != nil {
+		t.Fatalf("Unexpected error writing valid certificates: %v", err)
+	}
+
+	// Only the rejected bundles are left out, the others are still updated
+	recorder := &fakeRecorder{}
+	flb.recorder = recorder
+	flb.secretLister.Update(newTLSSecret("foo-tls", "broken-cert\n", "foo-key\n"))
+	flb.secretLister.Add(newTLSSecret("bar-tls", "broken-cert\n", "bar-key\n"))
+	flb.secretLister.Add(newTLSSecret("baz-tls", "baz-cert\n", "baz-key\n"))
+	services["httpsTerm"] = append(services["httpsTerm"],
+		service{Name: "bar", Host: "bar.baz", SslTerm: true, SslSecret: "default/bar-tls", Algorithm: "roundrobin",
+			source: newNamespacedService("bar", api.NamespaceDefault, time.Now(), nil)},
+		service{Name: "baz", Host: "baz.bar", SslTerm: true, SslSecret: "default/baz-tls", Algorithm: "roundrobin"})
+	services["httpsTerm"][0].source = newNamespacedService("foo", api.NamespaceDefault, time.Now(), nil)
+	flb.cfg.ValidateCmd = `sh -c '! grep -rq broken $(dirname $0)'`
+	changed, err := flb.writeCerts(services)
+	if err != nil || !changed {
+		t.Fatalf("Expected the valid certificates to be written, got %v, %v", changed, err)
+	}
+	certsDir := filepath.Join(dir, certsSubdir)
+	if pem, _ := ioutil.ReadFile(filepath.Join(certsDir, "default_foo-tls.pem")); string(pem) != "foo-cert\nfoo-key\n" {
+		t.Errorf("Expected the previous version of a rejected certificate to be kept, got %q", pem)
+	}
+	if _, err := os.Stat(filepath.Join(certsDir, "default_bar-tls.pem")); !os.IsNotExist(err) {
+		t.Errorf("Expected a new rejected certificate not to be written: %v", err)
+	}
+	if pem, _ := ioutil.ReadFile(filepath.Join(certsDir, "default_baz-tls.pem")); string(pem) != "baz-cert\nbaz-key\n" {
+		t.Errorf("Expected the valid certificate to be written, got %q", pem)
+	}
+	crtList, _ := ioutil.ReadFile(flb.cfg.crtList)
+	if strings.Contains(string(crtList), "bar-tls") || !strings.Contains(string(crtList), "baz-tls") {
+		t.Errorf("Expected only the crt-list entry of the new rejected certificate to be removed, got %q", crtList)
+	}
+	if len(recorder.events) != 2 {
+		t.Errorf("Expected an event for each service with a rejected certificate, got %v", recorder.events)
+	}
+	for _, event := range recorder.events {
+		if !strings.HasPrefix(event, "Warning "+reasonInvalidCertificate) {
+			t.Errorf("Unexpected event %q", event)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, ".staging*")); len(files) != 0 {
+		t.Fatalf("Expected the staging dir to be removed, found %v", files)
+	}
+}
+
+func TestSslTermWithCrtList(t *testing.T) {
+	flb := buildTestLoadBalancer("")
+	flb.cfg.crtList = "/etc/haproxy/certs/crt-list"
+	var buf bytes.Buffer
+	services := map[string][]service{
+		"httpsTerm": {{Name: "foo", Host: "foo.bar", SslTerm: true, Algorithm: "roundrobin"}},
+	}
+	if err := flb.cfg.render(services, &buf); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	if !strings.Contains(buf.String(), "bind :443 ssl crt-list /etc/haproxy/certs/crt-list no-sslv3") {
+		t.Fatalf("Expected the https frontend to use the crt-list:\n%v", buf.String())
+	}
+}
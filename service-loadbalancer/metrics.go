@@ -0,0 +1,68 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// reloadSkipped is used when the rendered config matches the live one.
+	reloadSkipped = "skipped"
+	// reloadInvalid is used when the rendered config fails validation.
+	reloadInvalid = "invalid"
+	// reloadFailed is used when the reload command returns an error.
+	reloadFailed = "failed"
+	// reloadSuccess is used when the load balancer was reloaded.
+	reloadSuccess = "success"
+)
+
+var (
+	// reloadsCount tracks the outcome of every attempt to reload the load balancer.
+	reloadsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "servicelb",
+			Name:      "reloads_count",
+			Help:      "Number of load balancer reload attempts, partitioned by load balancer and result.",
+		},
+		[]string{"loadbalancer", "result"})
+
+	// requestsCount tracks the http requests logged by haproxy.
+	requestsCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "servicelb",
+			Name:      "http_requests_count",
+			Help:      "Number of http requests, partitioned by frontend, backend and status code.",
+		},
+		[]string{"frontend", "backend", "code"})
+
+	// responseLatency tracks the time backend servers take to send the response headers.
+	responseLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "servicelb",
+			Name:      "http_response_latency_seconds",
+			Help:      "Time waiting for the response headers of the backend servers, partitioned by backend.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		},
+		[]string{"backend"})
+)
+
+func init() {
+	prometheus.MustRegister(reloadsCount)
+	prometheus.MustRegister(requestsCount)
+	prometheus.MustRegister(responseLatency)
+}
@@ -17,13 +17,16 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
@@ -32,17 +35,23 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
 	flag "github.com/spf13/pflag"
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
 	"k8s.io/kubernetes/pkg/client/cache"
 	"k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/controller/framework"
 	"k8s.io/kubernetes/pkg/fields"
 	kubectl_util "k8s.io/kubernetes/pkg/kubectl/cmd/util"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
 	"k8s.io/kubernetes/pkg/util"
 	"k8s.io/kubernetes/pkg/util/intstr"
+	"k8s.io/kubernetes/pkg/util/sets"
 	"k8s.io/kubernetes/pkg/util/wait"
 	"k8s.io/kubernetes/pkg/util/workqueue"
+	"k8s.io/kubernetes/pkg/watch"
 )
 
 const (
@@ -52,8 +61,18 @@ const (
 	lbAlgorithmKey           = "serviceloadbalancer/lb.algorithm"
 	lbHostKey                = "serviceloadbalancer/lb.host"
 	lbSslTerm                = "serviceloadbalancer/lb.sslTerm"
+	lbSslSecret              = "serviceloadbalancer/lb.sslSecret"
 	lbAclMatch               = "serviceloadbalancer/lb.aclMatch"
 	lbCookieStickySessionKey = "serviceloadbalancer/lb.cookie-sticky-session"
+	lbHealthCheckKey         = "serviceloadbalancer/lb.healthCheck"
+	lbHealthCheckPathKey     = "serviceloadbalancer/lb.healthCheckPath"
+	lbHealthCheckIntervalKey = "serviceloadbalancer/lb.healthCheckInterval"
+	lbHealthCheckRiseKey     = "serviceloadbalancer/lb.healthCheckRise"
+	lbHealthCheckFallKey     = "serviceloadbalancer/lb.healthCheckFall"
+	lbMaxConnKey             = "serviceloadbalancer/lb.maxconn"
+	lbGroupKey               = "serviceloadbalancer/lb.group"
+	lbWeightKey              = "serviceloadbalancer/lb.weight"
+	lbExternalNameKey        = "serviceloadbalancer/lb.externalName"
 	defaultErrorPage         = "file:///etc/haproxy/errors/404.http"
 )
 
@@ -101,6 +120,11 @@ var (
                 serviceName:servicePort pairings. This assumes you've opened up the right
                 hostPorts for each service that serves ingress traffic.`)
 
+	udpServices = flags.String("udp-services", "", `Comma separated list of udp
+                serviceName:servicePort pairings, exposed through the udp load balancer
+                configured in the json config. This assumes you've opened up the right
+                hostPorts for each service.`)
+
 	targetService = flags.String(
 		"target-service", "", `Restrict loadbalancing to a single target service.`)
 
@@ -131,6 +155,14 @@ var (
 	sslCaCert = flags.String("ssl-ca-cert", "", `if set, it will load the certificate from which
 		to load CA certificates used to verify client's certificate.`)
 
+	dnsResolvers = flags.String("dns-resolvers", "", `Comma separated list of ip[:port] nameservers
+                haproxy uses to resolve the backends of external services. Defaults to the
+                nameservers of /etc/resolv.conf.`)
+
+	sslCertDir = flags.String("ssl-cert-dir", "", `if set, the TLS secrets referenced by services
+                and ingresses are written to this directory and selected by SNI, falling back
+                to the certificate of --ssl-cert.`)
+
 	errorPage = flags.String("error-page", "", `if set, it will try to load the content
                 as a web page and use the content as error page. Is required that the URL returns
                 200 as a status code`)
@@ -140,6 +172,27 @@ var (
 
 	lbDefAlgorithm = flags.String("balance-algorithm", "roundrobin", `if set, it allows a custom
                 default balance algorithm.`)
+
+	watchIngress = flags.Bool("ingress", false, `if set, the host and path rules of Ingress
+                resources are also exposed, next to the services selected through annotations.`)
+
+	namespaces = flags.String("namespaces", "", `Comma separated list of namespaces to watch.
+                If not set, the namespace of the kubeconfig context is used, or all namespaces
+                if the context doesn't have one.`)
+
+	namespaceSelector = flags.String("namespace-selector", "", `if set, only the services and
+                ingresses of the namespaces matching this label selector are exposed, eg:
+                loadbalancer=public.`)
+
+	namespacedNames = flags.Bool("namespaced-names", false, `if set, the backends of services
+                outside of the default namespace are suffixed with their namespace, eg:
+                /kube-ui.kube-system, so services with the same name in different namespaces
+                can be exposed side by side.`)
+
+	serverSlots = flags.Int("server-slots", 0, `if greater than 0, every backend is created with
+                at least this many server slots and endpoint changes are applied through the haproxy
+                stats socket instead of reloading. A reload only happens when the number of slots
+                or the set of frontends changes.`)
 )
 
 // service encapsulates a single backend entry in the load balancer config.
@@ -150,9 +203,47 @@ type service struct {
 	Name string
 	Ep   []string
 
+	// Servers are the haproxy servers of the backend. Without server slots
+	// there is one server per endpoint.
+	Servers []backendServer
+
+	// Group is the name of the backend shared by the services of a weighted
+	// group, empty if the service is not part of one.
+	Group string
+
+	// Weight is the share of the traffic of the group sent to this service,
+	// relative to the weights of the other services of the group.
+	Weight int
+
+	// Weights maps every endpoint of a grouped backend to the haproxy weight
+	// of its server, nil for backends that are not weighted.
+	Weights map[string]int
+
 	// Kubernetes endpoint port. The application must serve a 200 page on this port.
 	BackendPort int
 
+	// ExternalName is the dns name of the backend of services outside of the
+	// cluster. Its servers are resolved by haproxy at runtime.
+	ExternalName string
+
+	// HealthCheck if true, haproxy checks the servers of the backend on BackendPort,
+	// by opening a connection, or with a GET of HealthCheckPath if it is set.
+	HealthCheck     bool
+	HealthCheckPath string
+
+	// HealthCheckInterval is the time between two checks, in milliseconds.
+	HealthCheckInterval int
+
+	// HealthCheckRise and HealthCheckFall are the number of consecutive
+	// successful and failed checks needed to consider a server up or down.
+	// haproxy defaults are used if 0.
+	HealthCheckRise int
+	HealthCheckFall int
+
+	// MaxConn is the maximum number of concurrent connections to each server,
+	// unlimited if 0.
+	MaxConn int
+
 	// FrontendPort is the port that the loadbalancer listens on for traffic
 	// for this service. For http, it's always :80, for each tcp service it
 	// is the service port of any service matching a name in the tcpServices set.
@@ -168,6 +259,15 @@ type service struct {
 	// if set use this to match the path rule
 	AclMatch string
 
+	// Path if not empty, traffic is routed to this backend when the request
+	// path begins with Path and, if Host is set, the host header matches Host.
+	// The path is not rewritten. It is used for the rules of Ingress resources.
+	Path string
+
+	// SslSecret is the namespace/name of the secret holding the certificate
+	// used to terminate ssl, if it was specified by an Ingress.
+	SslSecret string
+
 	// Algorithm
 	Algorithm string
 
@@ -186,6 +286,9 @@ type service struct {
 	// The name of the cookie is SERVERID
 	// This only can be used in http services
 	CookieStickySession bool
+
+	// source is the Service or Ingress the backend was created for.
+	source runtime.Object
 }
 
 type serviceByName []service
@@ -196,22 +299,63 @@ func (s serviceByName) Len() int {
 func (s serviceByName) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
+
+// Less sorts services in the order haproxy needs to evaluate their acls:
+// Ingress rules first, longest path first, then services by name, and last
+// the Ingress rules that catch all traffic.
 func (s serviceByName) Less(i, j int) bool {
+	ri, rj := s[i].rank(), s[j].rank()
+	if ri != rj {
+		return ri < rj
+	}
+	if len(s[i].Path) != len(s[j].Path) {
+		return len(s[i].Path) > len(s[j].Path)
+	}
 	return s[i].Name < s[j].Name
 }
 
+// rank returns the position of the service's acls in a frontend.
+func (s *service) rank() int {
+	switch {
+	case s.Path == "":
+		return 1
+	case s.Host == "" && s.Path == "/":
+		return 2
+	}
+	return 0
+}
+
 // loadBalancerConfig represents loadbalancer specific configuration. Eventually
 // kubernetes will have an api for l7 loadbalancing.
 type loadBalancerConfig struct {
 	Name           string `json:"name" description:"Name of the load balancer, eg: haproxy."`
 	ReloadCmd      string `json:"reloadCmd" description:"command used to reload the load balancer."`
+	ValidateCmd    string `json:"validateCmd" description:"command used to validate a rendered config, the path of the config is appended."`
 	Config         string `json:"config" description:"path to loadbalancers configuration file."`
 	Template       string `json:"template" description:"template for the load balancer config."`
 	Algorithm      string `json:"algorithm" description:"loadbalancing algorithm."`
 	startSyslog    bool   `description:"indicates if the load balancer uses syslog."`
-	sslCert        string `json:"sslCert" description:"PEM for ssl."`
-	sslCaCert      string `json:"sslCaCert" description:"PEM to verify client's certificate."`
-	lbDefAlgorithm string `description:"custom default load balancer algorithm".`
+	runtimeAPI     bool   `description:"indicates if endpoints are updated through the stats socket."`
+	sslCert        string `description:"PEM for ssl."`
+	sslCaCert      string `description:"PEM to verify client's certificate."`
+	lbDefAlgorithm string `description:"custom default load balancer algorithm."`
+	reloadPending  bool   `description:"indicates if the config was written but not yet reloaded."`
+	crtList        string `description:"path to the crt-list of the TLS secrets used by services."`
+
+	nameservers []string `description:"ip:port of the nameservers used to resolve external services."`
+
+	UDP *loadBalancerConfig `json:"udp" description:"load balancer for udp services, which haproxy can't handle."`
+}
+
+// loadBalancer is a backend that services are exposed through. loadBalancerConfig
+// implements it by rendering a template and running a reload command, other
+// backends (eg: ipvs) can program the services directly.
+type loadBalancer interface {
+	// write configures the given services, keyed by the kind of frontend they
+	// are exposed through. It returns true if the configuration changed.
+	write(services map[string][]service, dryRun bool) (bool, error)
+	// reload applies the configuration of the last write.
+	reload() error
 }
 
 type staticPageHandler struct {
@@ -248,6 +392,56 @@ func (s serviceAnnotations) getAclMatch() (string, bool) {
 	return val, ok
 }
 
+func (s serviceAnnotations) getSslSecret() (string, bool) {
+	val, ok := s[lbSslSecret]
+	return val, ok
+}
+
+func (s serviceAnnotations) getHealthCheck() (string, bool) {
+	val, ok := s[lbHealthCheckKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getHealthCheckPath() (string, bool) {
+	val, ok := s[lbHealthCheckPathKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getHealthCheckInterval() (string, bool) {
+	val, ok := s[lbHealthCheckIntervalKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getHealthCheckRise() (string, bool) {
+	val, ok := s[lbHealthCheckRiseKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getHealthCheckFall() (string, bool) {
+	val, ok := s[lbHealthCheckFallKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getMaxConn() (string, bool) {
+	val, ok := s[lbMaxConnKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getGroup() (string, bool) {
+	val, ok := s[lbGroupKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getWeight() (string, bool) {
+	val, ok := s[lbWeightKey]
+	return val, ok
+}
+
+func (s serviceAnnotations) getExternalName() (string, bool) {
+	val, ok := s[lbExternalNameKey]
+	return val, ok
+}
+
 // Get serves the error page
 func (s *staticPageHandler) Getfunc(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(s.returnCode)
@@ -288,25 +482,16 @@ func (s *staticPageHandler) loadUrl(url string) error {
 	return nil
 }
 
-// write writes the configuration file, will write to stdout if dryRun == true
-func (cfg *loadBalancerConfig) write(services map[string][]service, dryRun bool) (err error) {
-	var w io.Writer
-	if dryRun {
-		w = os.Stdout
-	} else {
-		w, err = os.Create(cfg.Config)
-		if err != nil {
-			return
-		}
-	}
-	var t *template.Template
-	t, err = template.ParseFiles(cfg.Template)
+// render executes the load balancer template for the given services into w.
+func (cfg *loadBalancerConfig) render(services map[string][]service, w io.Writer) error {
+	t, err := template.ParseFiles(cfg.Template)
 	if err != nil {
-		return
+		return err
 	}
 
 	conf := make(map[string]interface{})
 	conf["startSyslog"] = strconv.FormatBool(cfg.startSyslog)
+	conf["runtimeAPI"] = strconv.FormatBool(cfg.runtimeAPI)
 	conf["services"] = services
 
 	var sslConfig string
@@ -317,6 +502,8 @@ func (cfg *loadBalancerConfig) write(services map[string][]service, dryRun bool)
 		sslConfig += " ca-file " + cfg.sslCaCert
 	}
 	conf["sslCert"] = sslConfig
+	conf["crtList"] = cfg.crtList
+	conf["nameservers"] = cfg.nameservers
 
 	// default load balancer algorithm is roundrobin
 	conf["defLbAlgorithm"] = lbDefAlgorithm
@@ -324,8 +511,69 @@ func (cfg *loadBalancerConfig) write(services map[string][]service, dryRun bool)
 		conf["defLbAlgorithm"] = cfg.lbDefAlgorithm
 	}
 
-	err = t.Execute(w, conf)
-	return
+	return t.Execute(w, conf)
+}
+
+// write writes the configuration file, will write to stdout if dryRun == true.
+// The configuration is rendered into a temporary file next to cfg.Config, which
+// is validated and atomically renamed over the live configuration. It returns
+// false, leaving the live configuration untouched, if the rendered content is
+// identical to it and was already reloaded.
+func (cfg *loadBalancerConfig) write(services map[string][]service, dryRun bool) (bool, error) {
+	var buf bytes.Buffer
+	if err := cfg.render(services, &buf); err != nil {
+		return false, err
+	}
+	if dryRun {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return true, err
+	}
+
+	if current, err := ioutil.ReadFile(cfg.Config); err == nil && bytes.Equal(current, buf.Bytes()) {
+		if cfg.reloadPending {
+			return true, nil
+		}
+		reloadsCount.WithLabelValues(cfg.Name, reloadSkipped).Inc()
+		return false, nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(cfg.Config), "."+filepath.Base(cfg.Config))
+	if err != nil {
+		return false, err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = cfg.validate(tmp.Name())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cfg.Config)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	cfg.reloadPending = true
+	return true, nil
+}
+
+// validate checks the configuration file at path using the validate cmd
+// specified in the json manifest. It's a noop if no command was specified.
+func (cfg *loadBalancerConfig) validate(path string) error {
+	if cfg.ValidateCmd == "" {
+		return nil
+	}
+	output, err := exec.Command("sh", "-c", fmt.Sprintf("%v %v", cfg.ValidateCmd, path)).CombinedOutput()
+	if err != nil {
+		reloadsCount.WithLabelValues(cfg.Name, reloadInvalid).Inc()
+		return fmt.Errorf("invalid %v config: %v -- %v", cfg.Name, string(output), err)
+	}
+	return nil
 }
 
 // reload reloads the loadbalancer using the reload cmd specified in the json manifest.
@@ -333,9 +581,12 @@ func (cfg *loadBalancerConfig) reload() error {
 	output, err := exec.Command("sh", "-c", cfg.ReloadCmd).CombinedOutput()
 	msg := fmt.Sprintf("%v -- %v", cfg.Name, string(output))
 	if err != nil {
+		reloadsCount.WithLabelValues(cfg.Name, reloadFailed).Inc()
 		return fmt.Errorf("error restarting %v: %v", msg, err)
 	}
-	glog.Infof(msg)
+	reloadsCount.WithLabelValues(cfg.Name, reloadSuccess).Inc()
+	cfg.reloadPending = false
+	glog.Info(msg)
 	return nil
 }
 
@@ -347,14 +598,28 @@ type loadBalancerController struct {
 	client            *unversioned.Client
 	epController      *framework.Controller
 	svcController     *framework.Controller
+	ingController     *framework.Controller
+	secretController  *framework.Controller
+	nsController      *framework.Controller
 	svcLister         cache.StoreToServiceLister
 	epLister          cache.StoreToEndpointsLister
+	ingLister         cache.Store
+	secretLister      cache.Store
+	nsLister          cache.Store
+	namespaces        sets.String
+	nsSelector        labels.Selector
+	namespacedNames   bool
+	sslCertDir        string
 	reloadRateLimiter util.RateLimiter
 	template          string
 	targetService     string
 	forwardServices   bool
 	tcpServices       map[string]int
+	udpServices       map[string]int
+	udp               loadBalancer
+	recorder          eventRecorder
 	httpPort          int
+	runtime           *runtimeUpdater
 }
 
 // getTargetPort returns the numeric value of TargetPort
@@ -397,14 +662,39 @@ func (lbc *loadBalancerController) getEndpoints(
 	return
 }
 
+// getServiceEndpoints returns the list of <ip>:<port> the load balancer forwards
+// traffic for the given service/port combination to. External services have a
+// single <name>:<port> endpoint.
+func (lbc *loadBalancerController) getServiceEndpoints(
+	s *api.Service, servicePort *api.ServicePort) []string {
+	if name, ok := lbc.getExternalName(s); ok {
+		return []string{net.JoinHostPort(name, strconv.Itoa(getExternalPort(servicePort)))}
+	}
+	// Headless services don't have a vip to forward to.
+	if lbc.forwardServices && s.Spec.ClusterIP != api.ClusterIPNone {
+		return []string{fmt.Sprintf("%v:%v", s.Spec.ClusterIP, servicePort.Port)}
+	}
+	return lbc.getEndpoints(s, servicePort)
+}
+
 // encapsulates all the hacky convenience type name modifications for lb rules.
 // - :80 services don't need a :80 postfix
-// - default ns should be accessible without /ns/name (when we have /ns support)
-func getServiceNameForLBRule(s *api.Service, servicePort int) string {
+// - default ns should be accessible without /ns/name
+// - with --namespaced-names, other namespaces are appended like in dns names
+func (lbc *loadBalancerController) getServiceNameForLBRule(s *api.Service, servicePort int) string {
+	return lbc.getNameForLBRule(s.Name, s.Namespace, servicePort)
+}
+
+// getNameForLBRule returns the name of the backend for name in namespace,
+// following the rules of getServiceNameForLBRule.
+func (lbc *loadBalancerController) getNameForLBRule(name, namespace string, servicePort int) string {
+	if lbc.namespacedNames && namespace != "" && namespace != api.NamespaceDefault {
+		name = fmt.Sprintf("%v.%v", name, namespace)
+	}
 	if servicePort == 80 {
-		return s.Name
+		return name
 	}
-	return fmt.Sprintf("%v:%v", s.Name, servicePort)
+	return fmt.Sprintf("%v:%v", name, servicePort)
 }
 
 // getServices returns a list of services and their endpoints.
@@ -416,30 +706,34 @@ func (lbc *loadBalancerController) getServices() (httpSvc []service, httpsTermSv
 			glog.Infof("Ignoring service %v, it already has a loadbalancer", s.Name)
 			continue
 		}
+		if !lbc.watchesNamespace(s.Namespace) {
+			continue
+		}
+		source := s
 		for _, servicePort := range s.Spec.Ports {
-			// TODO: headless services?
 			sName := s.Name
 			if servicePort.Protocol == api.ProtocolUDP ||
-				(lbc.targetService != "" && lbc.targetService != sName) {
+				(lbc.targetService != "" && !matchesService(lbc.targetService, &s)) {
 				glog.Infof("Ignoring %v: %+v", sName, servicePort)
 				continue
 			}
 
-			if lbc.forwardServices {
-				ep = []string{
-					fmt.Sprintf("%v:%v", s.Spec.ClusterIP, servicePort.Port)}
-			} else {
-				ep = lbc.getEndpoints(&s, &servicePort)
-			}
+			ep = lbc.getServiceEndpoints(&s, &servicePort)
 			if len(ep) == 0 {
 				glog.Infof("No endpoints found for service %v, port %+v",
 					sName, servicePort)
 				continue
 			}
 			newSvc := service{
-				Name:        getServiceNameForLBRule(&s, servicePort.Port),
+				Name:        lbc.getServiceNameForLBRule(&s, servicePort.Port),
 				Ep:          ep,
+				Servers:     endpointServers(ep),
 				BackendPort: getTargetPort(&servicePort),
+				source:      &source,
+			}
+			newSvc.ExternalName, _ = lbc.getExternalName(&s)
+			if newSvc.ExternalName != "" {
+				newSvc.BackendPort = getExternalPort(&servicePort)
 			}
 
 			if val, ok := serviceAnnotations(s.ObjectMeta.Annotations).getHost(); ok {
@@ -472,14 +766,25 @@ func (lbc *loadBalancerController) getServices() (httpSvc []service, httpsTermSv
 				}
 			}
 
+			// A TLS secret implies ssl termination with its certificate.
+			if val, ok := serviceAnnotations(s.ObjectMeta.Annotations).getSslSecret(); ok {
+				newSvc.SslTerm = true
+				newSvc.SslSecret = fmt.Sprintf("%v/%v", s.Namespace, val)
+			}
+
 			if val, ok := serviceAnnotations(s.ObjectMeta.Annotations).getAclMatch(); ok {
 				newSvc.AclMatch = val
 			}
 
-			if port, ok := lbc.tcpServices[sName]; ok && port == servicePort.Port {
+			lbc.setGroup(&s, &newSvc, servicePort.Port)
+
+			if port, ok := lookupServicePort(lbc.tcpServices, &s); ok && port == servicePort.Port {
+				// tcp services are only checked on request
+				lbc.setHealthCheck(&s, &newSvc, false)
 				newSvc.FrontendPort = servicePort.Port
 				tcpSvc = append(tcpSvc, newSvc)
 			} else {
+				lbc.setHealthCheck(&s, &newSvc, true)
 				if val, ok := serviceAnnotations(s.ObjectMeta.Annotations).getCookieStickySession(); ok {
 					b, err := strconv.ParseBool(val)
 					if err == nil {
@@ -498,6 +803,12 @@ func (lbc *loadBalancerController) getServices() (httpSvc []service, httpsTermSv
 		}
 	}
 
+	lbc.ungroupMixedServices(httpSvc, httpsTermSvc, tcpSvc)
+	lbc.ungroupReservedNames(httpSvc, httpsTermSvc, tcpSvc)
+	httpSvc = groupServices(httpSvc)
+	httpsTermSvc = groupServices(httpsTermSvc)
+	tcpSvc = groupServices(tcpSvc)
+
 	sort.Sort(serviceByName(httpSvc))
 	sort.Sort(serviceByName(httpsTermSvc))
 	sort.Sort(serviceByName(tcpSvc))
@@ -505,28 +816,141 @@ func (lbc *loadBalancerController) getServices() (httpSvc []service, httpsTermSv
 	return
 }
 
+// getUDPServices returns the udp services specified in udpServices, and their
+// endpoints.
+func (lbc *loadBalancerController) getUDPServices() (udpSvc []service) {
+	services, _ := lbc.svcLister.List()
+	for _, s := range services.Items {
+		if s.Spec.Type == api.ServiceTypeLoadBalancer || !lbc.watchesNamespace(s.Namespace) {
+			continue
+		}
+		for _, servicePort := range s.Spec.Ports {
+			if servicePort.Protocol != api.ProtocolUDP {
+				continue
+			}
+			if port, ok := lookupServicePort(lbc.udpServices, &s); !ok || port != servicePort.Port {
+				glog.Infof("Ignoring udp %v: %+v", s.Name, servicePort)
+				continue
+			}
+			ep := lbc.getServiceEndpoints(&s, &servicePort)
+			if len(ep) == 0 {
+				glog.Infof("No endpoints found for service %v, port %+v",
+					s.Name, servicePort)
+				continue
+			}
+			newSvc := service{
+				Name:         lbc.getServiceNameForLBRule(&s, servicePort.Port),
+				Ep:           ep,
+				Servers:      endpointServers(ep),
+				BackendPort:  getTargetPort(&servicePort),
+				FrontendPort: servicePort.Port,
+			}
+			udpSvc = append(udpSvc, newSvc)
+			glog.Infof("Found udp service: %+v", newSvc)
+		}
+	}
+	sort.Sort(serviceByName(udpSvc))
+	return
+}
+
+// syncUDP writes the udp services to the udp load balancer, and reloads it if
+// its configuration changed.
+func (lbc *loadBalancerController) syncUDP(dryRun bool) error {
+	changed, err := lbc.udp.write(
+		map[string][]service{
+			"udp": lbc.getUDPServices(),
+		}, dryRun)
+	if err != nil || dryRun || !changed {
+		return err
+	}
+	return lbc.udp.reload()
+}
+
 // sync all services with the loadbalancer.
 func (lbc *loadBalancerController) sync(dryRun bool) error {
-	if !lbc.epController.HasSynced() || !lbc.svcController.HasSynced() {
+	if !lbc.epController.HasSynced() || !lbc.svcController.HasSynced() ||
+		(lbc.ingController != nil && !lbc.ingController.HasSynced()) ||
+		(lbc.secretController != nil && !lbc.secretController.HasSynced()) ||
+		(lbc.nsController != nil && !lbc.nsController.HasSynced()) {
 		time.Sleep(100 * time.Millisecond)
 		return errDeferredSync
 	}
+	// The load balancers are synced independently, so that a broken udp load
+	// balancer doesn't hold back the updates of haproxy. Its error is returned
+	// after haproxy is synced, to retry it.
+	var udpErr error
+	if lbc.udp != nil {
+		if udpErr = lbc.syncUDP(dryRun); udpErr != nil {
+			glog.Errorf("Failed to sync the udp load balancer: %v", udpErr)
+		}
+	}
+	if err := lbc.syncHaproxy(dryRun); err != nil {
+		return err
+	}
+	return udpErr
+}
+
+// syncHaproxy writes the http, https and tcp services to haproxy, and reloads
+// it if its configuration or certificates changed.
+func (lbc *loadBalancerController) syncHaproxy(dryRun bool) error {
 	httpSvc, httpsTermSvc, tcpSvc := lbc.getServices()
+	if lbc.ingLister != nil {
+		httpIng, httpsTermIng := lbc.getIngressServices(backendNames(httpSvc, httpsTermSvc, tcpSvc))
+		httpSvc = append(httpSvc, httpIng...)
+		httpsTermSvc = append(httpsTermSvc, httpsTermIng...)
+		sort.Sort(serviceByName(httpSvc))
+		sort.Sort(serviceByName(httpsTermSvc))
+	}
 	if len(httpSvc) == 0 && len(httpsTermSvc) == 0 && len(tcpSvc) == 0 {
 		return nil
 	}
-	if err := lbc.cfg.write(
-		map[string][]service{
-			"http":      httpSvc,
-			"httpsTerm": httpsTermSvc,
-			"tcp":       tcpSvc,
-		}, dryRun); err != nil {
+	services := lbc.resolveConflicts(map[string][]service{
+		"http":      httpSvc,
+		"httpsTerm": httpsTermSvc,
+		"tcp":       tcpSvc,
+	})
+	if lbc.runtime != nil {
+		lbc.runtime.assign(services)
+	}
+	certsChanged := false
+	if lbc.secretLister != nil && !dryRun {
+		var err error
+		if certsChanged, err = lbc.writeCerts(services); err != nil {
+			return err
+		}
+		if certsChanged {
+			lbc.cfg.reloadPending = true
+		}
+	}
+	changed, err := lbc.cfg.write(services, dryRun)
+	if err != nil {
 		return err
 	}
 	if dryRun {
 		return nil
 	}
-	return lbc.cfg.reload()
+	if !changed {
+		glog.V(2).Infof("No changes in %v config, skipping reload", lbc.cfg.Name)
+		if lbc.runtime != nil && lbc.runtime.loaded == nil {
+			lbc.runtime.setLoaded(services)
+		}
+		return nil
+	}
+	if lbc.runtime != nil && !certsChanged && lbc.runtime.canUpdate(services) {
+		err := lbc.runtime.update(services)
+		if err == nil {
+			lbc.cfg.reloadPending = false
+			return nil
+		}
+		glog.Warningf("Failed to update endpoints through the stats socket, reloading: %v", err)
+	}
+	if err := lbc.cfg.reload(); err != nil {
+		return err
+	}
+	if lbc.runtime != nil {
+		lbc.runtime.setLoaded(services)
+	}
+	return nil
 }
 
 // worker handles the work queue.
@@ -543,7 +967,10 @@ func (lbc *loadBalancerController) worker() {
 }
 
 // newLoadBalancerController creates a new controller from the given config.
-func newLoadBalancerController(cfg *loadBalancerConfig, kubeClient *unversioned.Client, namespace string, tcpServices map[string]int) *loadBalancerController {
+// Services and ingresses are only exposed if their namespace is listed in
+// namespaces, or for all namespaces if the list is empty, and if it matches
+// --namespace-selector.
+func newLoadBalancerController(cfg *loadBalancerConfig, kubeClient *unversioned.Client, namespaces []string, tcpServices, udpServices map[string]int) *loadBalancerController {
 	lbc := loadBalancerController{
 		cfg:    cfg,
 		client: kubeClient,
@@ -554,6 +981,18 @@ func newLoadBalancerController(cfg *loadBalancerConfig, kubeClient *unversioned.
 		forwardServices: *forwardServices,
 		httpPort:        *httpPort,
 		tcpServices:     tcpServices,
+		udpServices:     udpServices,
+		sslCertDir:      *sslCertDir,
+		recorder:        newServiceEventRecorder(kubeClient),
+		namespaces:      sets.NewString(namespaces...),
+		namespacedNames: *namespacedNames,
+	}
+	if cfg.UDP != nil {
+		lbc.udp = cfg.UDP
+	}
+	if *serverSlots > 0 {
+		cfg.runtimeAPI = true
+		lbc.runtime = newRuntimeUpdater(haproxyStatsSocket, *serverSlots)
 	}
 
 	enqueue := func(obj interface{}) {
@@ -565,8 +1004,11 @@ func newLoadBalancerController(cfg *loadBalancerConfig, kubeClient *unversioned.
 		lbc.queue.Add(key)
 	}
 	eventHandlers := framework.ResourceEventHandlerFuncs{
-		AddFunc:    enqueue,
-		DeleteFunc: enqueue,
+		AddFunc: enqueue,
+		DeleteFunc: func(obj interface{}) {
+			lbc.recorder.Forget(obj)
+			enqueue(obj)
+		},
 		UpdateFunc: func(old, cur interface{}) {
 			if !reflect.DeepEqual(old, cur) {
 				enqueue(cur)
@@ -574,6 +1016,24 @@ func newLoadBalancerController(cfg *loadBalancerConfig, kubeClient *unversioned.
 		},
 	}
 
+	// A single namespace is watched directly, otherwise objects are filtered
+	// by watchesNamespace.
+	namespace := api.NamespaceAll
+	if len(namespaces) == 1 && *namespaceSelector == "" {
+		namespace = namespaces[0]
+	}
+	if *namespaceSelector != "" {
+		selector, err := labels.Parse(*namespaceSelector)
+		if err != nil {
+			glog.Fatalf("Invalid namespace selector %v: %v", *namespaceSelector, err)
+		}
+		lbc.nsSelector = selector
+		lbc.nsLister, lbc.nsController = framework.NewInformer(
+			cache.NewListWatchFromClient(
+				lbc.client, "namespaces", api.NamespaceAll, fields.Everything()),
+			&api.Namespace{}, resyncPeriod, eventHandlers)
+	}
+
 	lbc.svcLister.Store, lbc.svcController = framework.NewInformer(
 		cache.NewListWatchFromClient(
 			lbc.client, "services", namespace, fields.Everything()),
@@ -584,6 +1044,44 @@ func newLoadBalancerController(cfg *loadBalancerConfig, kubeClient *unversioned.
 			lbc.client, "endpoints", namespace, fields.Everything()),
 		&api.Endpoints{}, resyncPeriod, eventHandlers)
 
+	if *watchIngress {
+		lbc.ingLister, lbc.ingController = framework.NewInformer(
+			&cache.ListWatch{
+				ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+					return lbc.client.Extensions().Ingress(namespace).List(options)
+				},
+				WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+					return lbc.client.Extensions().Ingress(namespace).Watch(options)
+				},
+			},
+			&extensions.Ingress{}, resyncPeriod, eventHandlers)
+	}
+
+	if lbc.sslCertDir != "" {
+		// Only TLS secrets can be referenced by services.
+		secretHandlers := framework.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if isTLSSecret(obj) {
+					enqueue(obj)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if isTLSSecret(obj) {
+					enqueue(obj)
+				}
+			},
+			UpdateFunc: func(old, cur interface{}) {
+				if isTLSSecret(cur) && !reflect.DeepEqual(old, cur) {
+					enqueue(cur)
+				}
+			},
+		}
+		lbc.secretLister, lbc.secretController = framework.NewInformer(
+			cache.NewListWatchFromClient(
+				lbc.client, "secrets", namespace, fields.Everything()),
+			&api.Secret{}, resyncPeriod, secretHandlers)
+	}
+
 	return &lbc
 }
 
@@ -631,30 +1129,34 @@ func registerHandlers(s *staticPageHandler) {
 		}
 	})
 
+	http.Handle("/metrics", prometheus.Handler())
+
 	// handler for not matched traffic
 	http.HandleFunc("/", s.Getfunc)
 
 	glog.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", lbApiPort), nil))
 }
 
-func parseTCPServices(tcpServices string) map[string]int {
-	tcpSvcs := make(map[string]int)
-	for _, service := range strings.Split(tcpServices, ",") {
+// parseServicePorts parses a comma separated list of serviceName:servicePort
+// pairings of the given protocol.
+func parseServicePorts(services string, protocol api.Protocol) map[string]int {
+	svcs := make(map[string]int)
+	for _, service := range strings.Split(services, ",") {
 		portSplit := strings.Split(service, ":")
 		if len(portSplit) != 2 {
-			glog.Errorf("Ignoring misconfigured TCP service %v", service)
+			glog.Errorf("Ignoring misconfigured %v service %v", protocol, service)
 			continue
 		}
 		if port, err := strconv.Atoi(portSplit[1]); err != nil {
-			glog.Errorf("Ignoring misconfigured TCP service %v: %v", service, err)
+			glog.Errorf("Ignoring misconfigured %v service %v: %v", protocol, service, err)
 			continue
 		} else {
-			glog.Infof("Adding TCP service %v", service)
-			tcpSvcs[portSplit[0]] = port
+			glog.Infof("Adding %v service %v", protocol, service)
+			svcs[portSplit[0]] = port
 		}
 	}
 
-	return tcpSvcs
+	return svcs
 }
 
 func dryRun(lbc *loadBalancerController) {
@@ -683,11 +1185,27 @@ func main() {
 
 	var tcpSvcs map[string]int
 	if *tcpServices != "" {
-		tcpSvcs = parseTCPServices(*tcpServices)
+		tcpSvcs = parseServicePorts(*tcpServices, api.ProtocolTCP)
 	} else {
 		glog.Infof("No tcp/https services specified")
 	}
 
+	var udpSvcs map[string]int
+	if *udpServices != "" {
+		if cfg.UDP == nil {
+			glog.Fatalf("No udp load balancer configured in %v for udp services %v", *config, *udpServices)
+		}
+		udpSvcs = parseServicePorts(*udpServices, api.ProtocolUDP)
+	}
+
+	if *dnsResolvers != "" {
+		if cfg.nameservers, err = parseNameservers(*dnsResolvers); err != nil {
+			glog.Fatalf("Invalid dns resolvers: %v", err)
+		}
+	} else if cfg.nameservers, err = readResolvConf(resolvConf); err != nil {
+		glog.Warningf("Failed to read nameservers from %v, external services are only resolved on reload: %v", resolvConf, err)
+	}
+
 	if *startSyslog {
 		cfg.startSyslog = true
 		_, err = newSyslogServer("/var/run/haproxy.log.socket")
@@ -710,19 +1228,36 @@ func main() {
 		}
 
 	}
-	namespace, specified, err := clientConfig.Namespace()
-	if err != nil {
-		glog.Fatalf("unexpected error: %v", err)
-	}
-	if !specified {
-		namespace = api.NamespaceAll
+	var watchedNamespaces []string
+	if *namespaces != "" {
+		for _, ns := range strings.Split(*namespaces, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				watchedNamespaces = append(watchedNamespaces, ns)
+			}
+		}
+	} else {
+		namespace, specified, err := clientConfig.Namespace()
+		if err != nil {
+			glog.Fatalf("unexpected error: %v", err)
+		}
+		if specified {
+			watchedNamespaces = []string{namespace}
+		}
 	}
 
-	// TODO: Handle multiple namespaces
-	lbc := newLoadBalancerController(cfg, kubeClient, namespace, tcpSvcs)
+	lbc := newLoadBalancerController(cfg, kubeClient, watchedNamespaces, tcpSvcs, udpSvcs)
 
 	go lbc.epController.Run(wait.NeverStop)
 	go lbc.svcController.Run(wait.NeverStop)
+	if lbc.ingController != nil {
+		go lbc.ingController.Run(wait.NeverStop)
+	}
+	if lbc.secretController != nil {
+		go lbc.secretController.Run(wait.NeverStop)
+	}
+	if lbc.nsController != nil {
+		go lbc.nsController.Run(wait.NeverStop)
+	}
 	if *dry {
 		dryRun(lbc)
 	} else {
@@ -22,6 +22,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"k8s.io/kubernetes/pkg/api"
@@ -296,7 +297,7 @@ func compareCfgFiles(t *testing.T, orig, template string) {
 func TestDefaultAlgorithm(t *testing.T) {
 	flb := buildTestLoadBalancer("")
 	httpSvc, _, tcpSvc := flb.getServices()
-	if err := flb.cfg.write(
+	if _, err := flb.cfg.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -311,7 +312,7 @@ func TestDefaultAlgorithm(t *testing.T) {
 func TestDefaultCustomAlgorithm(t *testing.T) {
 	flb := buildTestLoadBalancer("leastconn")
 	httpSvc, _, tcpSvc := flb.getServices()
-	if err := flb.cfg.write(
+	if _, err := flb.cfg.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -327,7 +328,7 @@ func TestSyslog(t *testing.T) {
 	flb := buildTestLoadBalancer("")
 	httpSvc, _, tcpSvc := flb.getServices()
 	flb.cfg.startSyslog = true
-	if err := flb.cfg.write(
+	if _, err := flb.cfg.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -343,7 +344,7 @@ func TestSvcCustomAlgorithm(t *testing.T) {
 	flb := buildTestLoadBalancer("")
 	httpSvc, _, tcpSvc := flb.getServices()
 	httpSvc[0].Algorithm = "leastconn"
-	if err := flb.cfg.write(
+	if _, err := flb.cfg.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -359,7 +360,7 @@ func TestCustomDefaultAndSvcAlgorithm(t *testing.T) {
 	flb := buildTestLoadBalancer("leastconn")
 	httpSvc, _, tcpSvc := flb.getServices()
 	httpSvc[0].Algorithm = "roundrobin"
-	if err := flb.cfg.write(
+	if _, err := flb.cfg.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -375,7 +376,7 @@ func TestServiceAffinity(t *testing.T) {
 	flb := buildTestLoadBalancer("")
 	httpSvc, _, tcpSvc := flb.getServices()
 	httpSvc[0].SessionAffinity = true
-	if err := flb.cfg.write(
+	if _, err := flb.cfg.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -392,7 +393,7 @@ func TestServiceAffinityWithCookies(t *testing.T) {
 	httpSvc, _, tcpSvc := flb.getServices()
 	httpSvc[0].SessionAffinity = true
 	httpSvc[0].CookieStickySession = true
-	if err := flb.cfg.write(
+	if _, err := flb.cfg.write(
 		map[string][]service{
 			"http": httpSvc,
 			"tcp":  tcpSvc,
@@ -403,3 +404,120 @@ func TestServiceAffinityWithCookies(t *testing.T) {
 	compareCfgFiles(t, flb.cfg.Config, template)
 	os.Remove(flb.cfg.Config)
 }
+
+func TestWriteUnchangedConfig(t *testing.T) {
+	flb := buildTestLoadBalancer("")
+	httpSvc, _, tcpSvc := flb.getServices()
+	services := map[string][]service{
+		"http": httpSvc,
+		"tcp":  tcpSvc,
+	}
+	defer os.Remove(flb.cfg.Config)
+	changed, err := flb.cfg.write(services, false)
+	if err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	if !changed {
+		t.Fatalf("Expected the first write to change the config")
+	}
+	changed, err = flb.cfg.write(services, false)
+	if err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	if !changed {
+		t.Fatalf("Expected an identical config to be reported until it is reloaded")
+	}
+	flb.cfg.reloadPending = false
+	changed, err = flb.cfg.write(services, false)
+	if err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	if changed {
+		t.Fatalf("Expected an identical config to be skipped")
+	}
+	httpSvc[0].Algorithm = "leastconn"
+	changed, err = flb.cfg.write(services, false)
+	if err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+	if !changed {
+		t.Fatalf("Expected a different algorithm to change the config")
+	}
+	template, _ := filepath.Abs("./test-samples/TestSvcCustomAlgorithm.cfg")
+	compareCfgFiles(t, flb.cfg.Config, template)
+}
+
+func TestWriteInvalidConfig(t *testing.T) {
+	flb := buildTestLoadBalancer("")
+	httpSvc, _, tcpSvc := flb.getServices()
+	services := map[string][]service{
+		"http": httpSvc,
+		"tcp":  tcpSvc,
+	}
+	defer os.Remove(flb.cfg.Config)
+	if _, err := flb.cfg.write(services, false); err != nil {
+		t.Fatalf("Expected a valid HAProxy cfg, but an error was returned: %v", err)
+	}
+
+	flb.cfg.ValidateCmd = "false"
+	httpSvc[0].Algorithm = "leastconn"
+	if _, err := flb.cfg.write(services, false); err == nil {
+		t.Fatalf("Expected an error from a config that fails validation")
+	}
+	template, _ := filepath.Abs("./test-samples/TestDefaultAlgorithm.cfg")
+	compareCfgFiles(t, flb.cfg.Config, template)
+
+	tmpFiles, _ := filepath.Glob(filepath.Join(filepath.Dir(flb.cfg.Config), "."+filepath.Base(flb.cfg.Config)+"*"))
+	if len(tmpFiles) != 0 {
+		t.Fatalf("Expected the rejected config to be removed, found %v", tmpFiles)
+	}
+}
+
+func TestGetUDPServices(t *testing.T) {
+	endpointAddresses := []api.EndpointAddress{
+		{IP: "1.2.3.4"},
+		{IP: "5.6.7.8"},
+	}
+	endpointPorts := []api.EndpointPort{
+		{Port: 53, Protocol: "UDP", Name: "dns"},
+		{Port: 53, Protocol: "TCP", Name: "dns-tcp"},
+	}
+	servicePorts := []api.ServicePort{
+		{Port: 53, Protocol: api.ProtocolUDP, TargetPort: intstr.FromString("dns")},
+		{Port: 53, Protocol: api.ProtocolTCP, TargetPort: intstr.FromString("dns-tcp")},
+	}
+	svc := getService(servicePorts)
+	svc.ObjectMeta.Name = "dns"
+	endpoints := []*api.Endpoints{getEndpoints(svc, endpointAddresses, endpointPorts)}
+	flb := newFakeLoadBalancerController(endpoints, []*api.Service{svc})
+	cfg, _ := filepath.Abs("./test-samples/loadbalancer_test.json")
+	flb.cfg = parseCfg(cfg, "roundrobin", "", "")
+
+	if udp := flb.getUDPServices(); len(udp) != 0 {
+		t.Fatalf("Expected udp services not in the udp services map to be ignored, got %+v", udp)
+	}
+	flb.udpServices = map[string]int{"dns": 53}
+	udp := flb.getUDPServices()
+	if len(udp) != 1 || udp[0].Name != "dns:53" || udp[0].FrontendPort != 53 {
+		t.Fatalf("Unexpected udp services %+v", udp)
+	}
+	expectedEps := sets.NewString("1.2.3.4:53", "5.6.7.8:53")
+	if receivedEps := sets.NewString(udp[0].Ep...); !receivedEps.Equal(expectedEps) {
+		t.Fatalf("Expected endpoints %v, got %v", expectedEps, receivedEps)
+	}
+
+	udpCfg := &loadBalancerConfig{Name: "nginx", Template: "udp_template.cfg"}
+	var buf bytes.Buffer
+	if err := udpCfg.render(map[string][]service{"udp": udp}, &buf); err != nil {
+		t.Fatalf("Expected a valid nginx cfg, but an error was returned: %v", err)
+	}
+	for _, line := range []string{
+		"server 1.2.3.4:53;",
+		"listen 53 udp;",
+		"proxy_pass udp_53;",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected %q in the rendered config:\n%v", line, buf.String())
+		}
+	}
+}